@@ -19,8 +19,10 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,14 +30,21 @@ import (
 	miwebhook "github.com/stolostron/mtv-integrations/webhook"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/dynamic"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -56,6 +65,8 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(clusterv1.Install(scheme))
+	utilruntime.Must(clusterv1beta1.Install(scheme))
+	utilruntime.Must(addonv1alpha1.Install(scheme))
 	utilruntime.Must(auth.AddToScheme(scheme))
 	utilruntime.Must(forkliftv1beta1.SchemeBuilder.AddToScheme(scheme))
 	utilruntime.Must(authorizationv1.AddToScheme(scheme))
@@ -74,6 +85,26 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var defaultRBACProfile, defaultRBACClusterRole string
+	var defaultEndpointSelection string
+	var defaultConnectionMode, clusterProxyURL, clusterProxyCA string
+	var allowInsecureSkipVerify bool
+	var unavailableGracePeriod time.Duration
+	var tokenValidity time.Duration
+	var annotateUnavailableProviders bool
+	var adoptProviders bool
+	var adoptNamespaces string
+	var enableOrphanGC, orphanGCDryRun bool
+	var orphanGCInterval time.Duration
+	var agentNamespace string
+	var forkliftNamespace string
+	var dryRun bool
+	var enableSharding bool
+	var shards int
+	var shardLeaseNamespace string
+	var dryRunReport string
+	var integrationConfigPath string
+	var integrationFlags controllers.IntegrationConfig
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -96,6 +127,88 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&defaultRBACProfile, "default-rbac-profile", controllers.RBACProfileClusterAdmin,
+		"The RBAC profile granted to the MTV ServiceAccount on clusters that do not select one with the "+
+			controllers.RBACProfileKey+" annotation or label. One of cluster-admin, migration-destination, "+
+			"inventory-read-only or custom.")
+	flag.StringVar(&defaultRBACClusterRole, "default-rbac-clusterrole", "",
+		"The ClusterRole bound by the custom RBAC profile on clusters that do not set "+
+			controllers.RBACClusterRoleKey+".")
+	flag.StringVar(&defaultEndpointSelection, "default-endpoint-selection", "",
+		"The API server endpoint the Provider connects to on clusters that do not select one with the "+
+			controllers.EndpointSelectionKey+" annotation. One of index:<n>, pattern:<regexp> or internal. "+
+			"Defaults to the first client config with a usable URL.")
+	flag.StringVar(&defaultConnectionMode, "default-connection-mode", controllers.ConnectionModeDirect,
+		"How the Providers of clusters without the "+controllers.ConnectionModeKey+" annotation connect to "+
+			"the API server: direct, cluster-proxy or auto, which uses the cluster-proxy when its addon is available.")
+	flag.StringVar(&clusterProxyURL, "cluster-proxy-url", controllers.DefaultClusterProxyURL,
+		"The URL of the cluster-proxy user server the Providers connect through in the cluster-proxy mode.")
+	flag.StringVar(&clusterProxyCA, "cluster-proxy-ca-configmap", controllers.DefaultClusterProxyCAConfigMap,
+		"The <namespace>/<name> of the ConfigMap whose service-ca.crt key holds the CA of the cluster-proxy "+
+			"user server.")
+	flag.BoolVar(&allowInsecureSkipVerify, "allow-insecure-skip-tls-verify", false,
+		"If set, ManagedClusters can disable the TLS verification of their Provider with the "+
+			controllers.InsecureSkipVerifyKey+" annotation. Only use this for lab clusters.")
+	flag.DurationVar(&tokenValidity, "token-validity", controllers.DefaultTokenValidity,
+		"The validity of the ManagedServiceAccount tokens of clusters that do not set the "+
+			controllers.TokenValidityKey+" annotation. Must be at least 10m.")
+	flag.DurationVar(&unavailableGracePeriod, "unavailable-grace-period", 5*time.Minute,
+		"How long a ManagedCluster can be unavailable before its Provider is marked as degraded. "+
+			"0 disables the availability check.")
+	flag.BoolVar(&annotateUnavailableProviders, "annotate-unavailable-providers", false,
+		"If set, the Provider of a degraded cluster is annotated with "+controllers.ClusterUnavailableKey+
+			" and the plan webhook rejects new Plans targeting it.")
+	flag.BoolVar(&adoptProviders, "adopt-providers", false,
+		"If set, an openshift Provider created by hand whose URL is the API server URL of a ManagedCluster is "+
+			"adopted and uses the provider secret of the cluster, instead of creating a second Provider. Only "+
+			"Providers annotated with "+controllers.AdoptKey+"=true in the integration namespace or in the "+
+			"--adopt-provider-namespaces are adopted.")
+	flag.StringVar(&adoptNamespaces, "adopt-provider-namespaces", "",
+		"A comma-separated list of namespaces, besides the integration namespace, whose Providers can be adopted.")
+	flag.BoolVar(&enableOrphanGC, "enable-orphan-gc", false,
+		"If set, the Providers, provider secrets, ClusterPermissions and ManagedServiceAccounts of clusters that "+
+			"no longer exist or are no longer selected are deleted on startup and periodically. Disabled by "+
+			"default, --orphan-gc-dry-run only reports them.")
+	flag.DurationVar(&orphanGCInterval, "orphan-gc-interval", time.Hour,
+		"The interval between two sweeps of the orphaned resources. 0 only sweeps on startup.")
+	flag.BoolVar(&orphanGCDryRun, "orphan-gc-dry-run", false,
+		"If set, the orphaned resources are only reported in the logs and metrics, not deleted.")
+	flag.StringVar(&agentNamespace, "agent-namespace", "",
+		"The namespace of the managed-serviceaccount agent on every managed cluster. If empty, the namespace "+
+			"reported by the managed-serviceaccount ManagedClusterAddOn of each cluster is used.")
+	flag.StringVar(&forkliftNamespace, "forklift-namespace", controllers.DefaultForkliftNamespace,
+		"The namespace Forklift is installed in, where it creates the host provider of the local cluster.")
+	flag.BoolVar(&enableSharding, "enable-sharding", false,
+		"If set, the ManagedClusters are split into hash ranges claimed by the replicas through Leases, and "+
+			"every replica reconciles the clusters of its ranges instead of only the leader reconciling all of them.")
+	flag.IntVar(&shards, "shards", controllers.DefaultShardCount,
+		"The number of hash ranges the ManagedClusters are split into when sharding is enabled.")
+	flag.StringVar(&shardLeaseNamespace, "shard-lease-namespace", "",
+		"The namespace of the sharding Leases. Defaults to the namespace the controller runs in.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"If set, the controller only plans its changes: every write is sent as a server-side dry run and the "+
+			"planned changes are reported in the logs and the dry run report ConfigMap.")
+	flag.StringVar(&dryRunReport, "dry-run-report", "",
+		"The <namespace>/<name> of the dry run report ConfigMap. Defaults to "+
+			controllers.DefaultDryRunReportName+" in the namespace of the controller.")
+	flag.StringVar(&integrationConfigPath, "integration-config", "",
+		"The YAML file with the namespace, provider naming and selection label of the integration. "+
+			"The integration flags override its settings.")
+	flag.StringVar(&integrationFlags.Namespace, "integration-namespace", "",
+		"The namespace of the Providers and provider secrets. Defaults to "+controllers.MTVIntegrationsNamespace+".")
+	flag.StringVar(&integrationFlags.ProviderNamePrefix, "provider-name-prefix", "",
+		"The prefix added to the ManagedCluster name in the names of the resources created for it.")
+	flag.StringVar(&integrationFlags.ProviderNameSuffix, "provider-name-suffix", "",
+		"The suffix added to the ManagedCluster name in the names of the resources created for it. "+
+			"Defaults to "+controllers.DefaultProviderNameSuffix+" when no prefix is set.")
+	flag.StringVar(&integrationFlags.SelectionLabel, "selection-label", "",
+		"The ManagedCluster label that selects the clusters to integrate. Defaults to "+
+			controllers.LabelCNVOperatorInstall+".")
+	flag.StringVar(&integrationFlags.SelectionLabelValue, "selection-label-value", "",
+		"The value of the selection label that selects a cluster. Defaults to true.")
+	flag.StringVar(&integrationFlags.Placement, "placement", "",
+		"The <namespace>/<name> of the OCM Placement whose decisions select the clusters to integrate, "+
+			"instead of the selection label.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -104,6 +217,44 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := controllers.ValidateRBACProfile(defaultRBACProfile, defaultRBACClusterRole); err != nil {
+		setupLog.Error(err, "invalid default RBAC profile")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateEndpointSelection(defaultEndpointSelection); err != nil {
+		setupLog.Error(err, "invalid default API server endpoint selection")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateConnectionMode(defaultConnectionMode); err != nil {
+		setupLog.Error(err, "invalid default connection mode")
+		os.Exit(1)
+	}
+	clusterProxyCAConfigMap, err := controllers.ParseClusterProxyCAConfigMap(clusterProxyCA)
+	if err != nil {
+		setupLog.Error(err, "invalid cluster-proxy CA ConfigMap")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateTokenValidity(tokenValidity); err != nil {
+		setupLog.Error(err, "invalid token validity")
+		os.Exit(1)
+	}
+	integration, err := loadIntegrationConfig(integrationConfigPath, integrationFlags)
+	if err != nil {
+		setupLog.Error(err, "invalid integration configuration")
+		os.Exit(1)
+	}
+	var dryRunReportName types.NamespacedName
+	if dryRunReport != "" {
+		if dryRunReportName, err = controllers.ParseDryRunReport(dryRunReport); err != nil {
+			setupLog.Error(err, "invalid dry run report")
+			os.Exit(1)
+		}
+	}
+	setupLog.Info("Integration configuration", "namespace", integration.Namespace,
+		"providerNamePrefix", integration.ProviderNamePrefix, "providerNameSuffix", integration.ProviderNameSuffix,
+		"selectionLabel", integration.SelectionLabel, "selectionLabelValue", integration.SelectionLabelValue,
+		"placement", integration.Placement)
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -211,6 +362,10 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		LeaderElectionReleaseOnCancel: true,
+		// The managed-serviceaccount agent is the only Deployment the controller reads
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{&appsv1.Deployment{}: controllers.AgentDeploymentCacheConfig()},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -223,21 +378,74 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ManagedClusterReconciler{
+	reconciler := &controllers.ManagedClusterReconciler{
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		DynamicClient: dynamicClient,
-	}).SetupWithManager(mgr); err != nil {
+		Recorder:      mgr.GetEventRecorder("mtv-integrations"),
+
+		DefaultRBACProfile:     defaultRBACProfile,
+		DefaultRBACClusterRole: defaultRBACClusterRole,
+
+		DefaultEndpointSelection: defaultEndpointSelection,
+		DefaultConnectionMode:    defaultConnectionMode,
+		ClusterProxyURL:          clusterProxyURL,
+		ClusterProxyCA:           clusterProxyCAConfigMap,
+		AllowInsecureSkipVerify:  allowInsecureSkipVerify,
+		TokenValidity:            tokenValidity,
+
+		UnavailableGracePeriod:       unavailableGracePeriod,
+		AnnotateUnavailableProviders: annotateUnavailableProviders,
+		AdoptProviders:               adoptProviders,
+		AdoptNamespaces:              controllers.ParseNamespaces(adoptNamespaces),
+
+		AgentNamespace:    agentNamespace,
+		ForkliftNamespace: forkliftNamespace,
+		Integration:       integration,
+
+		DryRun:       dryRun,
+		DryRunReport: dryRunReportName,
+	}
+	if dryRun {
+		if enableSharding {
+			setupLog.Error(nil, "--dry-run cannot be used with --enable-sharding, the dry run report is written "+
+				"by the leader only")
+			os.Exit(1)
+		}
+		setupLog.Info("Dry run mode, the planned changes are reported and not made")
+	}
+	if enableSharding {
+		reconciler.Sharder, err = newSharder(mgr.GetClient(), mgr.GetAPIReader(), shardLeaseNamespace, shards)
+		if err != nil {
+			setupLog.Error(err, "unable to set up the sharding")
+			os.Exit(1)
+		}
+		setupLog.Info("Sharding enabled", "identity", reconciler.Sharder.Identity, "shards", shards,
+			"namespace", reconciler.Sharder.Namespace)
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MTV-ManagedCluster")
 		os.Exit(1)
 	}
 
+	if enableOrphanGC {
+		if err := mgr.Add(&controllers.OrphanCollector{
+			Reconciler: reconciler,
+			Interval:   orphanGCInterval,
+			DryRun:     orphanGCDryRun || dryRun,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the orphan collector")
+			os.Exit(1)
+		}
+	}
+
 	if enableWebhook {
 		if err := mgr.Add(webhookServer); err != nil {
 			os.Exit(1)
 		}
 
-		webhookServer.Register("/validate-plan", miwebhook.ValidateWebhook(mgr.GetClient(), *mgr.GetConfig()))
+		webhookServer.Register("/validate-plan", miwebhook.ValidateWebhook(mgr.GetClient(), *mgr.GetConfig(),
+			integration))
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -272,3 +480,65 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// newSharder returns the Sharder of the replica, with a unique identity. The Leases are created in the
+// namespace the controller runs in when no namespace is set, and read with the uncached reader.
+func newSharder(
+	c client.Client,
+	reader client.Reader,
+	namespace string,
+	shards int,
+) (*controllers.Sharder, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("invalid number of shards %d: must be at least 1", shards)
+	}
+	if namespace == "" {
+		var err error
+		if namespace, err = controllers.PodNamespace(); err != nil {
+			return nil, fmt.Errorf("the shard Lease namespace is not set: %w", err)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &controllers.Sharder{
+		Client:    c,
+		Reader:    reader,
+		Namespace: namespace,
+		Identity:  hostname + "_" + string(uuid.NewUUID()),
+		Shards:    shards,
+	}, nil
+}
+
+// loadIntegrationConfig reads the integration configuration file, when set, and applies the integration flags
+// that are set on top of it
+func loadIntegrationConfig(path string, flags controllers.IntegrationConfig) (controllers.IntegrationConfig, error) {
+	integration := controllers.IntegrationConfig{}
+	if path != "" {
+		var err error
+		if integration, err = controllers.LoadIntegrationConfig(path); err != nil {
+			return integration, err
+		}
+	}
+
+	if flags.Namespace != "" {
+		integration.Namespace = flags.Namespace
+	}
+	if flags.ProviderNamePrefix != "" || flags.ProviderNameSuffix != "" {
+		integration.ProviderNamePrefix = flags.ProviderNamePrefix
+		integration.ProviderNameSuffix = flags.ProviderNameSuffix
+	}
+	if flags.SelectionLabel != "" {
+		integration.SelectionLabel = flags.SelectionLabel
+	}
+	if flags.SelectionLabelValue != "" {
+		integration.SelectionLabelValue = flags.SelectionLabelValue
+	}
+	if flags.Placement != "" {
+		integration.Placement = flags.Placement
+	}
+
+	integration = integration.WithDefaults()
+	return integration, integration.Validate()
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// AdoptedForLabel is the label of a Provider created by hand that the controller adopted for a
+	// ManagedCluster, the value is the name of the cluster. The adopted Provider replaces the Provider the
+	// controller would create, it uses the provider secret of the cluster and is deleted when the cluster is
+	// offboarded.
+	AdoptedForLabel = "mtv-integrations.open-cluster-management.io/adopted-for"
+	// AdoptedProviderKey is the ManagedCluster annotation that tracks the <namespace>/<name> of the Provider
+	// adopted for the cluster
+	AdoptedProviderKey = "mtv-integrations.open-cluster-management.io/adopted-provider"
+	// AdoptKey is the Provider annotation that opts a Provider created by hand in to the adoption when set to
+	// "true". The adoption points the Provider to the provider secret of the cluster, so a Provider is never
+	// adopted without it.
+	AdoptKey = "mtv-integrations.open-cluster-management.io/adopt"
+)
+
+// ParseNamespaces parses a comma-separated list of namespaces, ignoring the spaces and empty entries
+func ParseNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// adoptNamespaces returns the namespaces whose Providers can be adopted, the integration namespace first
+func (r *ManagedClusterReconciler) adoptNamespaces() []string {
+	namespaces := []string{r.integration().Namespace}
+	for _, namespace := range r.AdoptNamespaces {
+		if !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// adoptionAllowed checks if the Provider opted in to the adoption and is in a namespace whose Providers can be
+// adopted. The namespaces are set by the hub administrator, so users who can only create Providers elsewhere
+// cannot get their Provider to use the ManagedServiceAccount token.
+func (r *ManagedClusterReconciler) adoptionAllowed(provider *unstructured.Unstructured) bool {
+	return provider.GetAnnotations()[AdoptKey] == "true" && slices.Contains(r.adoptNamespaces(), provider.GetNamespace())
+}
+
+// sameURL checks if two API server URLs are the same, ignoring the case and a trailing slash
+func sameURL(a, b string) bool {
+	return a != "" && strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// adoptedProvider returns the Provider adopted for the ManagedCluster, or nil when it has none. The Providers
+// adopted before are found even when adopting is disabled, as long as they can still be adopted: a Provider
+// that lost its opt-in annotation, or whose namespace was removed from the adoption namespaces, is left alone.
+func (r *ManagedClusterReconciler) adoptedProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (*unstructured.Unstructured, error) {
+	namespace, name, ok := strings.Cut(managedCluster.GetAnnotations()[AdoptedProviderKey], "/")
+	if !ok {
+		return nil, nil
+	}
+	provider, err := r.getResource(ctx, ProvidersGVR, namespace, name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !r.adoptionAllowed(provider) {
+		log.FromContext(ctx).Info("Ignoring the adopted Provider, it is no longer opted in to the adoption",
+			"provider", name, "namespace", namespace)
+		return nil, nil
+	}
+	return provider, nil
+}
+
+// adoptableProvider returns the Provider created by hand that connects to the API server of the ManagedCluster,
+// or nil when there is none or adopting is disabled. Only the Providers of the adoption namespaces with the
+// opt-in annotation are adopted. A cluster that already has a Provider created by the controller keeps it, and a
+// Provider labeled for the cluster whose adoption was not tracked yet is taken again.
+func (r *ManagedClusterReconciler) adoptableProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (*unstructured.Unstructured, error) {
+	if !r.AdoptProviders {
+		return nil, nil
+	}
+	var providers []unstructured.Unstructured
+	for _, namespace := range r.adoptNamespaces() {
+		list, err := r.listResources(ctx, ProvidersGVR, namespace)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, list.Items...)
+	}
+
+	integration := r.integration()
+	for i := range providers {
+		if providers[i].GetNamespace() == integration.Namespace &&
+			providers[i].GetName() == integration.ResourceName(managedCluster.Name) {
+			return nil, nil
+		}
+	}
+
+	var candidates []*unstructured.Unstructured
+	for i := range providers {
+		provider := &providers[i]
+		if !r.adoptionAllowed(provider) {
+			continue
+		}
+		if owner, owned := managedClusterOf(provider); owned && owner != managedCluster.Name {
+			// The Provider of another cluster
+			continue
+		}
+		if _, ok := integration.ClusterNameForProvider(provider.GetName()); ok &&
+			provider.GetNamespace() == integration.Namespace {
+			// The Provider of another cluster, created before the ownership labels
+			continue
+		}
+		cluster, adopted := provider.GetLabels()[AdoptedForLabel]
+		if cluster == managedCluster.Name {
+			return provider, nil
+		}
+		if adopted || createdByController(provider) {
+			continue
+		}
+		providerType, _, _ := unstructured.NestedString(provider.Object, "spec", "type")
+		url, _, _ := unstructured.NestedString(provider.Object, "spec", payloadKeyURL)
+		if providerType != "openshift" {
+			continue
+		}
+		for _, clientConfig := range managedCluster.Spec.ManagedClusterClientConfigs {
+			if sameURL(url, clientConfig.URL) {
+				candidates = append(candidates, provider)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// Several Providers may connect to the cluster, the same one is adopted on every reconcile
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].GetNamespace()+"/"+candidates[i].GetName() <
+			candidates[j].GetNamespace()+"/"+candidates[j].GetName()
+	})
+	return candidates[0], nil
+}
+
+// adoptedProviderPayload is the Provider payload of an adopted Provider. It keeps the name and namespace of the
+// Provider and points it to the provider secret of the cluster.
+func (r *ManagedClusterReconciler) adoptedProviderPayload(
+	managedCluster *clusterv1.ManagedCluster,
+	provider *unstructured.Unstructured,
+	clusterURL string,
+) map[string]interface{} {
+	integration := r.integration()
+	payload := providerPayload(managedCluster.Name, provider.GetName(), provider.GetNamespace(), clusterURL)
+	metadata := payload[payloadKeyMetadata].(map[string]interface{})
+	metadata["labels"].(map[string]interface{})[AdoptedForLabel] = managedCluster.Name
+	spec := payload["spec"].(map[string]interface{})
+	spec["secret"] = map[string]interface{}{
+		payloadKeyName:      integration.ResourceName(managedCluster.Name),
+		payloadKeyNamespace: integration.Namespace,
+	}
+	return payload
+}
+
+// providerToAdopt returns the Provider adopted for the ManagedCluster, or a Provider created by hand that
+// connects to the cluster with adopting set when it is adopted now. It returns nil when the controller creates
+// the Provider of the cluster.
+func (r *ManagedClusterReconciler) providerToAdopt(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (provider *unstructured.Unstructured, adopting bool, err error) {
+	provider, err = r.adoptedProvider(ctx, managedCluster)
+	if err != nil || provider != nil {
+		return provider, false, err
+	}
+	provider, err = r.adoptableProvider(ctx, managedCluster)
+	return provider, provider != nil, err
+}
+
+// completeAdoption tracks the adopted Provider on the ManagedCluster and audits the adoption
+func (r *ManagedClusterReconciler) completeAdoption(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	previous *unstructured.Unstructured,
+) error {
+	original := managedCluster.DeepCopy()
+	if managedCluster.Annotations == nil {
+		managedCluster.Annotations = map[string]string{}
+	}
+	managedCluster.Annotations[AdoptedProviderKey] = previous.GetNamespace() + "/" + previous.GetName()
+	if err := r.Patch(ctx, managedCluster, client.MergeFrom(original)); err != nil {
+		return err
+	}
+
+	secret, _, _ := unstructured.NestedStringMap(previous.Object, "spec", "secret")
+	log.FromContext(ctx).Info("AUDIT: Adopted the Provider created by hand", "provider", previous.GetName(),
+		"namespace", previous.GetNamespace(), "previousSecret", secret[payloadKeyNamespace]+"/"+secret[payloadKeyName])
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonProviderAdopted, actionOnboard,
+		"Adopted the Provider %s/%s that connects to the cluster, it now uses the provider secret %s/%s",
+		previous.GetNamespace(), previous.GetName(), r.integration().Namespace,
+		r.integration().ResourceName(managedCluster.Name))
+	return nil
+}
+
+// deleteAdoptedProvider deletes the Provider adopted for the ManagedCluster when it is offboarded
+func (r *ManagedClusterReconciler) deleteAdoptedProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) error {
+	adopted, err := r.adoptedProvider(ctx, managedCluster)
+	if err != nil {
+		return err
+	}
+	if adopted != nil {
+		if err := deleteResource(ctx, r.DynamicClient, ProvidersGVR, adopted.GetName(),
+			adopted.GetNamespace()); err != nil {
+			return err
+		}
+	}
+	if _, ok := managedCluster.GetAnnotations()[AdoptedProviderKey]; !ok {
+		return nil
+	}
+	original := managedCluster.DeepCopy()
+	delete(managedCluster.Annotations, AdoptedProviderKey)
+	return r.Patch(ctx, managedCluster, client.MergeFrom(original))
+}
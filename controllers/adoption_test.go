@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// handMadeProvider is a Provider created by a user for the cluster with the URL, opted in to the adoption
+func handMadeProvider(name, namespace, providerType, url string) *unstructured.Unstructured {
+	provider := testProvider(name, namespace)
+	provider.SetAnnotations(map[string]string{AdoptKey: "true"})
+	_ = unstructured.SetNestedField(provider.Object, providerType, "spec", "type")
+	_ = unstructured.SetNestedField(provider.Object, url, "spec", "url")
+	_ = unstructured.SetNestedStringMap(provider.Object, map[string]string{"name": name + "-token",
+		"namespace": namespace}, "spec", "secret")
+	return provider
+}
+
+func adoptionCluster() *clusterv1.ManagedCluster {
+	return &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "c1"},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://api.c1.example.com:6443"}},
+		},
+	}
+}
+
+func adoptionSetup(t *testing.T, objs ...runtime.Object) (*ManagedClusterReconciler, *fake.FakeDynamicClient) {
+	t.Helper()
+	reconciler, dynClient, _ := newTestReconciler(t, withObjects(adoptionCluster()), withDynamicObjects(objs...))
+	reconciler.AdoptProviders = true
+	reconciler.AdoptNamespaces = []string{"team-a", "team-b"}
+	return reconciler, dynClient
+}
+
+func TestAdoptableProvider(t *testing.T) {
+	adoptedElsewhere := handMadeProvider("c1-copy", "team-b", "openshift", "https://api.c1.example.com:6443")
+	adoptedElsewhere.SetLabels(map[string]string{AdoptedForLabel: "c2"})
+	adoptedForCluster := handMadeProvider("c1-copy", "team-b", "openshift", "https://api.c1.example.com:6443")
+	adoptedForCluster.SetLabels(map[string]string{AdoptedForLabel: "c1"})
+	notOptedIn := handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443")
+	notOptedIn.SetAnnotations(nil)
+
+	cases := []struct {
+		name      string
+		providers []runtime.Object
+		disabled  bool
+		want      string
+	}{
+		{
+			name: "same URL",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-a", "openshift", "https://API.c1.example.com:6443/"),
+				handMadeProvider("c2", "team-a", "openshift", "https://api.c2.example.com:6443"),
+			},
+			want: "team-a/c1",
+		},
+		{
+			name: "the first of several Providers",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-b", "openshift", "https://api.c1.example.com:6443"),
+				handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443"),
+			},
+			want: "team-a/c1",
+		},
+		{
+			name: "adopting disabled",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443"),
+			},
+			disabled: true,
+		},
+		{
+			name: "not an openshift Provider",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-a", "vsphere", "https://api.c1.example.com:6443"),
+			},
+		},
+		{
+			name: "labeled for the cluster before its adoption was tracked",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443"),
+				adoptedForCluster,
+			},
+			want: "team-b/c1-copy",
+		},
+		{
+			name:      "not opted in",
+			providers: []runtime.Object{notOptedIn},
+		},
+		{
+			name: "in a namespace whose Providers cannot be adopted",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "tenant", "openshift", "https://api.c1.example.com:6443"),
+			},
+		},
+		{
+			name: "in the integration namespace",
+			providers: []runtime.Object{
+				handMadeProvider("c1", MTVIntegrationsNamespace, "openshift", "https://api.c1.example.com:6443"),
+			},
+			want: MTVIntegrationsNamespace + "/c1",
+		},
+		{
+			name:      "adopted for another cluster",
+			providers: []runtime.Object{adoptedElsewhere},
+		},
+		{
+			name: "the cluster has a Provider created by the controller",
+			providers: []runtime.Object{
+				handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443"),
+				testProvider("c1-mtv", MTVIntegrationsNamespace),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reconciler, _ := adoptionSetup(t, tc.providers...)
+			reconciler.AdoptProviders = !tc.disabled
+			provider, err := reconciler.adoptableProvider(context.TODO(), adoptionCluster())
+			require.NoError(t, err)
+			if tc.want == "" {
+				assert.Nil(t, provider)
+				return
+			}
+			require.NotNil(t, provider)
+			assert.Equal(t, tc.want, provider.GetNamespace()+"/"+provider.GetName())
+		})
+	}
+}
+
+func TestReconcileProviderResources_AdoptsTheProvider(t *testing.T) {
+	provider := handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443")
+	_ = unstructured.SetNestedField(provider.Object, "vcenter", "spec", "settings", "sdkEndpoint")
+	reconciler, dynClient := adoptionSetup(t, provider)
+	recorder := events.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	managedCluster := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "c1"}, managedCluster))
+
+	_, err := reconciler.reconcileProviderResources(context.TODO(), managedCluster, "https://api.c1.example.com:6443")
+	require.NoError(t, err)
+
+	adopted, err := dynClient.Resource(ProvidersGVR).Namespace("team-a").Get(context.TODO(), "c1",
+		metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "c1", adopted.GetLabels()[AdoptedForLabel])
+	secret, _, _ := unstructured.NestedStringMap(adopted.Object, "spec", "secret")
+	assert.Equal(t, map[string]string{"name": "c1-mtv", "namespace": MTVIntegrationsNamespace}, secret)
+	setting, _, _ := unstructured.NestedString(adopted.Object, "spec", "settings", "sdkEndpoint")
+	assert.Equal(t, "vcenter", setting, "the fields set by the users are kept")
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(), "c1-mtv",
+		metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "no second Provider is created")
+	assert.Equal(t, []string{"Normal " + ReasonProviderAdopted}, drainEvents(recorder))
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "c1"}, managedCluster))
+	assert.Equal(t, "team-a/c1", managedCluster.Annotations[AdoptedProviderKey])
+
+	// The adopted Provider is the Provider of the cluster from now on, even with adopting disabled
+	reconciler.AdoptProviders = false
+	_, err = reconciler.reconcileProviderResources(context.TODO(), managedCluster, "https://api.c1.example.com:6443")
+	require.NoError(t, err)
+	assert.Empty(t, drainEvents(recorder))
+	integrationProvider, err := reconciler.integrationProvider(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	require.NotNil(t, integrationProvider)
+	assert.Equal(t, "team-a", integrationProvider.GetNamespace())
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "c1"}}},
+		reconciler.clusterForProvider(context.TODO(), integrationProvider))
+
+	// The adopted Provider is deleted with the other resources of the cluster
+	require.NoError(t, reconciler.deleteManagedClusterResources(context.TODO(), managedCluster))
+	_, err = dynClient.Resource(ProvidersGVR).Namespace("team-a").Get(context.TODO(), "c1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "c1"}, managedCluster))
+	assert.NotContains(t, managedCluster.Annotations, AdoptedProviderKey)
+}
+
+func TestAdoptedProvider_RequiresOptIn(t *testing.T) {
+	provider := handMadeProvider("c1", "team-a", "openshift", "https://api.c1.example.com:6443")
+	provider.SetLabels(map[string]string{AdoptedForLabel: "c1"})
+	reconciler, dynClient := adoptionSetup(t, provider)
+	managedCluster := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "c1"}, managedCluster))
+	managedCluster.Annotations = map[string]string{AdoptedProviderKey: "team-a/c1"}
+	require.NoError(t, reconciler.Update(context.TODO(), managedCluster))
+
+	adopted, err := reconciler.adoptedProvider(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	require.NotNil(t, adopted)
+
+	// A Provider that lost its opt-in annotation is neither reconciled nor deleted
+	provider.SetAnnotations(nil)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace("team-a").Update(context.TODO(), provider,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	adopted, err = reconciler.adoptedProvider(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.Nil(t, adopted)
+
+	// Nor is one in a namespace removed from the adoption namespaces
+	provider.SetAnnotations(map[string]string{AdoptKey: "true"})
+	_, err = dynClient.Resource(ProvidersGVR).Namespace("team-a").Update(context.TODO(), provider,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	reconciler.AdoptNamespaces = nil
+	adopted, err = reconciler.adoptedProvider(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.Nil(t, adopted)
+
+	require.NoError(t, reconciler.deleteAdoptedProvider(context.TODO(), managedCluster))
+	_, err = dynClient.Resource(ProvidersGVR).Namespace("team-a").Get(context.TODO(), "c1", metav1.GetOptions{})
+	assert.NoError(t, err, "the Provider of the users is kept")
+}
+
+func TestParseNamespaces(t *testing.T) {
+	assert.Equal(t, []string{"team-a", "team-b"}, ParseNamespaces(" team-a,,team-b "))
+	assert.Empty(t, ParseNamespaces(""))
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// FieldManager is the server-side apply field manager for every resource the controller writes
+	FieldManager = "mtv-integrations"
+	// legacyFieldManager is the default field manager of the manager binary. Resources created before
+	// the controller used server-side apply are owned by it.
+	legacyFieldManager = "manager"
+)
+
+// payloadToUnstructured round-trips the payload through JSON so that it has the same shape as the
+// objects returned by the API server
+func payloadToUnstructured(payload map[string]interface{}) (*unstructured.Unstructured, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	unstructuredPayload := &unstructured.Unstructured{}
+	if err := json.Unmarshal(payloadJSON, unstructuredPayload); err != nil {
+		return nil, err
+	}
+	return unstructuredPayload, nil
+}
+
+// ownedFieldDrift returns the paths of the fields set in desired whose value differs in live.
+// Fields that are only present in live are owned by other managers and are ignored.
+func ownedFieldDrift(desired, live map[string]interface{}, path string) []string {
+	var drifted []string
+	for key, desiredValue := range desired {
+		fieldPath := key
+		if path != "" {
+			fieldPath = path + "." + key
+		}
+
+		desiredMap, desiredIsMap := desiredValue.(map[string]interface{})
+		liveMap, liveIsMap := live[key].(map[string]interface{})
+		if desiredIsMap && liveIsMap {
+			drifted = append(drifted, ownedFieldDrift(desiredMap, liveMap, fieldPath)...)
+			continue
+		}
+
+		if !equality.Semantic.DeepEqual(desiredValue, live[key]) {
+			drifted = append(drifted, fieldPath)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// applyResource server-side applies the desired object with the controller's field manager. Fields
+// set by other managers are left alone, and fields the controller no longer sets are removed.
+func (r *ManagedClusterReconciler) applyResource(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+	live, desired *unstructured.Unstructured,
+) (*unstructured.Unstructured, error) {
+	log := log.FromContext(ctx)
+	client := r.DynamicClient.Resource(gvr).Namespace(desired.GetNamespace())
+
+	// Move ownership of the fields written by earlier creates and updates to the apply field manager,
+	// otherwise the apply below cannot remove them.
+	upgradePatch, err := csaupgrade.UpgradeManagedFieldsPatch(live,
+		sets.New(FieldManager, legacyFieldManager), FieldManager)
+	if err != nil {
+		log.Error(err, "Failed to compute the managed fields upgrade", gvr.Resource, desired.GetName())
+		return nil, err
+	}
+	if upgradePatch != nil {
+		if _, err := client.Patch(ctx, desired.GetName(), types.JSONPatchType, upgradePatch,
+			metav1.PatchOptions{}); err != nil {
+			log.Error(err, "Failed to upgrade the managed fields", gvr.Resource, desired.GetName())
+			return nil, err
+		}
+	}
+
+	return client.Apply(ctx, desired.GetName(), desired, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+	})
+}
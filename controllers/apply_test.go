@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// addApplyReactor makes the fake dynamic client handle server-side apply of unstructured objects for a
+// single field manager: the applied fields are merged into the live object, and the fields set by the
+// previous create or apply that are no longer applied are removed. Lists are replaced atomically.
+func addApplyReactor(dynClient *fake.FakeDynamicClient) {
+	owned := map[string]map[string]interface{}{}
+	key := func(action clienttesting.Action, name string) string {
+		return action.GetResource().String() + "/" + action.GetNamespace() + "/" + name
+	}
+
+	dynClient.PrependReactor("create", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		obj := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		owned[key(action, obj.GetName())] = obj.DeepCopy().Object
+		return false, nil, nil
+	})
+
+	dynClient.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(clienttesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		applied := map[string]interface{}{}
+		if err := yaml.Unmarshal(patchAction.GetPatch(), &applied); err != nil {
+			return true, nil, err
+		}
+
+		tracker := dynClient.Tracker()
+		obj, err := tracker.Get(patchAction.GetResource(), patchAction.GetNamespace(), patchAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		live := obj.(*unstructured.Unstructured)
+
+		objKey := key(action, patchAction.GetName())
+		pruneReleasedFields(live.Object, owned[objKey], applied)
+		mergeAppliedFields(live.Object, applied)
+		owned[objKey] = applied
+
+		if err := tracker.Update(patchAction.GetResource(), live, patchAction.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, live, nil
+	})
+}
+
+func pruneReleasedFields(live, previous, applied map[string]interface{}) {
+	for key, previousValue := range previous {
+		appliedValue, stillApplied := applied[key]
+		if !stillApplied {
+			delete(live, key)
+			continue
+		}
+		previousMap, previousIsMap := previousValue.(map[string]interface{})
+		appliedMap, appliedIsMap := appliedValue.(map[string]interface{})
+		liveMap, liveIsMap := live[key].(map[string]interface{})
+		if previousIsMap && appliedIsMap && liveIsMap {
+			pruneReleasedFields(liveMap, previousMap, appliedMap)
+		}
+	}
+}
+
+func mergeAppliedFields(live, applied map[string]interface{}) {
+	for key, appliedValue := range applied {
+		appliedMap, appliedIsMap := appliedValue.(map[string]interface{})
+		liveMap, liveIsMap := live[key].(map[string]interface{})
+		if appliedIsMap && liveIsMap {
+			mergeAppliedFields(liveMap, appliedMap)
+			continue
+		}
+		live[key] = appliedValue
+	}
+}
+
+func TestOwnedFieldDrift(t *testing.T) {
+	desired := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"url":  "https://api.new.example.com:6443",
+			"type": "openshift",
+			"secret": map[string]interface{}{
+				"name":      "c1-mtv",
+				"namespace": MTVIntegrationsNamespace,
+			},
+		},
+	}
+
+	t.Run("no drift when only other fields are set", func(t *testing.T) {
+		live := map[string]interface{}{
+			"spec": map[string]interface{}{
+				"url":      "https://api.new.example.com:6443",
+				"type":     "openshift",
+				"settings": map[string]interface{}{"sdkEndpoint": "vcenter"},
+				"secret": map[string]interface{}{
+					"name":      "c1-mtv",
+					"namespace": MTVIntegrationsNamespace,
+				},
+			},
+			"status": map[string]interface{}{"phase": "Ready"},
+		}
+		assert.Empty(t, ownedFieldDrift(desired, live, ""))
+	})
+
+	t.Run("reports changed and missing fields", func(t *testing.T) {
+		live := map[string]interface{}{
+			"spec": map[string]interface{}{
+				"url":  "https://api.old.example.com:6443",
+				"type": "openshift",
+			},
+		}
+		assert.Equal(t, []string{"spec.secret", "spec.url"}, ownedFieldDrift(desired, live, ""))
+	})
+}
+
+func TestReconcileResource_RepairsProviderDrift(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://api.new.example.com:6443"}},
+		},
+	}
+
+	provider, err := payloadToUnstructured(providerPayload(managedCluster.Name, managedClusterMTVName(managedCluster.Name),
+		MTVIntegrationsNamespace, "https://api.new.example.com:6443"))
+	require.NoError(t, err)
+	// Someone edited the URL and another controller added its own setting
+	require.NoError(t, unstructured.SetNestedField(provider.Object, "https://api.old.example.com:6443",
+		"spec", "url"))
+	require.NoError(t, unstructured.SetNestedField(provider.Object, "vcenter", "spec", "settings", "sdkEndpoint"))
+
+	dynClient := fake.NewSimpleDynamicClient(scheme, provider)
+	addApplyReactor(dynClient)
+
+	reconciler := &ManagedClusterReconciler{
+		Client:        clientfake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+	}
+
+	_, _, err = reconciler.reconcileResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace,
+		providerPayload(managedCluster.Name, managedClusterMTVName(managedCluster.Name), MTVIntegrationsNamespace,
+			"https://api.new.example.com:6443"))
+	require.NoError(t, err)
+
+	u, err := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	url, _, _ := unstructured.NestedString(u.Object, "spec", "url")
+	assert.Equal(t, "https://api.new.example.com:6443", url)
+	setting, _, _ := unstructured.NestedString(u.Object, "spec", "settings", "sdkEndpoint")
+	assert.Equal(t, "vcenter", setting, "fields owned by other managers must be kept")
+}
+
+func TestSyncProviderSecret_RepairsURLDrift(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://api.new.example.com:6443"}},
+		},
+	}
+	sourceSecret := &corev1.Secret{
+		Data: map[string][]byte{"token": []byte("token"), "ca.crt": []byte("ca")},
+	}
+	providerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster-mtv",
+			Namespace: MTVIntegrationsNamespace,
+			Labels:    providerSecretLabels(),
+		},
+		Data: map[string][]byte{
+			"insecureSkipVerify": []byte("false"),
+			"url":                []byte("https://api.old.example.com:6443"),
+			"cacert":             []byte("ca"),
+			"token":              []byte("token"),
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(providerSecret).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+
+	require.NoError(t, reconciler.syncProviderSecret(context.TODO(), managedCluster, sourceSecret,
+		"test-cluster-mtv", providerConnection{url: "https://api.new.example.com:6443"}))
+
+	updated := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, updated))
+	assert.Equal(t, "https://api.new.example.com:6443", string(updated.Data["url"]))
+	assert.Equal(t, "token", string(updated.Data["token"]))
+}
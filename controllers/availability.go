@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ClusterUnavailableKey is the Provider annotation set while its ManagedCluster is unavailable for longer
+// than the grace period. The value is the time the cluster became unavailable. The plan webhook rejects
+// new Plans targeting a Provider with the annotation.
+const ClusterUnavailableKey = "mtv-integrations.open-cluster-management.io/cluster-unavailable"
+
+// clusterUnavailableSince returns when the ManagedCluster became unavailable, or the zero time when it is
+// available. A cluster that has not reported its availability yet is considered available.
+func clusterUnavailableSince(managedCluster *clusterv1.ManagedCluster) time.Time {
+	condition := meta.FindStatusCondition(managedCluster.Status.Conditions, clusterv1.ManagedClusterConditionAvailable)
+	if condition == nil || condition.Status == metav1.ConditionTrue {
+		return time.Time{}
+	}
+	return condition.LastTransitionTime.Time
+}
+
+// clusterAvailability checks if the ManagedCluster is unavailable for longer than the grace period. When it is
+// unavailable within the grace period, the time left is returned so the cluster is checked again.
+func (r *ManagedClusterReconciler) clusterAvailability(
+	managedCluster *clusterv1.ManagedCluster,
+	now time.Time,
+) (unavailable bool, graceLeft time.Duration) {
+	since := clusterUnavailableSince(managedCluster)
+	if r.UnavailableGracePeriod <= 0 || since.IsZero() {
+		return false, 0
+	}
+	if graceLeft := since.Add(r.UnavailableGracePeriod).Sub(now); graceLeft > 0 {
+		return false, graceLeft
+	}
+	return true, 0
+}
+
+// wasPaused checks if the last reconcile paused the integration of the ManagedCluster
+func wasPaused(managedCluster *clusterv1.ManagedCluster) bool {
+	condition := meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	return condition != nil && condition.Reason == string(PhaseClusterUnavailable)
+}
+
+// clusterUnavailableError is the error reported in the integration status of a paused ManagedCluster
+func clusterUnavailableError(managedCluster *clusterv1.ManagedCluster) error {
+	return fmt.Errorf("the ManagedCluster is unavailable since %s, the Provider is degraded",
+		clusterUnavailableSince(managedCluster).UTC().Format(time.RFC3339))
+}
+
+// pauseProvider marks the Provider of the unavailable ManagedCluster as degraded
+func (r *ManagedClusterReconciler) pauseProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) error {
+	since := clusterUnavailableSince(managedCluster).UTC().Format(time.RFC3339)
+	provider, err := r.integrationProvider(ctx, managedCluster)
+	if err != nil {
+		return err
+	}
+
+	if provider != nil && r.AnnotateUnavailableProviders && provider.GetAnnotations()[ClusterUnavailableKey] != since {
+		log.FromContext(ctx).Info("Annotating the Provider of the unavailable cluster", "provider", provider.GetName())
+		if err := r.patchProviderAnnotation(ctx, provider, &since); err != nil {
+			return fmt.Errorf("failed to annotate the Provider %s: %w", provider.GetName(), err)
+		}
+	}
+
+	if !wasPaused(managedCluster) && provider != nil {
+		r.recordEvent(provider, corev1.EventTypeWarning, ReasonClusterUnavailable, actionOnboard,
+			"The ManagedCluster %s is unavailable since %s", managedCluster.Name, since)
+	}
+	return nil
+}
+
+// resumeProvider restores the Provider of a ManagedCluster that is available again. The unavailable annotation
+// is removed whenever it is present, as the condition that tells the integration was paused is only written on
+// a best-effort basis and would otherwise leave the webhook rejecting the Plans of the cluster.
+func (r *ManagedClusterReconciler) resumeProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) error {
+	provider, err := r.integrationProvider(ctx, managedCluster)
+	if err != nil {
+		return err
+	}
+	// The annotation is removed even when annotating is disabled, it may have been enabled before
+	if provider != nil {
+		if _, ok := provider.GetAnnotations()[ClusterUnavailableKey]; ok {
+			log.FromContext(ctx).Info("Removing the unavailable annotation of the Provider", "provider",
+				provider.GetName())
+			if err := r.patchProviderAnnotation(ctx, provider, nil); err != nil {
+				return fmt.Errorf("failed to remove the annotation of the Provider %s: %w", provider.GetName(), err)
+			}
+		}
+	}
+	if !wasPaused(managedCluster) {
+		return nil
+	}
+
+	if provider != nil {
+		r.recordEvent(provider, corev1.EventTypeNormal, ReasonClusterAvailable, actionOnboard,
+			"The ManagedCluster %s is available again", managedCluster.Name)
+	}
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonClusterAvailable, actionOnboard,
+		"The ManagedCluster is available again, resuming the integration")
+	return nil
+}
+
+// integrationProvider returns the Provider of the ManagedCluster, or the Provider adopted for it, or nil when
+// neither exists
+func (r *ManagedClusterReconciler) integrationProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (*unstructured.Unstructured, error) {
+	integration := r.integration()
+	provider, err := r.getResource(ctx, ProvidersGVR, integration.Namespace,
+		integration.ResourceName(managedCluster.Name))
+	if errors.IsNotFound(err) {
+		return r.adoptedProvider(ctx, managedCluster)
+	}
+	return provider, err
+}
+
+// patchProviderAnnotation sets the unavailable annotation of the Provider, or removes it when since is nil
+func (r *ManagedClusterReconciler) patchProviderAnnotation(
+	ctx context.Context,
+	provider *unstructured.Unstructured,
+	since *string,
+) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{ClusterUnavailableKey: since},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.DynamicClient.Resource(ProvidersGVR).Namespace(provider.GetNamespace()).Patch(ctx,
+		provider.GetName(), types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func availableCondition(status metav1.ConditionStatus, since time.Time) metav1.Condition {
+	return metav1.Condition{
+		Type:               clusterv1.ManagedClusterConditionAvailable,
+		Status:             status,
+		Reason:             "ManagedClusterLeaseUpdateStopped",
+		LastTransitionTime: metav1.NewTime(since),
+	}
+}
+
+func TestClusterAvailability(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name            string
+		gracePeriod     time.Duration
+		conditions      []metav1.Condition
+		wantUnavailable bool
+		wantGraceLeft   time.Duration
+	}{
+		{name: "not reported yet", gracePeriod: 5 * time.Minute},
+		{
+			name:        "available",
+			gracePeriod: 5 * time.Minute,
+			conditions:  []metav1.Condition{availableCondition(metav1.ConditionTrue, now.Add(-time.Hour))},
+		},
+		{
+			name:          "unknown within the grace period",
+			gracePeriod:   5 * time.Minute,
+			conditions:    []metav1.Condition{availableCondition(metav1.ConditionUnknown, now.Add(-time.Minute))},
+			wantGraceLeft: 4 * time.Minute,
+		},
+		{
+			name:            "unavailable past the grace period",
+			gracePeriod:     5 * time.Minute,
+			conditions:      []metav1.Condition{availableCondition(metav1.ConditionFalse, now.Add(-time.Hour))},
+			wantUnavailable: true,
+		},
+		{
+			name:       "check disabled",
+			conditions: []metav1.Condition{availableCondition(metav1.ConditionFalse, now.Add(-time.Hour))},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reconciler := &ManagedClusterReconciler{UnavailableGracePeriod: tc.gracePeriod}
+			managedCluster := &clusterv1.ManagedCluster{
+				Status: clusterv1.ManagedClusterStatus{Conditions: tc.conditions},
+			}
+			unavailable, graceLeft := reconciler.clusterAvailability(managedCluster, now)
+			assert.Equal(t, tc.wantUnavailable, unavailable)
+			// The condition time is truncated to seconds
+			assert.InDelta(t, tc.wantGraceLeft.Seconds(), graceLeft.Seconds(), 1)
+		})
+	}
+}
+
+func TestReconcile_DegradesProviderOfUnavailableCluster(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Labels:     map[string]string{LabelCNVOperatorInstall: "true"},
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+		Status: clusterv1.ManagedClusterStatus{
+			Conditions: []metav1.Condition{
+				availableCondition(metav1.ConditionUnknown, time.Now().Add(-10*time.Minute)),
+			},
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster, deployment).Build()
+	dynClient := fake.NewSimpleDynamicClient(scheme, testProvider("test-cluster-mtv", MTVIntegrationsNamespace))
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{
+		Client:                       k8sClient,
+		Scheme:                       scheme,
+		DynamicClient:                dynClient,
+		Recorder:                     recorder,
+		UnavailableGracePeriod:       5 * time.Minute,
+		AnnotateUnavailableProviders: true,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+	providers := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace)
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	condition := integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseClusterUnavailable), condition.Reason)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	provider, err := providers.Get(context.TODO(), "test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, provider.GetAnnotations(), ClusterUnavailableKey)
+	assert.ElementsMatch(t, []string{"Warning " + ReasonClusterUnavailable, "Warning " + ReasonClusterUnavailable},
+		drainEvents(recorder), "the ManagedCluster and the Provider get the event")
+
+	// The cluster is back
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	managedCluster.Status.Conditions[0] = availableCondition(metav1.ConditionTrue, time.Now())
+	require.NoError(t, k8sClient.Status().Update(context.TODO(), managedCluster))
+
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	provider, err = providers.Get(context.TODO(), "test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, provider.GetAnnotations(), ClusterUnavailableKey)
+	assert.Contains(t, drainEvents(recorder), "Normal "+ReasonClusterAvailable)
+	condition = integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.NotEqual(t, string(PhaseClusterUnavailable), condition.Reason)
+}
+
+func TestReconcile_RequeuesAtTheEndOfTheGracePeriod(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-cluster",
+			Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+		},
+		Status: clusterv1.ManagedClusterStatus{
+			Conditions: []metav1.Condition{availableCondition(metav1.ConditionFalse, time.Now().Add(-time.Minute))},
+		},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster).Build()
+	reconciler := &ManagedClusterReconciler{
+		Client:                 k8sClient,
+		Scheme:                 scheme,
+		DynamicClient:          fake.NewSimpleDynamicClient(scheme),
+		UnavailableGracePeriod: 5 * time.Minute,
+	}
+
+	result, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+	assert.InDelta(t, (4 * time.Minute).Seconds(), result.RequeueAfter.Seconds(), 1)
+}
+
+func TestResumeProvider_RemovesAnnotationWithoutPausedCondition(t *testing.T) {
+	// The status patch that reported the cluster as unavailable failed, the Provider is still annotated
+	provider := testProvider("test-cluster-mtv", MTVIntegrationsNamespace)
+	provider.SetAnnotations(map[string]string{ClusterUnavailableKey: "2026-01-02T03:04:05Z"})
+	dynClient := fake.NewSimpleDynamicClient(runtime.NewScheme(), provider)
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{DynamicClient: dynClient, Recorder: recorder}
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+
+	require.NoError(t, reconciler.resumeProvider(context.TODO(), managedCluster))
+	updated, err := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, updated.GetAnnotations(), ClusterUnavailableKey)
+	assert.Empty(t, drainEvents(recorder), "the integration was not reported as paused")
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// ConnectionModeKey is the ManagedCluster annotation that selects how the Provider connects to the API server
+// of the cluster. The value is one of:
+//   - direct connects to the API server URL of a client config of the cluster
+//   - cluster-proxy connects through the user server of the OCM cluster-proxy addon
+//   - auto connects through the cluster-proxy when its addon is available on the cluster, directly otherwise
+//
+// Without the annotation, the hub-wide default applies, which is direct unless configured otherwise.
+const ConnectionModeKey = "mtv-integrations.open-cluster-management.io/connection-mode"
+
+const (
+	ConnectionModeDirect       = "direct"
+	ConnectionModeClusterProxy = "cluster-proxy"
+	ConnectionModeAuto         = "auto"
+
+	// DefaultClusterProxyURL is the URL of the cluster-proxy user server in a multicluster engine installation
+	DefaultClusterProxyURL = "https://cluster-proxy-addon-user.multicluster-engine.svc:9092"
+	// DefaultClusterProxyCAConfigMap is the <namespace>/<name> of the ConfigMap with the CA that signed the
+	// certificate of the cluster-proxy user server, the OpenShift service CA by default
+	DefaultClusterProxyCAConfigMap = "multicluster-engine/openshift-service-ca.crt"
+	// clusterProxyCAKey is the key of the CA in the cluster-proxy CA ConfigMap
+	clusterProxyCAKey = "service-ca.crt"
+
+	// clusterProxyAddonName is the name of the ManagedClusterAddOn of the cluster-proxy addon
+	clusterProxyAddonName = "cluster-proxy"
+)
+
+// ValidateConnectionMode checks a hub-wide connection mode, empty is direct
+func ValidateConnectionMode(mode string) error {
+	switch mode {
+	case "", ConnectionModeDirect, ConnectionModeClusterProxy, ConnectionModeAuto:
+		return nil
+	}
+	return fmt.Errorf("invalid connection mode %q: must be %s, %s or %s", mode, ConnectionModeDirect,
+		ConnectionModeClusterProxy, ConnectionModeAuto)
+}
+
+// ParseClusterProxyCAConfigMap parses the <namespace>/<name> of the cluster-proxy CA ConfigMap
+func ParseClusterProxyCAConfigMap(value string) (types.NamespacedName, error) {
+	namespace, name, found := strings.Cut(value, "/")
+	if !found || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("invalid cluster-proxy CA ConfigMap %q: must be <namespace>/<name>",
+			value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// providerEndpoint is the API server endpoint the Provider of a ManagedCluster connects to
+type providerEndpoint struct {
+	clusterv1.ClientConfig
+	// viaClusterProxy is set when the endpoint is the cluster-proxy user server. Its CA replaces the CA of
+	// the cluster.
+	viaClusterProxy bool
+}
+
+// connectionMode returns the connection mode of the ManagedCluster, from its annotation or the hub-wide default
+func (r *ManagedClusterReconciler) connectionMode(managedCluster *clusterv1.ManagedCluster) (string, error) {
+	mode, ok := managedCluster.GetAnnotations()[ConnectionModeKey]
+	if !ok {
+		mode = r.DefaultConnectionMode
+	}
+	if err := ValidateConnectionMode(mode); err != nil {
+		return "", err
+	}
+	if mode == "" {
+		mode = ConnectionModeDirect
+	}
+	return mode, nil
+}
+
+// clusterProxyAvailable checks if the cluster-proxy addon is available on the ManagedCluster
+func (r *ManagedClusterReconciler) clusterProxyAvailable(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (bool, error) {
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	err := r.Get(ctx, types.NamespacedName{Name: clusterProxyAddonName, Namespace: managedCluster.Name}, addon)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return meta.IsStatusConditionTrue(addon.Status.Conditions, addonv1alpha1.ManagedClusterAddOnConditionAvailable),
+		nil
+}
+
+// providerEndpoint returns the endpoint the Provider of the ManagedCluster connects to, the cluster-proxy user
+// server or the selected client config of the cluster
+func (r *ManagedClusterReconciler) providerEndpoint(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (*providerEndpoint, error) {
+	mode, err := r.connectionMode(managedCluster)
+	if err != nil {
+		return nil, err
+	}
+	if mode == ConnectionModeAuto {
+		available, err := r.clusterProxyAvailable(ctx, managedCluster)
+		if err != nil {
+			return nil, err
+		}
+		mode = ConnectionModeDirect
+		if available {
+			mode = ConnectionModeClusterProxy
+		}
+	}
+
+	if mode == ConnectionModeClusterProxy {
+		return r.clusterProxyEndpoint(ctx, managedCluster)
+	}
+	clientConfig, err := r.clusterEndpoint(managedCluster)
+	if err != nil {
+		return nil, err
+	}
+	return &providerEndpoint{ClientConfig: *clientConfig}, nil
+}
+
+// clusterProxyEndpoint returns the cluster-proxy user server endpoint of the ManagedCluster, with the CA of
+// the user server
+func (r *ManagedClusterReconciler) clusterProxyEndpoint(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (*providerEndpoint, error) {
+	proxyURL := r.ClusterProxyURL
+	if proxyURL == "" {
+		proxyURL = DefaultClusterProxyURL
+	}
+	caConfigMap := r.ClusterProxyCA
+	if caConfigMap.Name == "" {
+		caConfigMap, _ = ParseClusterProxyCAConfigMap(DefaultClusterProxyCAConfigMap)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, caConfigMap, configMap); err != nil {
+		return nil, fmt.Errorf("failed to get the cluster-proxy CA ConfigMap %s: %w", caConfigMap, err)
+	}
+	ca := configMap.Data[clusterProxyCAKey]
+	if ca == "" {
+		return nil, fmt.Errorf("the cluster-proxy CA ConfigMap %s has no %s key", caConfigMap, clusterProxyCAKey)
+	}
+
+	return &providerEndpoint{
+		ClientConfig: clusterv1.ClientConfig{
+			URL:      strings.TrimSuffix(proxyURL, "/") + "/" + managedCluster.Name,
+			CABundle: []byte(ca),
+		},
+		viaClusterProxy: true,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateConnectionMode(t *testing.T) {
+	for _, mode := range []string{"", ConnectionModeDirect, ConnectionModeClusterProxy, ConnectionModeAuto} {
+		assert.NoError(t, ValidateConnectionMode(mode), mode)
+	}
+	assert.Error(t, ValidateConnectionMode("tunnel"))
+}
+
+func TestParseClusterProxyCAConfigMap(t *testing.T) {
+	configMap, err := ParseClusterProxyCAConfigMap(DefaultClusterProxyCAConfigMap)
+	require.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "multicluster-engine", Name: "openshift-service-ca.crt"}, configMap)
+
+	for _, invalid := range []string{"openshift-service-ca.crt", "/ca", "multicluster-engine/"} {
+		_, err := ParseClusterProxyCAConfigMap(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func clusterProxySetup(t *testing.T, objects ...runtime.Object) *ManagedClusterReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	proxyCA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "openshift-service-ca.crt", Namespace: "multicluster-engine"},
+		Data:       map[string]string{"service-ca.crt": "proxy-ca"},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithRuntimeObjects(append(objects, proxyCA)...).Build()
+	return &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+}
+
+func clusterProxyAddon(available metav1.ConditionStatus) *addonv1alpha1.ManagedClusterAddOn {
+	return &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-proxy", Namespace: "test-cluster"},
+		Status: addonv1alpha1.ManagedClusterAddOnStatus{
+			Conditions: []metav1.Condition{{Type: "Available", Status: available, Reason: "Tested"}},
+		},
+	}
+}
+
+func TestProviderEndpoint(t *testing.T) {
+	direct := "https://api.example.com:6443"
+	proxied := "https://cluster-proxy-addon-user.multicluster-engine.svc:9092/test-cluster"
+
+	cases := []struct {
+		name        string
+		defaultMode string
+		annotation  string
+		objects     []runtime.Object
+		wantURL     string
+		wantErr     bool
+	}{
+		{name: "direct by default", wantURL: direct},
+		{name: "cluster-proxy by annotation", annotation: ConnectionModeClusterProxy, wantURL: proxied},
+		{name: "cluster-proxy by default", defaultMode: ConnectionModeClusterProxy, wantURL: proxied},
+		{
+			name:        "annotation overrides the default",
+			defaultMode: ConnectionModeClusterProxy,
+			annotation:  ConnectionModeDirect,
+			wantURL:     direct,
+		},
+		{
+			name:       "auto with the addon available",
+			annotation: ConnectionModeAuto,
+			objects:    []runtime.Object{clusterProxyAddon(metav1.ConditionTrue)},
+			wantURL:    proxied,
+		},
+		{
+			name:       "auto with the addon unavailable",
+			annotation: ConnectionModeAuto,
+			objects:    []runtime.Object{clusterProxyAddon(metav1.ConditionFalse)},
+			wantURL:    direct,
+		},
+		{name: "auto without the addon", annotation: ConnectionModeAuto, wantURL: direct},
+		{name: "invalid annotation", annotation: "tunnel", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reconciler := clusterProxySetup(t, tc.objects...)
+			reconciler.DefaultConnectionMode = tc.defaultMode
+			managedCluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+				Spec: clusterv1.ManagedClusterSpec{
+					ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: direct, CABundle: []byte("cluster-ca")}},
+				},
+			}
+			if tc.annotation != "" {
+				managedCluster.SetAnnotations(map[string]string{ConnectionModeKey: tc.annotation})
+			}
+
+			endpoint, err := reconciler.providerEndpoint(context.TODO(), managedCluster)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantURL, endpoint.URL)
+			assert.Equal(t, tc.wantURL == proxied, endpoint.viaClusterProxy)
+		})
+	}
+}
+
+func TestHandleProviderSecrets_ClusterProxy(t *testing.T) {
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token"), "ca.crt": []byte("cluster-ca")},
+	}
+	reconciler := clusterProxySetup(t, tokenSecret)
+	reconciler.ClusterProxyURL = "https://proxy.example.com/"
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-cluster",
+			Annotations: map[string]string{ConnectionModeKey: ConnectionModeClusterProxy},
+		},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Status:     auth.ManagedServiceAccountStatus{TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv"}},
+	}
+
+	// A cluster behind NAT has no client config, the cluster-proxy does not need one
+	endpoint, err := reconciler.providerEndpoint(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	synced, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+	require.NoError(t, err)
+	assert.True(t, synced)
+
+	providerSecret := providerSecretOf(t, reconciler)
+	assert.Equal(t, "https://proxy.example.com/test-cluster", string(providerSecret.Data["url"]))
+	assert.Equal(t, "proxy-ca", string(providerSecret.Data["cacert"]))
+	assert.Equal(t, "test-token", string(providerSecret.Data["token"]))
+}
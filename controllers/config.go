@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultProviderNameSuffix is the suffix of the resources created for a ManagedCluster when no naming is
+// configured
+const DefaultProviderNameSuffix = "-mtv"
+
+// podNamespacePath holds the namespace of the pod when running in a cluster
+var podNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// PodNamespace returns the namespace the controller runs in
+func PodNamespace() (string, error) {
+	data, err := os.ReadFile(podNamespacePath)
+	if err != nil {
+		return "", fmt.Errorf("not running in a cluster: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// IntegrationConfig is the naming and selection convention of the integration. The controller and the
+// Plan webhook must use the same configuration.
+type IntegrationConfig struct {
+	// Namespace is where the Providers and provider secrets are created
+	Namespace string `json:"namespace,omitempty"`
+	// ProviderNamePrefix and ProviderNameSuffix surround the ManagedCluster name in the names of the
+	// Provider, provider secret, ManagedServiceAccount and ClusterPermission
+	ProviderNamePrefix string `json:"providerNamePrefix,omitempty"`
+	ProviderNameSuffix string `json:"providerNameSuffix,omitempty"`
+	// SelectionLabel is the ManagedCluster label that selects the clusters to integrate when it has the
+	// SelectionLabelValue value
+	SelectionLabel      string `json:"selectionLabel,omitempty"`
+	SelectionLabelValue string `json:"selectionLabelValue,omitempty"`
+	// Placement is the <namespace>/<name> of an OCM Placement. When it is set, the clusters in its
+	// PlacementDecisions are integrated instead of the clusters with the selection label.
+	Placement string `json:"placement,omitempty"`
+}
+
+// DefaultIntegrationConfig is the configuration of installations that do not configure the integration
+func DefaultIntegrationConfig() IntegrationConfig {
+	return IntegrationConfig{
+		Namespace:           MTVIntegrationsNamespace,
+		ProviderNameSuffix:  DefaultProviderNameSuffix,
+		SelectionLabel:      LabelCNVOperatorInstall,
+		SelectionLabelValue: cnvOperatorInstallEnabled,
+	}
+}
+
+// LoadIntegrationConfig reads the configuration from a YAML file. Settings missing from the file keep
+// their default.
+func LoadIntegrationConfig(path string) (IntegrationConfig, error) {
+	config := IntegrationConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read the integration config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse the integration config %s: %w", path, err)
+	}
+	return config.WithDefaults(), nil
+}
+
+// WithDefaults returns the configuration with the defaults for the settings that are not set. The
+// default suffix is only used when neither a prefix nor a suffix is set.
+func (c IntegrationConfig) WithDefaults() IntegrationConfig {
+	defaults := DefaultIntegrationConfig()
+	if c.Namespace == "" {
+		c.Namespace = defaults.Namespace
+	}
+	if c.ProviderNamePrefix == "" && c.ProviderNameSuffix == "" {
+		c.ProviderNameSuffix = defaults.ProviderNameSuffix
+	}
+	if c.SelectionLabel == "" {
+		c.SelectionLabel = defaults.SelectionLabel
+	}
+	if c.SelectionLabelValue == "" {
+		c.SelectionLabelValue = defaults.SelectionLabelValue
+	}
+	return c
+}
+
+// Validate checks that the configuration produces valid resource names and labels
+func (c IntegrationConfig) Validate() error {
+	if errs := validation.IsDNS1123Label(c.Namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid integration namespace %q: %s", c.Namespace, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(c.ResourceName("cluster")); len(errs) > 0 {
+		return fmt.Errorf("invalid provider name prefix %q or suffix %q: %s", c.ProviderNamePrefix,
+			c.ProviderNameSuffix, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsQualifiedName(c.SelectionLabel); len(errs) > 0 {
+		return fmt.Errorf("invalid selection label %q: %s", c.SelectionLabel, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(c.SelectionLabelValue); len(errs) > 0 {
+		return fmt.Errorf("invalid selection label value %q: %s", c.SelectionLabelValue, strings.Join(errs, ", "))
+	}
+	if c.Placement != "" {
+		if _, err := parsePlacement(c.Placement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResourceName is the name of the resources created for the ManagedCluster
+func (c IntegrationConfig) ResourceName(managedClusterName string) string {
+	return c.ProviderNamePrefix + managedClusterName + c.ProviderNameSuffix
+}
+
+// ClusterNameForProvider returns the ManagedCluster a Provider name was created for, and false when the
+// name does not follow the naming of the integration
+func (c IntegrationConfig) ClusterNameForProvider(providerName string) (string, bool) {
+	if len(providerName) <= len(c.ProviderNamePrefix)+len(c.ProviderNameSuffix) ||
+		!strings.HasPrefix(providerName, c.ProviderNamePrefix) || !strings.HasSuffix(providerName, c.ProviderNameSuffix) {
+		return "", false
+	}
+	return providerName[len(c.ProviderNamePrefix) : len(providerName)-len(c.ProviderNameSuffix)], true
+}
+
+// Selected checks if the ManagedCluster carries the selection label. It does not apply to a configuration
+// with a Placement.
+func (c IntegrationConfig) Selected(managedCluster *clusterv1.ManagedCluster) bool {
+	return managedCluster.GetLabels()[c.SelectionLabel] == c.SelectionLabelValue
+}
+
+// integration returns the configuration of the reconciler, an unset configuration is the default one
+func (r *ManagedClusterReconciler) integration() IntegrationConfig {
+	return r.Integration.WithDefaults()
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func TestIntegrationConfig_WithDefaults(t *testing.T) {
+	assert.Equal(t, DefaultIntegrationConfig(), IntegrationConfig{}.WithDefaults())
+
+	// A prefix replaces the default suffix
+	config := IntegrationConfig{Namespace: "migrations", ProviderNamePrefix: "acm-"}.WithDefaults()
+	assert.Equal(t, "migrations", config.Namespace)
+	assert.Equal(t, "acm-c1", config.ResourceName("c1"))
+	assert.Equal(t, LabelCNVOperatorInstall, config.SelectionLabel)
+}
+
+func TestIntegrationConfig_Validate(t *testing.T) {
+	assert.NoError(t, DefaultIntegrationConfig().Validate())
+	assert.Error(t, IntegrationConfig{Namespace: "Migrations"}.WithDefaults().Validate())
+	assert.Error(t, IntegrationConfig{ProviderNameSuffix: "_mtv"}.WithDefaults().Validate())
+	assert.Error(t, IntegrationConfig{SelectionLabel: "example.com/mtv/enabled"}.WithDefaults().Validate())
+	assert.Error(t, IntegrationConfig{SelectionLabelValue: "not valid"}.WithDefaults().Validate())
+}
+
+func TestIntegrationConfig_ClusterNameForProvider(t *testing.T) {
+	cases := []struct {
+		name         string
+		config       IntegrationConfig
+		providerName string
+		wantCluster  string
+		wantManaged  bool
+	}{
+		{"default suffix", DefaultIntegrationConfig(), "c1-mtv", "c1", true},
+		{"not managed", DefaultIntegrationConfig(), "vcenter", "", false},
+		{"only the suffix", DefaultIntegrationConfig(), "-mtv", "", false},
+		{"prefix and suffix", IntegrationConfig{ProviderNamePrefix: "acm-", ProviderNameSuffix: "-mtv"},
+			"acm-c1-mtv", "c1", true},
+		{"overlapping prefix and suffix", IntegrationConfig{ProviderNamePrefix: "acm-", ProviderNameSuffix: "-mtv"},
+			"acm-mtv", "", false},
+		{"prefix only", IntegrationConfig{ProviderNamePrefix: "acm-"}, "acm-c1-mtv", "c1-mtv", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterName, managed := tc.config.ClusterNameForProvider(tc.providerName)
+			assert.Equal(t, tc.wantManaged, managed)
+			assert.Equal(t, tc.wantCluster, clusterName)
+		})
+	}
+}
+
+func TestIntegrationConfig_Selected(t *testing.T) {
+	config := IntegrationConfig{SelectionLabel: "example.com/mtv", SelectionLabelValue: "enabled"}.WithDefaults()
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+		Name:   "c1",
+		Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+	}}
+	assert.False(t, config.Selected(managedCluster))
+
+	managedCluster.Labels["example.com/mtv"] = "enabled"
+	assert.True(t, config.Selected(managedCluster))
+}
+
+func TestLoadIntegrationConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "integration.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("namespace: migrations\nproviderNamePrefix: acm-\n"), 0o600))
+
+	config, err := LoadIntegrationConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, IntegrationConfig{
+		Namespace:           "migrations",
+		ProviderNamePrefix:  "acm-",
+		SelectionLabel:      LabelCNVOperatorInstall,
+		SelectionLabelValue: "true",
+	}, config)
+
+	require.NoError(t, os.WriteFile(path, []byte("namespaces: migrations\n"), 0o600))
+	_, err = LoadIntegrationConfig(path)
+	assert.Error(t, err, "unknown settings must be rejected")
+}
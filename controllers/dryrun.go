@@ -0,0 +1,431 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/dynamic"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultDryRunReportName is the name of the dry run report ConfigMap when none is configured. It is
+	// created in the namespace the controller runs in, the dry run does not create the integration namespace.
+	DefaultDryRunReportName = "mtv-integrations-dry-run"
+	// DryRunReportInterval is how often the dry run report ConfigMap is updated
+	DryRunReportInterval = 30 * time.Second
+
+	// dryRunNoCluster is the report key of the changes made outside of the reconcile of a ManagedCluster
+	dryRunNoCluster = "no-cluster"
+)
+
+// dryRunClusterKey is the context key of the ManagedCluster a change is planned for
+type dryRunClusterKey struct{}
+
+// plannedChange is a write the controller would make without the dry run
+type plannedChange struct {
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Note      string `json:"note,omitempty"`
+}
+
+// ParseDryRunReport parses the <namespace>/<name> of the dry run report ConfigMap
+func ParseDryRunReport(report string) (types.NamespacedName, error) {
+	namespace, name, found := strings.Cut(report, "/")
+	if !found || len(validation.IsDNS1123Label(namespace)) > 0 || len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return types.NamespacedName{}, fmt.Errorf("invalid dry run report %q: must be <namespace>/<name>", report)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// DryRunReport collects the changes planned in dry run mode by ManagedCluster and writes them to a ConfigMap
+// with a key for each cluster. The report of a cluster is replaced by every reconcile of the cluster.
+type DryRunReport struct {
+	// Client writes the ConfigMap, it is not a dry run client
+	Client    client.Client
+	ConfigMap types.NamespacedName
+	// Interval is the time between two updates of the ConfigMap, zero is DryRunReportInterval
+	Interval time.Duration
+
+	mu      sync.Mutex
+	changes map[string][]plannedChange
+	dirty   bool
+}
+
+// startCluster clears the planned changes of the ManagedCluster before it is reconciled again, and returns
+// the context the changes of the reconcile are recorded with
+func (d *DryRunReport) startCluster(ctx context.Context, cluster string) context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.changes[cluster]; ok {
+		delete(d.changes, cluster)
+		d.dirty = true
+	}
+	return context.WithValue(ctx, dryRunClusterKey{}, cluster)
+}
+
+// record logs the planned change and adds it to the report of the ManagedCluster being reconciled
+func (d *DryRunReport) record(ctx context.Context, change plannedChange) {
+	log.FromContext(ctx).Info("DRY RUN: "+change.Operation, "kind", change.Kind, "namespace", change.Namespace,
+		"name", change.Name, "note", change.Note)
+
+	cluster, _ := ctx.Value(dryRunClusterKey{}).(string)
+	if cluster == "" {
+		cluster = dryRunNoCluster
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changes == nil {
+		d.changes = map[string][]plannedChange{}
+	}
+	for _, recorded := range d.changes[cluster] {
+		if recorded == change {
+			return
+		}
+	}
+	d.changes[cluster] = append(d.changes[cluster], change)
+	d.dirty = true
+}
+
+// data renders the report as the ConfigMap data, or returns false when it did not change since the last
+// rendering
+func (d *DryRunReport) data() (map[string]string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.dirty {
+		return nil, false, nil
+	}
+
+	data := map[string]string{}
+	for cluster, changes := range d.changes {
+		rendered, err := yaml.Marshal(changes)
+		if err != nil {
+			return nil, false, err
+		}
+		data[cluster] = string(rendered)
+	}
+	d.dirty = false
+	return data, true, nil
+}
+
+// Start writes the report to the ConfigMap until the manager stops
+func (d *DryRunReport) Start(ctx context.Context) error {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DryRunReportInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.write(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "Failed to write the dry run report", "configMap", d.ConfigMap)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection makes only the leader write the report. The leader reconciles every ManagedCluster since
+// the dry run cannot be used with sharding.
+func (d *DryRunReport) NeedLeaderElection() bool {
+	return true
+}
+
+// write server-side applies the report ConfigMap when the report changed
+func (d *DryRunReport) write(ctx context.Context) error {
+	data, changed, err := d.data()
+	if err != nil || !changed {
+		return err
+	}
+
+	configMap := corev1ac.ConfigMap(d.ConfigMap.Name, d.ConfigMap.Namespace).
+		WithAnnotations(map[string]string{
+			"mtv-integrations.open-cluster-management.io/dry-run-updated": time.Now().UTC().Format(time.RFC3339),
+		}).
+		WithData(data)
+	if err := d.Client.Apply(ctx, configMap, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		// Write the report again on the next attempt
+		d.mu.Lock()
+		d.dirty = true
+		d.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// enableDryRun makes every write of the reconciler a server-side dry run recorded in the report
+func (r *ManagedClusterReconciler) enableDryRun(report *DryRunReport) {
+	r.dryRunReport = report
+	r.Client = &dryRunClient{Client: client.NewDryRunClient(r.Client), report: report}
+	r.DynamicClient = &dryRunDynamicClient{Interface: r.DynamicClient, report: report}
+}
+
+// dryRunReportName returns the dry run report ConfigMap of the reconciler
+func (r *ManagedClusterReconciler) dryRunReportName() (types.NamespacedName, error) {
+	if r.DryRunReport.Name != "" {
+		return r.DryRunReport, nil
+	}
+	namespace, err := PodNamespace()
+	if err != nil {
+		return types.NamespacedName{}, fmt.Errorf("the dry run report is not set: %w", err)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: DefaultDryRunReportName}, nil
+}
+
+// setupDryRun makes the writes of the reconciler a dry run and adds the report writer to the manager
+func (r *ManagedClusterReconciler) setupDryRun(mgr ctrl.Manager) error {
+	// The report is written by the leader, which only reconciles its own shards with sharding
+	if r.Sharder != nil {
+		return fmt.Errorf("the dry run cannot be used with sharding")
+	}
+	name, err := r.dryRunReportName()
+	if err != nil {
+		return err
+	}
+	report := &DryRunReport{Client: mgr.GetClient(), ConfigMap: name}
+	r.enableDryRun(report)
+	return mgr.Add(report)
+}
+
+// dryRunContext starts the report of the ManagedCluster in dry run mode
+func (r *ManagedClusterReconciler) dryRunContext(ctx context.Context, cluster string) context.Context {
+	if r.dryRunReport == nil {
+		return ctx
+	}
+	return r.dryRunReport.startCluster(ctx, cluster)
+}
+
+// planPendingProvider records the provider secret and Provider that are created once the
+// ManagedServiceAccount token is issued, which does not happen in dry run mode
+func (r *ManagedClusterReconciler) planPendingProvider(ctx context.Context, managedClusterName string) {
+	if r.dryRunReport == nil {
+		return
+	}
+	integration := r.integration()
+	name := integration.ResourceName(managedClusterName)
+	r.dryRunReport.record(ctx, plannedChange{Operation: "create", Kind: "Secret", Namespace: integration.Namespace,
+		Name: name, Note: "once the ManagedServiceAccount token is issued"})
+	r.dryRunReport.record(ctx, plannedChange{Operation: "create", Kind: "Provider", Namespace: integration.Namespace,
+		Name: name, Note: "once the ManagedServiceAccount token is issued"})
+}
+
+// dryRunClient is a controller-runtime client that sends every write as a server-side dry run and records it
+type dryRunClient struct {
+	client.Client
+	report *DryRunReport
+}
+
+func (c *dryRunClient) recordObject(ctx context.Context, operation string, obj runtime.Object) {
+	change := plannedChange{Operation: operation}
+	if gvk, err := c.GroupVersionKindFor(obj); err == nil {
+		change.Kind = gvk.Kind
+	}
+	if accessor, ok := obj.(metav1.Object); ok {
+		change.Namespace, change.Name = accessor.GetNamespace(), accessor.GetName()
+	}
+	c.report.record(ctx, change)
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.recordObject(ctx, "create", obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.recordObject(ctx, "update", obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Patch(
+	ctx context.Context,
+	obj client.Object,
+	patch client.Patch,
+	opts ...client.PatchOption,
+) error {
+	c.recordObject(ctx, "patch", obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.recordObject(ctx, "delete", obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	change := plannedChange{Operation: "apply"}
+	if named, ok := obj.(interface {
+		GetKind() *string
+		GetName() *string
+		GetNamespace() *string
+	}); ok {
+		change.Kind, change.Name, change.Namespace = stringValue(named.GetKind()), stringValue(named.GetName()),
+			stringValue(named.GetNamespace())
+	}
+	c.report.record(ctx, change)
+	return c.Client.Apply(ctx, obj, opts...)
+}
+
+// stringValue returns the value of an apply configuration field, or empty when it is not set
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// dryRunDynamicClient is a dynamic client that sends every write as a server-side dry run and records it
+type dryRunDynamicClient struct {
+	dynamic.Interface
+	report *DryRunReport
+}
+
+func (c *dryRunDynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+	return &dryRunResource{ResourceInterface: resource, namespaceable: resource, gvr: gvr, report: c.report}
+}
+
+// dryRunResource is the resource client of the dryRunDynamicClient
+type dryRunResource struct {
+	dynamic.ResourceInterface
+	namespaceable dynamic.NamespaceableResourceInterface
+	gvr           schema.GroupVersionResource
+	namespace     string
+	report        *DryRunReport
+}
+
+var dryRunAll = []string{metav1.DryRunAll}
+
+// resourceKinds are the kinds of the resources the controller reads and writes with the dynamic client
+var resourceKinds = map[schema.GroupVersionResource]string{
+	ClusterPermissionsGVR:     "ClusterPermission",
+	ManagedServiceAccountsGVR: "ManagedServiceAccount",
+	ProviderSecretGVR:         "Secret",
+	ProvidersGVR:              "Provider",
+	PlansGVR:                  "Plan",
+	MigrationsGVR:             "Migration",
+}
+
+func (c *dryRunResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &dryRunResource{
+		ResourceInterface: c.namespaceable.Namespace(namespace),
+		namespaceable:     c.namespaceable,
+		gvr:               c.gvr,
+		namespace:         namespace,
+		report:            c.report,
+	}
+}
+
+func (c *dryRunResource) record(ctx context.Context, operation, name string) {
+	kind, ok := resourceKinds[c.gvr]
+	if !ok {
+		kind = c.gvr.Resource
+	}
+	c.report.record(ctx, plannedChange{Operation: operation, Kind: kind, Namespace: c.namespace, Name: name})
+}
+
+func (c *dryRunResource) Create(
+	ctx context.Context,
+	obj *unstructured.Unstructured,
+	options metav1.CreateOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	c.record(ctx, "create", obj.GetName())
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.Create(ctx, obj, options, subresources...)
+}
+
+func (c *dryRunResource) Update(
+	ctx context.Context,
+	obj *unstructured.Unstructured,
+	options metav1.UpdateOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	c.record(ctx, "update", obj.GetName())
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.Update(ctx, obj, options, subresources...)
+}
+
+func (c *dryRunResource) UpdateStatus(
+	ctx context.Context,
+	obj *unstructured.Unstructured,
+	options metav1.UpdateOptions,
+) (*unstructured.Unstructured, error) {
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.UpdateStatus(ctx, obj, options)
+}
+
+func (c *dryRunResource) Delete(
+	ctx context.Context,
+	name string,
+	options metav1.DeleteOptions,
+	subresources ...string,
+) error {
+	c.record(ctx, "delete", name)
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.Delete(ctx, name, options, subresources...)
+}
+
+func (c *dryRunResource) DeleteCollection(
+	ctx context.Context,
+	options metav1.DeleteOptions,
+	listOptions metav1.ListOptions,
+) error {
+	c.record(ctx, "deletecollection", "")
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.DeleteCollection(ctx, options, listOptions)
+}
+
+func (c *dryRunResource) Patch(
+	ctx context.Context,
+	name string,
+	pt types.PatchType,
+	data []byte,
+	options metav1.PatchOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	c.record(ctx, "patch", name)
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+func (c *dryRunResource) Apply(
+	ctx context.Context,
+	name string,
+	obj *unstructured.Unstructured,
+	options metav1.ApplyOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	c.record(ctx, "apply", name)
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.Apply(ctx, name, obj, options, subresources...)
+}
+
+func (c *dryRunResource) ApplyStatus(
+	ctx context.Context,
+	name string,
+	obj *unstructured.Unstructured,
+	options metav1.ApplyOptions,
+) (*unstructured.Unstructured, error) {
+	options.DryRun = dryRunAll
+	return c.ResourceInterface.ApplyStatus(ctx, name, obj, options)
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseDryRunReport(t *testing.T) {
+	report, err := ParseDryRunReport("open-cluster-management/mtv-plan")
+	require.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "open-cluster-management", Name: "mtv-plan"}, report)
+
+	for _, invalid := range []string{"mtv-plan", "/mtv-plan", "open-cluster-management/", "Invalid/mtv-plan"} {
+		_, err := ParseDryRunReport(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// dryRunOptions returns the dry run option of a write of the dynamic client
+func dryRunOptions(action clienttesting.Action) ([]string, bool) {
+	switch action := action.(type) {
+	case clienttesting.CreateActionImpl:
+		return action.CreateOptions.DryRun, true
+	case clienttesting.UpdateActionImpl:
+		return action.UpdateOptions.DryRun, true
+	case clienttesting.PatchActionImpl:
+		return action.PatchOptions.DryRun, true
+	case clienttesting.DeleteActionImpl:
+		return action.DeleteOptions.DryRun, true
+	}
+	return nil, false
+}
+
+// usePodNamespace makes the controller run in the namespace for the test
+func usePodNamespace(t *testing.T, namespace string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "namespace")
+	require.NoError(t, os.WriteFile(path, []byte(namespace+"\n"), 0o600))
+	previous := podNamespacePath
+	podNamespacePath = path
+	t.Cleanup(func() { podNamespacePath = previous })
+}
+
+func TestDryRunReportName(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{}
+	previous := podNamespacePath
+	t.Cleanup(func() { podNamespacePath = previous })
+	podNamespacePath = filepath.Join(t.TempDir(), "missing")
+	_, err := reconciler.dryRunReportName()
+	assert.Error(t, err, "not running in a cluster")
+
+	usePodNamespace(t, "open-cluster-management")
+	name, err := reconciler.dryRunReportName()
+	require.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "open-cluster-management", Name: DefaultDryRunReportName}, name)
+
+	reconciler.DryRunReport = types.NamespacedName{Namespace: "mtv", Name: "plan"}
+	name, err = reconciler.dryRunReportName()
+	require.NoError(t, err)
+	assert.Equal(t, reconciler.DryRunReport, name)
+}
+
+func TestReconcile_DryRun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-cluster",
+			Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithObjects(providerCrd, managedCluster).Build()
+	dynClient := fake.NewSimpleDynamicClient(scheme)
+	recorder := events.NewFakeRecorder(10)
+
+	reconciler := &ManagedClusterReconciler{
+		Client:         k8sClient,
+		Scheme:         scheme,
+		DynamicClient:  dynClient,
+		Recorder:       recorder,
+		AgentNamespace: "open-cluster-management-agent-addon",
+	}
+	usePodNamespace(t, "open-cluster-management")
+	reportName, err := reconciler.dryRunReportName()
+	require.NoError(t, err)
+	report := &DryRunReport{Client: k8sClient, ConfigMap: reportName}
+	reconciler.enableDryRun(report)
+
+	_, err = reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+
+	// Nothing is changed
+	updated := &clusterv1.ManagedCluster{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+	assert.NotContains(t, updated.Finalizers, ManagedClusterFinalizer)
+	assert.Empty(t, updated.Status.Conditions)
+	err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		&auth.ManagedServiceAccount{})
+	assert.True(t, apierrors.IsNotFound(err))
+	err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: MTVIntegrationsNamespace}, &corev1.Namespace{})
+	assert.True(t, apierrors.IsNotFound(err))
+	for _, action := range dynClient.Actions() {
+		if options, write := dryRunOptions(action); write {
+			assert.Equal(t, []string{metav1.DryRunAll}, options, action.GetVerb()+" "+action.GetResource().Resource)
+		}
+	}
+	assert.Empty(t, drainEvents(recorder))
+
+	// Every planned change is reported for the cluster
+	planned := report.changes["test-cluster"]
+	assert.Contains(t, planned, plannedChange{Operation: "patch", Kind: "ManagedCluster", Name: "test-cluster"})
+	assert.Contains(t, planned, plannedChange{Operation: "create", Kind: "ManagedServiceAccount",
+		Namespace: "test-cluster", Name: "test-cluster-mtv"})
+	assert.Contains(t, planned, plannedChange{Operation: "create", Kind: "ClusterPermission",
+		Namespace: "test-cluster", Name: "test-cluster-mtv"})
+	assert.Contains(t, planned, plannedChange{Operation: "create", Kind: "Namespace", Name: MTVIntegrationsNamespace})
+	assert.Contains(t, planned, plannedChange{Operation: "create", Kind: "Provider",
+		Namespace: MTVIntegrationsNamespace, Name: "test-cluster-mtv",
+		Note: "once the ManagedServiceAccount token is issued"})
+
+	// The report is written to the ConfigMap in the namespace of the controller, the integration namespace
+	// does not exist
+	require.NoError(t, report.write(context.TODO()))
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: DefaultDryRunReportName, Namespace: "open-cluster-management"}, configMap))
+	var written []plannedChange
+	require.NoError(t, yaml.Unmarshal([]byte(configMap.Data["test-cluster"]), &written))
+	assert.Equal(t, planned, written)
+
+	// The next reconcile replaces the report of the cluster
+	report.record(report.startCluster(context.TODO(), "test-cluster"),
+		plannedChange{Operation: "patch", Kind: "Provider", Namespace: MTVIntegrationsNamespace, Name: "test-cluster-mtv"})
+	assert.Len(t, report.changes["test-cluster"], 1)
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+// EndpointSelectionKey is the ManagedCluster annotation that selects the client config whose API server URL
+// the Provider connects to. The value is one of:
+//   - index:<n> selects the client config at the index
+//   - pattern:<regexp> selects the first client config whose URL matches the regular expression
+//   - internal prefers a client config with an internal API server URL, such as api-int.<domain>
+//
+// Without a selection the first client config with a usable URL is used.
+const EndpointSelectionKey = "mtv-integrations.open-cluster-management.io/api-server-endpoint"
+
+const (
+	endpointSelectionIndex    = "index:"
+	endpointSelectionPattern  = "pattern:"
+	endpointSelectionInternal = "internal"
+)
+
+var errNoUsableEndpoint = errors.New("the ManagedCluster has no client config with a usable API server URL")
+
+// endpointSelection is a parsed EndpointSelectionKey value
+type endpointSelection struct {
+	index    *int
+	pattern  *regexp.Regexp
+	internal bool
+}
+
+// parseEndpointSelection parses an EndpointSelectionKey value, an empty value selects the first usable URL
+func parseEndpointSelection(value string) (*endpointSelection, error) {
+	switch {
+	case value == "":
+		return &endpointSelection{}, nil
+	case value == endpointSelectionInternal:
+		return &endpointSelection{internal: true}, nil
+	case strings.HasPrefix(value, endpointSelectionIndex):
+		index, err := strconv.Atoi(strings.TrimPrefix(value, endpointSelectionIndex))
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid API server endpoint selection %q: the index must be a number >= 0", value)
+		}
+		return &endpointSelection{index: &index}, nil
+	case strings.HasPrefix(value, endpointSelectionPattern):
+		pattern, err := regexp.Compile(strings.TrimPrefix(value, endpointSelectionPattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid API server endpoint selection %q: %w", value, err)
+		}
+		return &endpointSelection{pattern: pattern}, nil
+	default:
+		return nil, fmt.Errorf("invalid API server endpoint selection %q: must be index:<n>, pattern:<regexp> "+
+			"or internal", value)
+	}
+}
+
+// ValidateEndpointSelection checks a hub-wide API server endpoint selection
+func ValidateEndpointSelection(value string) error {
+	_, err := parseEndpointSelection(value)
+	return err
+}
+
+// usableEndpoint checks that the client config URL is an absolute http or https URL
+func usableEndpoint(clientConfig clusterv1.ClientConfig) bool {
+	u, err := url.Parse(clientConfig.URL)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// internalEndpoint checks if the client config URL is an internal API server URL
+func internalEndpoint(clientConfig clusterv1.ClientConfig) bool {
+	u, err := url.Parse(clientConfig.URL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	return strings.HasPrefix(host, "api-int.") || strings.HasSuffix(host, ".svc") ||
+		strings.HasSuffix(host, ".svc.cluster.local")
+}
+
+// selectClientConfig returns the client config chosen by the selection
+func selectClientConfig(
+	clientConfigs []clusterv1.ClientConfig,
+	selection *endpointSelection,
+) (*clusterv1.ClientConfig, error) {
+	if selection.index != nil {
+		if *selection.index >= len(clientConfigs) || !usableEndpoint(clientConfigs[*selection.index]) {
+			return nil, fmt.Errorf("the ManagedCluster has no client config with a usable API server URL "+
+				"at index %d", *selection.index)
+		}
+		return &clientConfigs[*selection.index], nil
+	}
+
+	var first *clusterv1.ClientConfig
+	for i := range clientConfigs {
+		clientConfig := &clientConfigs[i]
+		if !usableEndpoint(*clientConfig) {
+			continue
+		}
+		if selection.pattern != nil && !selection.pattern.MatchString(clientConfig.URL) {
+			continue
+		}
+		if selection.internal && internalEndpoint(*clientConfig) {
+			return clientConfig, nil
+		}
+		if first == nil {
+			first = clientConfig
+		}
+	}
+
+	if first == nil {
+		if selection.pattern != nil {
+			return nil, fmt.Errorf("the ManagedCluster has no client config with a usable API server URL "+
+				"matching %q", selection.pattern)
+		}
+		return nil, errNoUsableEndpoint
+	}
+	return first, nil
+}
+
+// clusterEndpoint returns the client config the Provider of the ManagedCluster connects to. The cluster
+// annotation takes precedence over the hub-wide selection.
+func (r *ManagedClusterReconciler) clusterEndpoint(
+	managedCluster *clusterv1.ManagedCluster,
+) (*clusterv1.ClientConfig, error) {
+	value, ok := managedCluster.GetAnnotations()[EndpointSelectionKey]
+	if !ok {
+		value = r.DefaultEndpointSelection
+	}
+
+	selection, err := parseEndpointSelection(value)
+	if err != nil {
+		return nil, err
+	}
+	return selectClientConfig(managedCluster.Spec.ManagedClusterClientConfigs, selection)
+}
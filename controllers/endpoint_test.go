@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestClusterEndpoint(t *testing.T) {
+	clientConfigs := []clusterv1.ClientConfig{
+		{URL: ""},
+		{URL: "https://api.example.com:6443", CABundle: []byte("external-ca")},
+		{URL: "https://api-int.example.com:6443"},
+	}
+
+	cases := []struct {
+		name       string
+		reconciler *ManagedClusterReconciler
+		selection  string
+		configs    []clusterv1.ClientConfig
+		wantURL    string
+		wantErr    bool
+	}{
+		{
+			name:       "first usable URL by default",
+			reconciler: &ManagedClusterReconciler{},
+			configs:    clientConfigs,
+			wantURL:    "https://api.example.com:6443",
+		},
+		{
+			name:       "by index",
+			reconciler: &ManagedClusterReconciler{},
+			selection:  "index:2",
+			configs:    clientConfigs,
+			wantURL:    "https://api-int.example.com:6443",
+		},
+		{
+			name:       "index without a usable URL",
+			reconciler: &ManagedClusterReconciler{},
+			selection:  "index:0",
+			configs:    clientConfigs,
+			wantErr:    true,
+		},
+		{
+			name:       "by pattern",
+			reconciler: &ManagedClusterReconciler{},
+			selection:  `pattern:^https://api-int\.`,
+			configs:    clientConfigs,
+			wantURL:    "https://api-int.example.com:6443",
+		},
+		{
+			name:       "hub-wide internal preference",
+			reconciler: &ManagedClusterReconciler{DefaultEndpointSelection: "internal"},
+			configs:    clientConfigs,
+			wantURL:    "https://api-int.example.com:6443",
+		},
+		{
+			name:       "internal preference falls back to the first usable URL",
+			reconciler: &ManagedClusterReconciler{},
+			selection:  "internal",
+			configs:    clientConfigs[:2],
+			wantURL:    "https://api.example.com:6443",
+		},
+		{
+			name:       "no client configs",
+			reconciler: &ManagedClusterReconciler{},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid selection",
+			reconciler: &ManagedClusterReconciler{},
+			selection:  "newest",
+			configs:    clientConfigs,
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			managedCluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "c1"},
+				Spec:       clusterv1.ManagedClusterSpec{ManagedClusterClientConfigs: tc.configs},
+			}
+			if tc.selection != "" {
+				managedCluster.SetAnnotations(map[string]string{EndpointSelectionKey: tc.selection})
+			}
+
+			endpoint, err := tc.reconciler.clusterEndpoint(managedCluster)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantURL, endpoint.URL)
+		})
+	}
+}
+
+func TestValidateEndpointSelection(t *testing.T) {
+	assert.NoError(t, ValidateEndpointSelection(""))
+	assert.NoError(t, ValidateEndpointSelection("index:1"))
+	assert.NoError(t, ValidateEndpointSelection("pattern:example"))
+	assert.NoError(t, ValidateEndpointSelection("internal"))
+	assert.Error(t, ValidateEndpointSelection("index:-1"))
+	assert.Error(t, ValidateEndpointSelection("pattern:("))
+}
+
+func TestReconcile_NoProviderWithoutEndpoint(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Labels:     map[string]string{LabelCNVOperatorInstall: "true"},
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Status:     auth.ManagedServiceAccountStatus{TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv"}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token"), "ca.crt": []byte("test-ca")},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster, msa, secret, deployment).Build()
+	dynClient := fake.NewSimpleDynamicClient(scheme)
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+	}
+
+	_, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "no Provider must be created without a URL")
+	err = k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err), "no provider secret must be created without a URL")
+
+	condition := integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseEndpointUnavailable), condition.Reason)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, errNoUsableEndpoint.Error(), condition.Message)
+}
+
+func TestHandleProviderSecrets_UsesClientConfigCABundle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	endpoint := &providerEndpoint{
+		ClientConfig: clusterv1.ClientConfig{URL: "https://api.example.com:6443", CABundle: []byte("bundle-ca")},
+	}
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{endpoint.ClientConfig},
+		},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Status:     auth.ManagedServiceAccountStatus{TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv"}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token")},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+
+	synced, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+	require.NoError(t, err)
+	assert.True(t, synced)
+
+	providerSecret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, providerSecret))
+	assert.Equal(t, "bundle-ca", string(providerSecret.Data["cacert"]))
+	assert.Equal(t, "https://api.example.com:6443", string(providerSecret.Data["url"]))
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the Events emitted on the ManagedCluster and its Provider
+const (
+	ReasonFinalizerAdded           = "FinalizerAdded"
+	ReasonServiceAccountCreated    = "ManagedServiceAccountCreated"
+	ReasonTokenSynced              = "TokenSynced"
+	ReasonTokenRotated             = "TokenRotated"
+	ReasonTokenValidityUpdated     = "TokenValidityUpdated"
+	ReasonTokenRotationStarted     = "TokenRotationStarted"
+	ReasonTokenRotationCompleted   = "TokenRotationCompleted"
+	ReasonClusterPermissionApplied = "ClusterPermissionApplied"
+	ReasonProviderCreated          = "ProviderCreated"
+	ReasonProviderUpdated          = "ProviderUpdated"
+	ReasonCleanupStarted           = "CleanupStarted"
+	ReasonCleanupFinished          = "CleanupFinished"
+	ReasonLegacyResourcesMigrated  = "LegacyResourcesMigrated"
+	ReasonClusterAvailable         = "ClusterAvailable"
+	ReasonHostProviderMapped       = "HostProviderMapped"
+	ReasonProviderAdopted          = "ProviderAdopted"
+	ReasonIntegrationPaused        = "IntegrationPaused"
+	ReasonIntegrationResumed       = "IntegrationResumed"
+	ReasonResyncCompleted          = "ResyncCompleted"
+	// The TLS verification reasons audit changes of the provider secret insecureSkipVerify setting
+	ReasonInsecureSkipVerifyEnabled  = "InsecureSkipVerifyEnabled"
+	ReasonInsecureSkipVerifyDisabled = "InsecureSkipVerifyDisabled"
+	ReasonInsecureSkipVerifyDenied   = "InsecureSkipVerifyDenied"
+
+	ReasonFinalizerFailed         = "FinalizerFailed"
+	ReasonServiceAccountFailed    = "ManagedServiceAccountFailed"
+	ReasonClusterPermissionFailed = "ClusterPermissionFailed"
+	ReasonEndpointUnavailable     = "EndpointUnavailable"
+	ReasonClusterUnavailable      = "ClusterUnavailable"
+	ReasonSecretSyncFailed        = "SecretSyncFailed"
+	ReasonProviderFailed          = "ProviderFailed"
+	ReasonProviderAuthFailed      = "ProviderAuthenticationFailed"
+	ReasonCleanupFailed           = "CleanupFailed"
+	ReasonCleanupBlocked          = "CleanupBlocked"
+	ReasonHostProviderMissing     = "HostProviderMissing"
+	// ReasonCleanupForced audits a cleanup forced while Plans were in flight
+	ReasonCleanupForced = "CleanupForced"
+)
+
+// Actions of the Events, onboarding covers every step before the cleanup
+const (
+	actionOnboard  = "Onboard"
+	actionOffboard = "Offboard"
+)
+
+// phaseFailureReasons maps the phase a reconcile failed in to the reason of its Warning event
+var phaseFailureReasons = map[IntegrationPhase]string{
+	PhaseFinalizerAdded:        ReasonFinalizerFailed,
+	PhaseServiceAccountPending: ReasonServiceAccountFailed,
+	PhasePermissionApplied:     ReasonClusterPermissionFailed,
+	PhaseEndpointUnavailable:   ReasonEndpointUnavailable,
+	PhaseClusterUnavailable:    ReasonClusterUnavailable,
+	PhaseSecretSynced:          ReasonSecretSyncFailed,
+	PhaseProviderCreated:       ReasonProviderFailed,
+	PhaseCleaningUp:            ReasonCleanupFailed,
+	PhaseCleanupBlocked:        ReasonCleanupBlocked,
+	PhaseHostProviderMissing:   ReasonHostProviderMissing,
+}
+
+// recordEvent emits an Event regarding the object. It does nothing when the reconciler has no recorder.
+func (r *ManagedClusterReconciler) recordEvent(
+	regarding runtime.Object,
+	eventtype, reason, action, note string,
+	args ...interface{},
+) {
+	// The changes of a dry run are not made, they are reported instead
+	if r.Recorder == nil || r.dryRunReport != nil {
+		return
+	}
+	r.Recorder.Eventf(regarding, nil, eventtype, reason, action, note, args...)
+}
+
+// recordPhaseFailure emits a Warning event for a step that failed
+func (r *ManagedClusterReconciler) recordPhaseFailure(regarding runtime.Object, phase IntegrationPhase, err error) {
+	reason, ok := phaseFailureReasons[phase]
+	if !ok || err == nil {
+		return
+	}
+	action := actionOnboard
+	if phase == PhaseCleaningUp || phase == PhaseCleanupBlocked {
+		action = actionOffboard
+	}
+	r.recordEvent(regarding, corev1.EventTypeWarning, reason, action, "%s", phaseErrorMessage(phase, err))
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// drainEvents returns the events recorded so far as "<type> <reason>"
+func drainEvents(recorder *events.FakeRecorder) []string {
+	var recorded []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			fields := strings.SplitN(event, " ", 3)
+			recorded = append(recorded, fields[0]+" "+fields[1])
+		default:
+			return recorded
+		}
+	}
+}
+
+func TestReconcile_EmitsOnboardingEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-cluster",
+			Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token"), "ca.crt": []byte("test-ca")},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithObjects(providerCrd, managedCluster, secret, deployment).Build()
+	recorder := events.NewFakeRecorder(20)
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClient(scheme),
+		Recorder:      recorder,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Normal " + ReasonFinalizerAdded}, drainEvents(recorder))
+
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Normal " + ReasonServiceAccountCreated}, drainEvents(recorder))
+
+	msa := &auth.ManagedServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}, msa))
+	msa.Status.TokenSecretRef = &auth.SecretRef{Name: "test-cluster-mtv"}
+	require.NoError(t, k8sClient.Update(context.TODO(), msa))
+
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	// The Provider event is emitted on both the ManagedCluster and the Provider
+	assert.Equal(t, []string{
+		"Normal " + ReasonClusterPermissionApplied,
+		"Normal " + ReasonTokenSynced,
+		"Normal " + ReasonProviderCreated,
+		"Normal " + ReasonProviderCreated,
+	}, drainEvents(recorder))
+
+	// A rotated token is copied again
+	secret.Data["token"] = []byte("rotated-token")
+	require.NoError(t, k8sClient.Update(context.TODO(), secret))
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Normal " + ReasonTokenRotated}, drainEvents(recorder))
+}
+
+func TestCleanupManagedClusterResources_EmitsEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(managedCluster).Build()
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClientWithCustomListKinds(scheme, integrationListKinds),
+		Recorder:      recorder,
+	}
+
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, managedCluster))
+	require.NoError(t, reconciler.cleanupManagedClusterResources(context.TODO(), managedCluster))
+	assert.Equal(t, []string{
+		"Normal " + ReasonCleanupStarted,
+		"Normal " + ReasonCleanupFinished,
+	}, drainEvents(recorder))
+}
+
+func TestRecordPhaseFailure(t *testing.T) {
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{Recorder: recorder}
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c1"}}
+
+	reconciler.recordPhaseFailure(managedCluster, PhaseSecretSynced, nil)
+	reconciler.recordPhaseFailure(managedCluster, PhaseSecretSynced, errors.New("conflict"))
+	reconciler.recordPhaseFailure(managedCluster, PhaseCleaningUp, errors.New("forbidden"))
+
+	assert.Equal(t, []string{
+		"Warning " + ReasonSecretSyncFailed,
+		"Warning " + ReasonCleanupFailed,
+	}, drainEvents(recorder))
+
+	// Without a recorder no events are emitted
+	(&ManagedClusterReconciler{}).recordPhaseFailure(managedCluster, PhaseSecretSynced, errors.New("conflict"))
+}
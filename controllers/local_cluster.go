@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// LocalClusterLabel is the ManagedCluster label set to true on the hub's self-managed cluster
+	LocalClusterLabel = "local-cluster"
+	// LocalClusterClaim is the ClusterClaim set to true on the hub's self-managed cluster, for hubs that do not
+	// label it
+	LocalClusterClaim = "local-cluster.open-cluster-management.io"
+
+	// DefaultForkliftNamespace is the namespace Forklift is installed in when none is configured
+	DefaultForkliftNamespace = "openshift-mtv"
+
+	// hostProviderName is the name of the Provider Forklift creates for the cluster it runs on
+	hostProviderName = "host"
+)
+
+// isLocalCluster checks if the ManagedCluster is the hub's self-managed cluster
+func isLocalCluster(managedCluster *clusterv1.ManagedCluster) bool {
+	if managedCluster.GetLabels()[LocalClusterLabel] == "true" {
+		return true
+	}
+	for _, claim := range managedCluster.Status.ClusterClaims {
+		if claim.Name == LocalClusterClaim && claim.Value == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// forkliftNamespace returns the namespace Forklift is installed in
+func (r *ManagedClusterReconciler) forkliftNamespace() string {
+	if r.ForkliftNamespace != "" {
+		return r.ForkliftNamespace
+	}
+	return DefaultForkliftNamespace
+}
+
+// isHostProvider checks if the Provider is the Forklift host provider, an OpenShift Provider without a URL
+// in the Forklift namespace that connects to the cluster Forklift runs on. A Provider without a URL created
+// by a user in another namespace is not the host provider.
+func (r *ManagedClusterReconciler) isHostProvider(provider *unstructured.Unstructured) bool {
+	providerType, _, _ := unstructured.NestedString(provider.Object, "spec", "type")
+	url, _, _ := unstructured.NestedString(provider.Object, "spec", "url")
+	return provider.GetNamespace() == r.forkliftNamespace() && providerType == "openshift" && url == ""
+}
+
+// hostProvider returns the Forklift host provider, or nil when Forklift did not create it yet. The Provider
+// named host is preferred when several Providers of the Forklift namespace have no URL.
+func (r *ManagedClusterReconciler) hostProvider(ctx context.Context) (*unstructured.Unstructured, error) {
+	providers, err := r.listResources(ctx, ProvidersGVR, r.forkliftNamespace())
+	if err != nil {
+		return nil, err
+	}
+
+	var host *unstructured.Unstructured
+	for i := range providers.Items {
+		provider := &providers.Items[i]
+		if !r.isHostProvider(provider) {
+			continue
+		}
+		if host == nil || provider.GetName() == hostProviderName {
+			host = provider
+		}
+	}
+	return host, nil
+}
+
+// localClusters returns the requests of the self-managed clusters, to reconcile them when the host
+// provider changes
+func (r *ManagedClusterReconciler) localClusters(ctx context.Context) []reconcile.Request {
+	managedClusters := &clusterv1.ManagedClusterList{}
+	if err := r.List(ctx, managedClusters); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the ManagedClusters for the host provider")
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for i := range managedClusters.Items {
+		if isLocalCluster(&managedClusters.Items[i]) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name: managedClusters.Items[i].Name,
+			}})
+		}
+	}
+	return reqs
+}
+
+// reconcileLocalCluster maps the hub's self-managed cluster to the Forklift host provider. No
+// ManagedServiceAccount, ClusterPermission, provider secret or Provider is created for it, and the ones
+// created before the cluster was recognized are deleted.
+func (r *ManagedClusterReconciler) reconcileLocalCluster(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	status *integrationStatus,
+) (ctrl.Result, error) {
+	host, err := r.hostProvider(ctx)
+	if err != nil {
+		status.record(PhaseHostProviderMissing, err)
+		return ctrl.Result{}, err
+	}
+	if host == nil {
+		// Forklift creates the host provider once it is installed, check again until it does
+		status.record(PhaseHostProviderMissing, nil)
+		return ctrl.Result{RequeueAfter: ProviderReadyCheckInterval}, nil
+	}
+
+	remote, err := r.remoteProviderResources(ctx, managedCluster)
+	if err != nil {
+		status.record(PhaseCleaningUp, err)
+		return ctrl.Result{}, err
+	}
+	if len(remote) > 0 {
+		// Like an offboarding, the remote Provider is kept until the Plans that use it finish. The check
+		// sets the status itself.
+		blocked, err := r.cleanupBlocked(ctx, managedCluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if blocked {
+			return ctrl.Result{RequeueAfter: CleanupBlockedCheckInterval}, nil
+		}
+		if err := r.removeRemoteProvider(ctx, managedCluster, host, remote); err != nil {
+			status.record(PhaseCleaningUp, err)
+			return ctrl.Result{}, err
+		}
+	}
+	// The local cluster has no resources of its own to re-apply
+	if err := r.completeResync(ctx, managedCluster); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.setProviderConditions(ctx, managedCluster, host)
+	if !providerReady(host) {
+		status.record(PhaseProviderCreated, nil)
+		return ctrl.Result{RequeueAfter: ProviderReadyCheckInterval}, nil
+	}
+	status.record(PhaseProviderReady, nil)
+	return ctrl.Result{}, nil
+}
+
+// remoteProviderResources returns the resources created for the self-managed cluster as a remote cluster
+// before it was recognized
+func (r *ManagedClusterReconciler) remoteProviderResources(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) ([]integrationResource, error) {
+	resources := integrationResources(r.integration(), managedCluster.Name)
+	resources = append(resources, r.legacyResources(managedCluster.Name)...)
+
+	var remote []integrationResource
+	for _, resource := range resources {
+		_, err := r.getResource(ctx, resource.gvr, resource.namespace, resource.name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		remote = append(remote, resource)
+	}
+	return remote, nil
+}
+
+// removeRemoteProvider deletes the remote provider resources of the self-managed cluster, so the host provider
+// is its only Provider
+func (r *ManagedClusterReconciler) removeRemoteProvider(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	host *unstructured.Unstructured,
+	remote []integrationResource,
+) error {
+	for _, resource := range remote {
+		log.FromContext(ctx).Info("Deleting the remote provider resource of the local cluster", resource.gvr.Resource,
+			resource.name, "namespace", resource.namespace)
+		if err := deleteResource(ctx, r.DynamicClient, resource.gvr, resource.name, resource.namespace); err != nil {
+			return err
+		}
+	}
+
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonHostProviderMapped, actionOnboard,
+		"Deleted the remote provider resources, the local cluster uses the Forklift host provider %s/%s",
+		host.GetNamespace(), host.GetName())
+	return nil
+}
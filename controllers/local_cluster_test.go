@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestIsLocalCluster(t *testing.T) {
+	assert.False(t, isLocalCluster(&clusterv1.ManagedCluster{}))
+	assert.True(t, isLocalCluster(&clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LocalClusterLabel: "true"}},
+	}))
+	assert.False(t, isLocalCluster(&clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LocalClusterLabel: "false"}},
+	}))
+	assert.True(t, isLocalCluster(&clusterv1.ManagedCluster{
+		Status: clusterv1.ManagedClusterStatus{
+			ClusterClaims: []clusterv1.ManagedClusterClaim{{Name: LocalClusterClaim, Value: "true"}},
+		},
+	}))
+}
+
+// hostProviderOf returns a Forklift host provider with the Ready condition
+func hostProviderOf(name, namespace, ready string) *unstructured.Unstructured {
+	provider := testProvider(name, namespace)
+	_ = unstructured.SetNestedField(provider.Object, "openshift", "spec", "type")
+	_ = unstructured.SetNestedField(provider.Object, "", "spec", "url")
+	_ = unstructured.SetNestedSlice(provider.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": ready, "reason": "Completed", "message": "Ready"},
+	}, "status", "conditions")
+	return provider
+}
+
+func localClusterSetup(
+	t *testing.T,
+	objects ...runtime.Object,
+) (*ManagedClusterReconciler, *events.FakeRecorder) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "local-cluster",
+			Labels:     map[string]string{LabelCNVOperatorInstall: "true", LocalClusterLabel: "true"},
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://api.hub.example.com:6443"}},
+		},
+	}
+	listKinds := map[schema.GroupVersionResource]string{ProvidersGVR: "ProviderList", PlansGVR: "PlanList",
+		MigrationsGVR: "MigrationList"}
+
+	recorder := events.NewFakeRecorder(10)
+	return &ManagedClusterReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(providerCrd, managedCluster).WithStatusSubresource(managedCluster).Build(),
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...),
+		Recorder:      recorder,
+	}, recorder
+}
+
+func TestReconcile_LocalClusterUsesHostProvider(t *testing.T) {
+	// A remote-style Provider was created before the cluster was recognized as the local cluster
+	remote := testProvider("local-cluster-mtv", MTVIntegrationsNamespace)
+	reconciler, recorder := localClusterSetup(t, remote, testProvider("other-mtv", MTVIntegrationsNamespace),
+		hostProviderOf("host", "openshift-mtv", "True"))
+
+	result, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "local-cluster"}})
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+
+	// No ManagedServiceAccount is created and the remote Provider is deleted
+	err = reconciler.Get(context.TODO(), types.NamespacedName{Name: "local-cluster-mtv", Namespace: "local-cluster"},
+		&auth.ManagedServiceAccount{})
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = reconciler.DynamicClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"local-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, []string{"Normal " + ReasonHostProviderMapped}, drainEvents(recorder))
+
+	// The status reports the readiness of the host provider
+	assert.Equal(t, string(PhaseProviderReady), integrationConditionOf(t, reconciler.Client, "local-cluster").Reason)
+	managedCluster := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "local-cluster"}, managedCluster))
+	ready := meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeProviderPrefix+"Ready")
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+
+	// Nothing is left to delete on the next reconcile
+	_, err = reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "local-cluster"}})
+	require.NoError(t, err)
+	assert.Empty(t, drainEvents(recorder))
+}
+
+func TestReconcile_LocalClusterKeepsRemoteProviderOfInFlightPlans(t *testing.T) {
+	remote := testProvider("local-cluster-mtv", MTVIntegrationsNamespace)
+	reconciler, recorder := localClusterSetup(t, remote, hostProviderOf("host", "openshift-mtv", "True"),
+		unstructuredPlan(t, testPlan("running", "local-cluster-mtv", forkliftv1beta1.ConditionExecuting)))
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "local-cluster"}}
+
+	result, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, CleanupBlockedCheckInterval, result.RequeueAfter)
+	_, err = reconciler.DynamicClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"local-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err, "the Provider of the running Plan is kept")
+	assert.Equal(t, string(PhaseCleanupBlocked), integrationConditionOf(t, reconciler.Client, "local-cluster").Reason)
+	assert.Equal(t, []string{"Warning " + ReasonCleanupBlocked}, drainEvents(recorder))
+
+	// The remote Provider is deleted once the Plan finished
+	finished := unstructuredPlan(t, testPlan("running", "local-cluster-mtv", forkliftv1beta1.ConditionSucceeded))
+	_, err = reconciler.DynamicClient.Resource(PlansGVR).Namespace("migrations").Update(context.TODO(), finished,
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	result, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	_, err = reconciler.DynamicClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"local-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, []string{"Normal " + ReasonHostProviderMapped}, drainEvents(recorder))
+}
+
+func TestReconcile_LocalClusterWaitsForHostProvider(t *testing.T) {
+	reconciler, _ := localClusterSetup(t)
+
+	result, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "local-cluster"}})
+	require.NoError(t, err)
+	assert.Equal(t, ProviderReadyCheckInterval, result.RequeueAfter)
+	assert.Equal(t, string(PhaseHostProviderMissing), integrationConditionOf(t, reconciler.Client, "local-cluster").Reason)
+}
+
+func TestHostProvider_PrefersHostName(t *testing.T) {
+	reconciler, _ := localClusterSetup(t, hostProviderOf("another", "openshift-mtv", "True"),
+		hostProviderOf("host", "openshift-mtv", "False"))
+
+	host, err := reconciler.hostProvider(context.TODO())
+	require.NoError(t, err)
+	require.NotNil(t, host)
+	assert.Equal(t, "host", host.GetName())
+}
+
+func TestHostProvider_OnlyInForkliftNamespace(t *testing.T) {
+	// A Provider without a URL created by a user outside of the Forklift namespace
+	reconciler, _ := localClusterSetup(t, hostProviderOf("host", "team-a", "True"))
+
+	host, err := reconciler.hostProvider(context.TODO())
+	require.NoError(t, err)
+	assert.Nil(t, host)
+	assert.Empty(t, reconciler.clusterForProvider(context.TODO(), hostProviderOf("host", "team-a", "True")))
+
+	// Forklift is installed in another namespace
+	reconciler.ForkliftNamespace = "team-a"
+	host, err = reconciler.hostProvider(context.TODO())
+	require.NoError(t, err)
+	require.NotNil(t, host)
+	assert.Equal(t, "team-a", host.GetNamespace())
+}
+
+func TestClusterForProvider_HostProvider(t *testing.T) {
+	reconciler, _ := localClusterSetup(t)
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "local-cluster"}}},
+		reconciler.clusterForProvider(context.TODO(), hostProviderOf("host", "openshift-mtv", "True")))
+}
@@ -3,27 +3,34 @@ package controllers
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"sort"
+	"strconv"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
 )
 
@@ -32,6 +39,59 @@ type ManagedClusterReconciler struct {
 	client.Client
 	Scheme        *runtime.Scheme
 	DynamicClient dynamic.Interface
+	// DefaultRBACProfile is the RBAC profile used for clusters that do not select one
+	DefaultRBACProfile string
+	// DefaultRBACClusterRole is the ClusterRole used by the custom profile when the cluster does not name one
+	DefaultRBACClusterRole string
+	// DefaultEndpointSelection selects the API server endpoint of clusters that do not set EndpointSelectionKey
+	DefaultEndpointSelection string
+	// DefaultConnectionMode is the connection mode of clusters that do not set ConnectionModeKey, empty is
+	// direct
+	DefaultConnectionMode string
+	// ClusterProxyURL is the URL of the cluster-proxy user server. Empty is DefaultClusterProxyURL.
+	ClusterProxyURL string
+	// ClusterProxyCA is the ConfigMap with the CA of the cluster-proxy user server. The zero value is
+	// DefaultClusterProxyCAConfigMap.
+	ClusterProxyCA types.NamespacedName
+	// AllowInsecureSkipVerify allows clusters to disable the TLS verification of their Provider
+	AllowInsecureSkipVerify bool
+	// UnavailableGracePeriod is how long a ManagedCluster can be unavailable before its Provider is degraded.
+	// Zero disables the availability check.
+	UnavailableGracePeriod time.Duration
+	// TokenValidity is the validity of the ManagedServiceAccount tokens of clusters that do not set
+	// TokenValidityKey. Zero is DefaultTokenValidity.
+	TokenValidity time.Duration
+	// AnnotateUnavailableProviders annotates the Provider of a degraded cluster so new Plans are rejected
+	AnnotateUnavailableProviders bool
+	// AgentNamespace is the namespace of the managed-serviceaccount agent on every ManagedCluster. When it is
+	// empty, the namespace is read from the managed-serviceaccount ManagedClusterAddOn of each cluster.
+	AgentNamespace string
+	// ForkliftNamespace is the namespace Forklift is installed in, where it creates the host provider of the
+	// local cluster. Empty is DefaultForkliftNamespace.
+	ForkliftNamespace string
+	// AdoptProviders adopts the openshift Provider created by hand that connects to the API server of a
+	// ManagedCluster instead of creating a second Provider for the cluster
+	AdoptProviders bool
+	// AdoptNamespaces are the namespaces, besides the integration namespace, whose Providers can be adopted
+	AdoptNamespaces []string
+	// Integration is the namespace, naming and selection label convention shared with the Plan webhook. The
+	// zero value is the default convention.
+	Integration IntegrationConfig
+	// Recorder emits Events on the ManagedCluster and its Provider. No Events are emitted when it is nil.
+	Recorder events.EventRecorder
+	// Sharder splits the ManagedClusters across the replicas, each replica only reconciles its shards. Every
+	// replica reconciles every ManagedCluster it is told about when it is nil.
+	Sharder *Sharder
+	// DryRun sends every write as a server-side dry run and reports the planned changes in the logs and the
+	// DryRunReport ConfigMap instead of making them. It cannot be used with a Sharder.
+	DryRun bool
+	// DryRunReport is the ConfigMap of the planned changes. The zero value is the DefaultDryRunReportName
+	// ConfigMap in the namespace the controller runs in.
+	DryRunReport types.NamespacedName
+
+	providerWatch providerWatch
+	resourceCache resourceCache
+	dryRunReport  *DryRunReport
 }
 
 const (
@@ -44,10 +104,16 @@ const (
 //nolint:revive // Added by kubebuilder
 //+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=managedclusters,verbs=get;list;watch
 //nolint:revive // Added by kubebuilder
-//+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=managedclusters/status,verbs=get
+//+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=managedclusters/status,verbs=get;update;patch
 //nolint:revive // Added by kubebuilder
 //+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=managedclusters/finalizers,verbs=update
 //nolint:revive,lll // Added by kubebuilder
+//+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=placementdecisions,verbs=get;list;watch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=placements,verbs=get;list;watch
+//nolint:revive,lll // Added by kubebuilder
+//+kubebuilder:rbac:groups=addon.open-cluster-management.io,resources=managedclusteraddons,verbs=get;list;watch
+//nolint:revive,lll // Added by kubebuilder
 //+kubebuilder:rbac:groups=rbac.open-cluster-management.io,resources=clusterpermissions,verbs=get;list;watch;create;update;patch;delete
 //nolint:revive // Added by kubebuilder
 //+kubebuilder:rbac:groups=rbac.open-cluster-management.io,resources=clusterpermissions/status,verbs=get;update;patch
@@ -56,11 +122,27 @@ const (
 //nolint:revive,lll // Added by kubebuilder
 //+kubebuilder:rbac:groups=authentication.open-cluster-management.io,resources=managedserviceaccounts/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups=forklift.konveyor.io,resources=plans,verbs=get;list;watch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups=forklift.konveyor.io,resources=migrations,verbs=get;list;watch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+//nolint:revive // Added by kubebuilder
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
 
 // Reconcile handles the reconciliation of ManagedCluster resources for MTV integration
 // Refactored to reduce cognitive complexity from 51 to under 50 for SonarQube compliance
 func (r *ManagedClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
+	// Another replica reconciles the clusters of the shards it holds
+	if !r.Sharder.Owns(req.Name) {
+		reconcilesTotal.WithLabelValues(reconcileOtherShard).Inc()
+		return ctrl.Result{}, nil
+	}
+	ctx = r.dryRunContext(ctx, req.Name)
 
 	// Early exit if Provider CRD is not established - do not log "Reconciling" in this case
 	crdEstablished, err := r.checkProviderCRD(ctx)
@@ -71,93 +153,251 @@ func (r *ManagedClusterReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 
 	if !crdEstablished {
 		log.Info("Provider CRD is not established, skipping reconciliation")
+		r.reportCRDMissing(ctx, req)
+		reconcilesTotal.WithLabelValues(reconcileCRDMissing).Inc()
 		return ctrl.Result{}, nil // CRD is not established, do not proceed with reconciliation
 	}
+	if err := r.watchProviders(); err != nil {
+		log.Error(err, "Failed to watch the Providers")
+		return ctrl.Result{}, err
+	}
 
 	// Fetch the ManagedCluster instance
 	managedCluster := &clusterv1.ManagedCluster{}
 	if err := r.Get(ctx, req.NamespacedName, managedCluster); err != nil {
+		if errors.IsNotFound(err) {
+			onboarding.forget(req.Name)
+			reconcilesTotal.WithLabelValues(reconcileSkipped).Inc()
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = resyncContext(ctx, managedCluster)
+
+	// A deleted cluster is cleaned up whatever its selection
+	selected, err := r.clusterSelected(ctx, managedCluster)
+	if err != nil && managedCluster.GetDeletionTimestamp() == nil {
+		log.Error(err, "Failed to check if the ManagedCluster is selected")
+		return ctrl.Result{}, err
+	}
+
+	// The resources of a paused cluster are left as they are, its status is still reported
+	if integrationPaused(managedCluster) &&
+		(r.shouldCleanupCluster(managedCluster, selected) || r.shouldManageCluster(managedCluster, selected)) {
+		reconcilesTotal.WithLabelValues(reconcilePaused).Inc()
+		return r.reportPaused(ctx, managedCluster)
+	}
+	r.reportResumed(managedCluster)
 
 	// Add cleanup before reconcileActiveCluster to avoid unnecessary steps
-	if r.shouldCleanupCluster(managedCluster) {
+	if r.shouldCleanupCluster(managedCluster, selected) {
+		reconcilesTotal.WithLabelValues(reconcileOffboard).Inc()
+		blocked, err := r.cleanupBlocked(ctx, managedCluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if blocked {
+			return ctrl.Result{RequeueAfter: CleanupBlockedCheckInterval}, nil
+		}
 		return ctrl.Result{}, r.cleanupManagedClusterResources(ctx, managedCluster)
 	}
 
 	// Handle active cluster lifecycle
-	if r.shouldManageCluster(managedCluster) {
+	if r.shouldManageCluster(managedCluster, selected) {
+		reconcilesTotal.WithLabelValues(reconcileOnboard).Inc()
 		return r.reconcileActiveCluster(ctx, managedCluster)
 	}
 
 	// Only log "Reconciling" after we know we will actually proceed
 	log.Info("Reconciling ManagedCluster", "name", req.NamespacedName)
+	onboarding.forget(managedCluster.GetName())
+	reconcilesTotal.WithLabelValues(reconcileSkipped).Inc()
 
 	return ctrl.Result{}, nil
 }
 
 // Helper methods to reduce cognitive complexity in Reconcile function
 
+// reportCRDMissing records on a cluster labeled for MTV that it cannot be onboarded until the
+// Provider CRD is established
+func (r *ManagedClusterReconciler) reportCRDMissing(ctx context.Context, req ctrl.Request) {
+	managedCluster := &clusterv1.ManagedCluster{}
+	if err := r.Get(ctx, req.NamespacedName, managedCluster); err != nil {
+		return
+	}
+	selected, err := r.clusterSelected(ctx, managedCluster)
+	if err != nil {
+		return
+	}
+	if r.shouldManageCluster(managedCluster, selected) {
+		r.setIntegrationPhase(ctx, managedCluster, PhaseCRDMissing, nil)
+		onboarding.observe(managedCluster.GetName(), PhaseCRDMissing, nil)
+	}
+}
+
 // shouldCleanupCluster determines if the cluster should be cleaned up.
 // When the cluster is being deleted we always attempt cleanup regardless of
 // whether our finalizer is present – a Provider may have been created before
 // the finalizer was persisted.
-func (r *ManagedClusterReconciler) shouldCleanupCluster(managedCluster *clusterv1.ManagedCluster) bool {
+func (r *ManagedClusterReconciler) shouldCleanupCluster(managedCluster *clusterv1.ManagedCluster, selected bool) bool {
 	if managedCluster.GetDeletionTimestamp() != nil {
 		return true
 	}
-	return managedCluster.GetLabels()[LabelCNVOperatorInstall] != cnvOperatorInstallEnabled &&
+	return !selected &&
 		controllerutil.ContainsFinalizer(managedCluster, ManagedClusterFinalizer)
 }
 
 // shouldManageCluster determines if the cluster should be managed
-func (r *ManagedClusterReconciler) shouldManageCluster(managedCluster *clusterv1.ManagedCluster) bool {
-	// Must have the CNV label AND must NOT be in the process of deletion.
+func (r *ManagedClusterReconciler) shouldManageCluster(managedCluster *clusterv1.ManagedCluster, selected bool) bool {
+	// Must be selected by the CNV label or the Placement AND must NOT be in the process of deletion.
 	// Without the deletion check, a cluster being deleted (without our finalizer yet)
 	// would fall through here and we'd try to add a finalizer — which Kubernetes forbids
 	// on objects that already have a deletionTimestamp.
-	return managedCluster.GetDeletionTimestamp() == nil &&
-		managedCluster.GetLabels()[LabelCNVOperatorInstall] == cnvOperatorInstallEnabled
+	return managedCluster.GetDeletionTimestamp() == nil && selected
 }
 
-// reconcileActiveCluster handles the complete lifecycle for active MTV clusters
+// reconcileActiveCluster handles the complete lifecycle for active MTV clusters. The phase reached by
+// the steps is written to the ManagedCluster status when it returns.
 func (r *ManagedClusterReconciler) reconcileActiveCluster(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
-) (ctrl.Result, error) {
-	managedClusterMTV := managedClusterMTVName(managedCluster.GetName())
+) (result ctrl.Result, err error) {
+	managedClusterMTV := r.integration().ResourceName(managedCluster.GetName())
+	unavailable, graceLeft := r.clusterAvailability(managedCluster, time.Now())
+
+	status := &integrationStatus{}
+	defer func() {
+		// Check the cluster again when its grace period ends, no event is sent at that time
+		if err == nil && graceLeft > 0 && (result.RequeueAfter == 0 || graceLeft < result.RequeueAfter) {
+			result.RequeueAfter = graceLeft
+		}
+		if status.phase != "" {
+			r.setIntegrationPhase(ctx, managedCluster, status.phase, status.err)
+			onboarding.observe(managedCluster.GetName(), status.phase, status.err)
+			recordStepError(status.phase, status.err)
+			r.recordPhaseFailure(managedCluster, status.phase, status.err)
+		}
+	}()
 
 	// Ensure finalizer is present - if it wasn't there, we need to requeue
 	finalizerWasAdded := !controllerutil.ContainsFinalizer(managedCluster, ManagedClusterFinalizer)
 	if err := r.ensureFinalizerAndNamespace(ctx, managedCluster); err != nil {
+		status.record(PhaseFinalizerAdded, err)
 		return ctrl.Result{}, err
 	}
 
-	// If finalizer was just added, requeue to ensure it's processed
+	// If finalizer was just added, requeue to ensure it's processed. A dry run continues to plan the
+	// following changes, the finalizer is never added.
 	if finalizerWasAdded {
-		return ctrl.Result{}, nil // Requeue to ensure the finalizer is added
+		status.record(PhaseFinalizerAdded, nil)
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonFinalizerAdded, actionOnboard,
+			"Added the %s finalizer to clean up the MTV resources", ManagedClusterFinalizer)
+		if r.dryRunReport == nil {
+			return ctrl.Result{}, nil // Requeue to ensure the finalizer is added
+		}
+	}
+
+	// The hub's self-managed cluster uses the Forklift host provider instead of a remote-style Provider
+	if isLocalCluster(managedCluster) {
+		return r.reconcileLocalCluster(ctx, managedCluster, status)
+	}
+
+	// The Provider of a cluster unavailable for longer than the grace period is degraded until it is back
+	if unavailable {
+		if err := r.pauseProvider(ctx, managedCluster); err != nil {
+			status.record(PhaseClusterUnavailable, err)
+			return ctrl.Result{}, err
+		}
+		status.record(PhaseClusterUnavailable, clusterUnavailableError(managedCluster))
+		return ctrl.Result{}, nil
+	}
+	if err := r.resumeProvider(ctx, managedCluster); err != nil {
+		status.record(PhaseClusterUnavailable, err)
+		return ctrl.Result{}, err
 	}
 
 	// Handle ManagedServiceAccount lifecycle
 	managedServiceAccount, result, err := r.handleManagedServiceAccount(ctx, managedCluster, managedClusterMTV)
-	if err != nil || result.RequeueAfter > 0 {
+	if err != nil || (result.RequeueAfter > 0 && r.dryRunReport == nil) {
+		status.record(PhaseServiceAccountPending, err)
 		return result, err
 	}
+	// A token rotation requested on the cluster replaces the ManagedServiceAccount, wait for the revocation
+	rotationStarted, err := r.startTokenRotation(ctx, managedCluster, managedServiceAccount)
+	if err != nil || rotationStarted {
+		status.record(PhaseServiceAccountPending, err)
+		return ctrl.Result{RequeueAfter: TokenRevocationDelay}, err
+	}
+	if tokenSecretReady(managedServiceAccount) {
+		status.record(PhaseTokenReady, nil)
+	} else {
+		status.record(PhaseServiceAccountPending, nil)
+	}
 
 	// Reconcile cluster permissions
 	if err := r.reconcileClusterPermissions(ctx, managedCluster); err != nil {
+		status.record(PhasePermissionApplied, err)
 		return ctrl.Result{}, err
 	}
+	status.record(PhasePermissionApplied, nil)
+
+	// Select the API server endpoint, a Provider without a URL cannot connect
+	endpoint, err := r.providerEndpoint(ctx, managedCluster)
+	if err != nil {
+		// The ManagedCluster has to change for this to succeed, its update triggers the next reconcile
+		status.record(PhaseEndpointUnavailable, err)
+		return ctrl.Result{}, nil
+	}
 
 	// Handle provider secrets synchronization
-	if err := r.handleProviderSecrets(ctx, managedCluster, managedServiceAccount, managedClusterMTV); err != nil {
+	synced, err := r.handleProviderSecrets(ctx, managedCluster, managedServiceAccount, managedClusterMTV, endpoint)
+	if err != nil {
+		status.record(PhaseSecretSynced, err)
+		return ctrl.Result{}, err
+	}
+	if !synced {
+		// The Provider is only created once its secret holds the token
+		status.record(PhaseServiceAccountPending, nil)
+		r.planPendingProvider(ctx, managedCluster.Name)
+		if rotationInProgress(managedCluster, managedServiceAccount) {
+			return ctrl.Result{RequeueAfter: TokenWaitDuration}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+	if err := r.completeTokenRotation(ctx, managedCluster, managedServiceAccount); err != nil {
+		status.record(PhaseSecretSynced, err)
 		return ctrl.Result{}, err
 	}
+	status.record(PhaseSecretSynced, nil)
 
 	// Reconcile provider resources
-	if err := r.reconcileProviderResources(ctx, managedCluster); err != nil {
+	provider, err := r.reconcileProviderResources(ctx, managedCluster, endpoint.URL)
+	if err != nil {
+		status.record(PhaseProviderCreated, err)
+		return ctrl.Result{}, err
+	}
+	if err := r.completeResync(ctx, managedCluster); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.setProviderConditions(ctx, managedCluster, provider)
+	if err := r.retryProviderSecret(ctx, managedCluster, endpoint, provider); err != nil {
+		status.record(PhaseSecretSynced, err)
+		return ctrl.Result{}, err
+	}
+	if !providerReady(provider) {
+		status.record(PhaseProviderCreated, nil)
+		return ctrl.Result{RequeueAfter: ProviderReadyCheckInterval}, nil
+	}
+	status.record(PhaseProviderReady, nil)
+
+	// The Provider with the configured naming is ready, the resources of a legacy installation can go
+	blocked, err := r.migrateLegacyResources(ctx, managedCluster)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to delete the resources with the legacy naming")
 		return ctrl.Result{}, err
 	}
+	if blocked {
+		return ctrl.Result{RequeueAfter: CleanupBlockedCheckInterval}, nil
+	}
 
 	return ctrl.Result{}, nil
 }
@@ -167,23 +407,39 @@ func (r *ManagedClusterReconciler) ensureFinalizerAndNamespace(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
 ) error {
+	// The namespace is checked on every reconcile, the configured namespace can change after the
+	// finalizer was added
+	if err := r.ensureIntegrationNamespace(ctx); err != nil {
+		return err
+	}
+
 	if controllerutil.ContainsFinalizer(managedCluster, ManagedClusterFinalizer) {
 		return nil
 	}
 
 	log := log.FromContext(ctx)
-	log.Info("Adding finalizer and ensuring MTV namespace")
+	log.Info("Adding finalizer")
 
 	original := managedCluster.DeepCopy()
 	controllerutil.AddFinalizer(managedCluster, ManagedClusterFinalizer)
 
-	log.Info("Create the " + MTVIntegrationsNamespace + " namespace")
-	MTVNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: MTVIntegrationsNamespace}}
-	if err := r.Create(ctx, MTVNamespace); err != nil && !errors.IsAlreadyExists(err) {
+	return r.Patch(ctx, managedCluster, client.MergeFrom(original))
+}
+
+// ensureIntegrationNamespace creates the namespace of the Providers when it does not exist
+func (r *ManagedClusterReconciler) ensureIntegrationNamespace(ctx context.Context) error {
+	namespace := r.integration().Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: namespace}, &corev1.Namespace{})
+	if !errors.IsNotFound(err) {
 		return err
 	}
 
-	return r.Patch(ctx, managedCluster, client.MergeFrom(original))
+	log.FromContext(ctx).Info("Create the " + namespace + " namespace")
+	MTVNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	if err := r.Create(ctx, MTVNamespace); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
 }
 
 // handleManagedServiceAccount manages the ManagedServiceAccount lifecycle
@@ -195,19 +451,45 @@ func (r *ManagedClusterReconciler) handleManagedServiceAccount(
 	log := log.FromContext(ctx)
 	managedClusterNamespace := managedCluster.Name
 
+	validity, err := r.tokenValidity(managedCluster)
+	if err != nil {
+		log.Error(err, "Invalid token validity")
+		return nil, ctrl.Result{}, err
+	}
+
 	managedServiceAccount := &auth.ManagedServiceAccount{}
-	err := r.Get(ctx,
+	err = r.Get(ctx,
 		types.NamespacedName{Name: managedClusterMTV, Namespace: managedClusterNamespace},
 		managedServiceAccount)
 
 	if errors.IsNotFound(err) {
+		// A ManagedServiceAccount deleted to revoke its token is only created again once the agent deleted the
+		// ServiceAccount
+		if wait := tokenRevocationWait(managedCluster, time.Now()); wait > 0 {
+			log.Info("Waiting for the revocation of the token before creating the ManagedServiceAccount",
+				"wait", wait)
+			return nil, ctrl.Result{RequeueAfter: wait}, nil
+		}
 		return r.createManagedServiceAccount(ctx, managedCluster,
-			managedClusterMTV, managedClusterNamespace)
+			managedClusterMTV, managedClusterNamespace, validity)
 	} else if err != nil {
 		log.Error(err, "Failed to retrieve ManagedServiceAccount")
 		return nil, ctrl.Result{}, err
 	}
 
+	if err := r.reconcileTokenValidity(ctx, managedCluster, managedServiceAccount, validity); err != nil {
+		log.Error(err, "Failed to update the token validity of the ManagedServiceAccount")
+		return nil, ctrl.Result{}, err
+	}
+	if err := r.reconcileManagedServiceAccountOwnership(ctx, managedCluster, managedServiceAccount); err != nil {
+		log.Error(err, "Failed to label the ManagedServiceAccount")
+		return nil, ctrl.Result{}, err
+	}
+	if err := r.resyncManagedServiceAccount(ctx, managedCluster, managedServiceAccount, validity); err != nil {
+		log.Error(err, "Failed to resynchronize the ManagedServiceAccount")
+		return nil, ctrl.Result{}, err
+	}
+
 	return managedServiceAccount, ctrl.Result{}, nil
 }
 
@@ -216,23 +498,31 @@ func (r *ManagedClusterReconciler) createManagedServiceAccount(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
 	managedClusterMTV, managedClusterNamespace string,
+	validity time.Duration,
 ) (*auth.ManagedServiceAccount, ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("ManagedServiceAccount not found, creating new one")
 
 	managedServiceAccount := &auth.ManagedServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      managedClusterMTV,
-			Namespace: managedClusterNamespace,
+			Name:        managedClusterMTV,
+			Namespace:   managedClusterNamespace,
+			Labels:      ownershipMetadata(managedCluster.Name),
+			Annotations: ownershipMetadata(managedCluster.Name),
 		},
 		Spec: auth.ManagedServiceAccountSpec{
 			Rotation: auth.ManagedServiceAccountRotation{
 				Enabled:  true,
-				Validity: metav1.Duration{Duration: time.Minute * 60},
+				Validity: metav1.Duration{Duration: validity},
 			},
 		},
 	}
 
+	if _, revoked := managedCluster.GetAnnotations()[tokenRevokedAtKey]; revoked && pendingRotation(managedCluster) != "" {
+		// Created again for the rotation request, its first token completes the rotation
+		managedServiceAccount.Annotations[rotationRequestKey] = pendingRotation(managedCluster)
+	}
+
 	if err := controllerutil.SetControllerReference(
 		managedCluster, managedServiceAccount, r.Scheme,
 		controllerutil.WithBlockOwnerDeletion(false)); err != nil {
@@ -240,13 +530,15 @@ func (r *ManagedClusterReconciler) createManagedServiceAccount(
 		return nil, ctrl.Result{}, err
 	}
 
-	if err := r.Create(ctx, managedServiceAccount, &client.CreateOptions{}); err != nil {
+	if err := r.Create(ctx, managedServiceAccount, client.FieldOwner(FieldManager)); err != nil {
 		log.Error(err, "Failed to create ManagedServiceAccount")
 		return nil, ctrl.Result{}, err
 	}
 
 	log.Info("Created successfully", "ManagedServiceAccount", managedServiceAccount.Name,
 		"namespace", managedClusterNamespace)
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonServiceAccountCreated, actionOnboard,
+		"Created the ManagedServiceAccount %s/%s", managedClusterNamespace, managedServiceAccount.Name)
 	return managedServiceAccount, ctrl.Result{RequeueAfter: TokenWaitDuration}, nil
 }
 
@@ -257,32 +549,42 @@ func (r *ManagedClusterReconciler) reconcileClusterPermissions(
 ) error {
 	log := log.FromContext(ctx)
 
-	msaaNamespace, err := r.findMsaaDeploymentNs(ctx)
+	msaaNamespace, err := r.agentNamespace(ctx, managedCluster)
 	if err != nil || msaaNamespace == "" {
 		log.Error(err, "Failed to find the namespace where the managed-serviceaccount-addon-agent deployment runs")
 		return err
 	}
 
-	if err := r.reconcileResource(ctx, ClusterPermissionsGVR,
-		managedCluster.Name, managedCluster.Name,
-		clusterPermissionPayload(managedCluster, msaaNamespace)); err != nil {
+	profile, err := r.rbacProfileFor(managedCluster)
+	if err != nil {
+		log.Error(err, "Failed to resolve the RBAC profile")
+		return err
+	}
+
+	// Drift repair also applies a change of the selected profile to the ClusterPermission
+	_, operation, err := r.reconcileResource(ctx, ClusterPermissionsGVR, managedCluster.Name,
+		clusterPermissionPayload(managedCluster, r.integration().ResourceName(managedCluster.Name), msaaNamespace,
+			profile))
+	if err != nil {
 		log.Error(err, "Failed to reconcile ClusterPermissions")
 		return err
 	}
+	if operation != operationNone {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonClusterPermissionApplied, actionOnboard,
+			"%s the ClusterPermission %s/%s with the %s RBAC profile", operation, managedCluster.Name,
+			r.integration().ResourceName(managedCluster.Name), profile.name)
+	}
 	return nil
 }
 
 // findMsaaDeploymentNs finds the namespace where the managed-serviceaccount-addon-agent deployment runs
 func (r *ManagedClusterReconciler) findMsaaDeploymentNs(ctx context.Context) (string, error) {
 	var depList appsv1.DeploymentList
-	if err := r.List(ctx, &depList); err != nil {
+	if err := r.List(ctx, &depList, client.MatchingFields{deploymentNameIndex: msaaDeploymentName}); err != nil {
 		return "", err
 	}
-
-	for _, d := range depList.Items {
-		if d.Name == msaaDeploymentName {
-			return d.Namespace, nil
-		}
+	if len(depList.Items) > 0 {
+		return depList.Items[0].Namespace, nil
 	}
 
 	return "", errors.NewNotFound(
@@ -291,21 +593,28 @@ func (r *ManagedClusterReconciler) findMsaaDeploymentNs(ctx context.Context) (st
 	)
 }
 
-// handleProviderSecrets manages provider secret synchronization
+// tokenSecretReady checks if the ManagedServiceAccount token secret has been issued
+func tokenSecretReady(managedServiceAccount *auth.ManagedServiceAccount) bool {
+	return managedServiceAccount.Status.TokenSecretRef != nil &&
+		managedServiceAccount.Status.TokenSecretRef.Name != ""
+}
+
+// handleProviderSecrets manages provider secret synchronization. It returns false when the
+// ManagedServiceAccount token is not issued yet.
 func (r *ManagedClusterReconciler) handleProviderSecrets(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
 	managedServiceAccount *auth.ManagedServiceAccount,
 	managedClusterMTV string,
-) error {
+	endpoint *providerEndpoint,
+) (bool, error) {
 	log := log.FromContext(ctx)
 	managedClusterNamespace := managedCluster.Name
 
 	// Check if token secret is ready
-	if managedServiceAccount.Status.TokenSecretRef == nil ||
-		managedServiceAccount.Status.TokenSecretRef.Name == "" {
+	if !tokenSecretReady(managedServiceAccount) {
 		log.Info("ManagedServiceAccount secret is not ready")
-		return nil // Will be handled on next reconcile
+		return false, nil // Will be handled on next reconcile
 	}
 
 	// Get source secret from ManagedServiceAccount using correct secret name from TokenSecretRef
@@ -316,110 +625,256 @@ func (r *ManagedClusterReconciler) handleProviderSecrets(
 	}
 
 	if err := r.Get(ctx, namespacedName, ogSecret); err != nil {
+		if errors.IsNotFound(err) {
+			// The token secret is replaced during a token rotation
+			log.Info("ManagedServiceAccount secret is not found, waiting for the token")
+			return false, nil
+		}
 		log.Error(err, "Failed to retrieve ManagedServiceAccount secret")
-		return err
+		return false, err
+	}
+
+	if ogSecret.Data == nil {
+		ogSecret.Data = map[string][]byte{}
+	}
+	if endpoint.viaClusterProxy {
+		// The Provider verifies the certificate of the cluster-proxy user server, not the one of the cluster
+		ogSecret.Data["ca.crt"] = endpoint.CABundle
+	} else {
+		// Fall back to the CA bundle of the client config when the token secret has no CA, and layer the
+		// CA bundle referenced by the cluster on top
+		caBundle, err := r.additionalCABundle(ctx, managedCluster)
+		if err != nil {
+			log.Error(err, "Failed to retrieve the CA bundle")
+			return false, err
+		}
+		if len(ogSecret.Data["ca.crt"]) == 0 {
+			ogSecret.Data["ca.crt"] = endpoint.CABundle
+		}
+		ogSecret.Data["ca.crt"] = appendCABundle(ogSecret.Data["ca.crt"], caBundle)
+	}
+
+	connection := providerConnection{
+		url:                endpoint.URL,
+		insecureSkipVerify: r.insecureSkipVerify(ctx, managedCluster),
 	}
 
 	// Create or update provider secret
-	return r.syncProviderSecret(ctx, managedCluster, ogSecret, managedClusterMTV)
+	return true, r.syncProviderSecret(ctx, managedCluster, ogSecret, managedClusterMTV, connection)
 }
 
-// syncProviderSecret synchronizes the provider secret with ManagedServiceAccount data
+// providerConnection is how the Provider connects to the API server of the ManagedCluster
+type providerConnection struct {
+	url                string
+	insecureSkipVerify bool
+}
+
+// syncProviderSecret synchronizes the provider secret with ManagedServiceAccount data and the connection
 func (r *ManagedClusterReconciler) syncProviderSecret(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
 	sourceSecret *corev1.Secret,
 	managedClusterMTV string,
+	connection providerConnection,
 ) error {
 	log := log.FromContext(ctx)
 
-	// Safely get the URL from ManagedClusterClientConfigs
-	var clusterURL string
-	if len(managedCluster.Spec.ManagedClusterClientConfigs) > 0 {
-		clusterURL = managedCluster.Spec.ManagedClusterClientConfigs[0].URL
-	}
-
-	providerSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      managedClusterMTV,
-			Namespace: MTVIntegrationsNamespace,
-			Labels: map[string]string{
-				"createdForProviderType": "openshift",
-				"createdForResourceType": "providers",
-			},
-		},
-		Data: map[string][]byte{
-			"insecureSkipVerify": []byte("false"),
-			providerSecretURLKey: []byte(clusterURL),
-		},
-	}
-
 	// Check if secret needs updating
+	namespace := r.integration().Namespace
 	namespacedName := types.NamespacedName{
 		Name:      managedClusterMTV,
-		Namespace: MTVIntegrationsNamespace,
+		Namespace: namespace,
 	}
 
-	if err := r.Get(ctx, namespacedName, providerSecret); err != nil &&
-		!errors.IsNotFound(err) {
+	// A provider secret that does not exist yet is compared as an empty secret
+	providerSecret := &corev1.Secret{}
+	if err := r.Get(ctx, namespacedName, providerSecret); err != nil && !errors.IsNotFound(err) {
 		log.Error(err, "Failed to retrieve Provider secret")
 		return err
 	}
 
-	// Update secret if data has changed
-	if r.secretNeedsUpdate(providerSecret, sourceSecret) {
-		return r.updateProviderSecret(ctx, providerSecret, sourceSecret)
+	drifted := providerSecretDrift(providerSecret, managedCluster.Name, connection)
+	if len(drifted) > 0 && providerSecret.ResourceVersion != "" {
+		log.Info("Repairing drift", "secret", managedClusterMTV, "namespace", namespace, "fields", drifted)
 	}
 
+	// Update secret if data has changed, or re-apply it for a resync
+	if len(drifted) == 0 && !r.secretNeedsUpdate(providerSecret, sourceSecret) && !resyncing(ctx) {
+		return nil
+	}
+	if err := r.updateProviderSecret(ctx, managedCluster.Name, managedClusterMTV, connection,
+		sourceSecret); err != nil {
+		return err
+	}
+	// A rotation is counted once its token reached the provider secret
+	if tokenRotated(providerSecret, sourceSecret) {
+		tokenRotationsTotal.Inc()
+	}
+	r.recordProviderSecretEvents(ctx, managedCluster, providerSecret, sourceSecret, connection)
 	return nil
 }
 
-// secretNeedsUpdate checks if the provider secret needs to be updated
+// recordProviderSecretEvents emits Events for the token and TLS verification changes of an update of the
+// provider secret. Disabling the TLS verification is also logged for auditing.
+func (r *ManagedClusterReconciler) recordProviderSecretEvents(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	previous, sourceSecret *corev1.Secret,
+	connection providerConnection,
+) {
+	secretName := r.integration().ResourceName(managedCluster.Name)
+	namespace := r.integration().Namespace
+
+	if tokenRotated(previous, sourceSecret) {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonTokenRotated, actionOnboard,
+			"Copied the rotated ManagedServiceAccount token to the provider secret %s/%s",
+			namespace, secretName)
+	} else if !bytes.Equal(previous.Data["token"], sourceSecret.Data["token"]) {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonTokenSynced, actionOnboard,
+			"Copied the ManagedServiceAccount token to the provider secret %s/%s",
+			namespace, secretName)
+	}
+
+	wasInsecure := string(previous.Data["insecureSkipVerify"]) == "true"
+	switch {
+	case connection.insecureSkipVerify && !wasInsecure:
+		log.FromContext(ctx).Info("AUDIT: TLS verification of the Provider disabled", "managedCluster",
+			managedCluster.Name, "secret", secretName, "annotation", InsecureSkipVerifyKey)
+		r.recordEvent(managedCluster, corev1.EventTypeWarning, ReasonInsecureSkipVerifyEnabled, actionOnboard,
+			"The TLS verification of the Provider is disabled by the %s annotation", InsecureSkipVerifyKey)
+	case !connection.insecureSkipVerify && wasInsecure:
+		log.FromContext(ctx).Info("AUDIT: TLS verification of the Provider enabled", "managedCluster",
+			managedCluster.Name, "secret", secretName)
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonInsecureSkipVerifyDisabled, actionOnboard,
+			"The TLS verification of the Provider is enabled")
+	}
+}
+
+// providerSecretLabels are the labels Forklift expects on a provider secret
+func providerSecretLabels() map[string]string {
+	return map[string]string{
+		"createdForProviderType": "openshift",
+		"createdForResourceType": "providers",
+	}
+}
+
+// providerSecretDrift returns the fields of the provider secret, other than the token and CA that are
+// rotated by the ManagedServiceAccount, that no longer match what the controller sets
+func providerSecretDrift(
+	providerSecret *corev1.Secret,
+	managedClusterName string,
+	connection providerConnection,
+) []string {
+	drifted := ownershipDrift(providerSecret, managedClusterName)
+	for key, value := range providerSecretLabels() {
+		if providerSecret.GetLabels()[key] != value {
+			drifted = append(drifted, "metadata.labels."+key)
+		}
+	}
+	if string(providerSecret.Data["insecureSkipVerify"]) != strconv.FormatBool(connection.insecureSkipVerify) {
+		drifted = append(drifted, "data.insecureSkipVerify")
+	}
+	if string(providerSecret.Data[providerSecretURLKey]) != connection.url {
+		drifted = append(drifted, "data."+providerSecretURLKey)
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// secretNeedsUpdate checks if the provider secret needs to be updated. A provider secret holding a
+// different token means the ManagedServiceAccount token was rotated.
 func (r *ManagedClusterReconciler) secretNeedsUpdate(
 	providerSecret, sourceSecret *corev1.Secret,
 ) bool {
-	return !bytes.Equal(providerSecret.Data["cacert"], sourceSecret.Data["ca.crt"]) ||
+	rotated := tokenRotated(providerSecret, sourceSecret)
+	return rotated || !bytes.Equal(providerSecret.Data["cacert"], sourceSecret.Data["ca.crt"]) ||
 		!bytes.Equal(providerSecret.Data["token"], sourceSecret.Data["token"])
 }
 
-// updateProviderSecret updates the provider secret with new data
+// tokenRotated checks if the provider secret holds an earlier token of the ManagedServiceAccount
+func tokenRotated(providerSecret, sourceSecret *corev1.Secret) bool {
+	return len(providerSecret.Data["token"]) > 0 &&
+		!bytes.Equal(providerSecret.Data["token"], sourceSecret.Data["token"])
+}
+
+// updateProviderSecret server-side applies the provider secret with the current provider details
 func (r *ManagedClusterReconciler) updateProviderSecret(
 	ctx context.Context,
-	providerSecret, sourceSecret *corev1.Secret,
+	managedClusterName, managedClusterMTV string,
+	connection providerConnection,
+	sourceSecret *corev1.Secret,
 ) error {
 	log := log.FromContext(ctx)
-	log.Info("Adding provider details to secret", "secret", providerSecret.Name,
-		"namespace", MTVIntegrationsNamespace)
-
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, providerSecret, func() error {
-		providerSecret.Data["cacert"] = sourceSecret.Data["ca.crt"]
-		providerSecret.Data["token"] = sourceSecret.Data["token"]
-		return nil
-	})
-
-	if err != nil {
-		log.Error(err, "Failed to create or patch", "secret", providerSecret.Name,
-			"namespace", MTVIntegrationsNamespace)
+	namespace := r.integration().Namespace
+	log.Info("Adding provider details to secret", "secret", managedClusterMTV, "namespace", namespace)
+
+	providerSecret := corev1ac.Secret(managedClusterMTV, namespace).
+		WithLabels(providerSecretOwnership(managedClusterName)).
+		WithAnnotations(ownershipMetadata(managedClusterName)).
+		WithData(map[string][]byte{
+			"insecureSkipVerify": []byte(strconv.FormatBool(connection.insecureSkipVerify)),
+			providerSecretURLKey: []byte(connection.url),
+			"cacert":             sourceSecret.Data["ca.crt"],
+			"token":              sourceSecret.Data["token"],
+		})
+
+	if err := r.Apply(ctx, providerSecret, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply", "secret", managedClusterMTV, "namespace", namespace)
 		return err
 	}
 
-	log.Info("Created or Patched successfully", "secret", providerSecret.Name,
-		"namespace", MTVIntegrationsNamespace)
+	log.Info("Applied successfully", "secret", managedClusterMTV, "namespace", namespace)
 	return nil
 }
 
-// reconcileProviderResources handles provider resource reconciliation
+// reconcileProviderResources handles provider resource reconciliation and returns the Provider
 func (r *ManagedClusterReconciler) reconcileProviderResources(
 	ctx context.Context,
 	managedCluster *clusterv1.ManagedCluster,
-) error {
-	if err := r.reconcileResource(ctx, ProvidersGVR, managedCluster.Name,
-		MTVIntegrationsNamespace, providerPayload(managedCluster)); err != nil {
-		log := log.FromContext(ctx)
+	clusterURL string,
+) (*unstructured.Unstructured, error) {
+	log := log.FromContext(ctx)
+	integration := r.integration()
+	namespace := integration.Namespace
+	payload := providerPayload(managedCluster.Name, integration.ResourceName(managedCluster.Name), namespace,
+		clusterURL)
+
+	// A Provider created by hand for the cluster is adopted instead of creating a second one
+	adopted, adopting, err := r.providerToAdopt(ctx, managedCluster)
+	if err != nil {
+		log.Error(err, "Failed to look up the Provider to adopt")
+		return nil, err
+	}
+	if adopted != nil {
+		namespace = adopted.GetNamespace()
+		payload = r.adoptedProviderPayload(managedCluster, adopted, clusterURL)
+	}
+
+	provider, operation, err := r.reconcileResource(ctx, ProvidersGVR, namespace, payload)
+	if err != nil {
 		log.Error(err, "Failed to reconcile Provider")
-		return err
+		return nil, err
 	}
-	return nil
+	if adopting {
+		return provider, r.completeAdoption(ctx, managedCluster, adopted)
+	}
+
+	reason := ReasonProviderCreated
+	if operation == operationRepaired {
+		reason = ReasonProviderUpdated
+	}
+	if operation != operationNone {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, reason, actionOnboard,
+			"%s the Provider %s/%s", operation, namespace, provider.GetName())
+		r.recordEvent(provider, corev1.EventTypeNormal, reason, actionOnboard,
+			"%s for the ManagedCluster %s", operation, managedCluster.Name)
+	}
+	return provider, nil
+}
+
+// providerReady checks the Ready condition that Forklift sets on the Provider
+func providerReady(provider *unstructured.Unstructured) bool {
+	return conditionTrue(providerCondition(provider, "Ready"))
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -427,89 +882,145 @@ func (r *ManagedClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	log := mgr.GetLogger().WithName("controllers.ManagedClusterReconciler.SetupWithManager")
 	log.Info("Initializing ManagedCluster controller setup")
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1.ManagedCluster{}).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &clusterv1.ManagedCluster{},
+		caBundleIndex, indexCABundleRef); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appsv1.Deployment{},
+		deploymentNameIndex, indexDeploymentName); err != nil {
+		return err
+	}
+
+	bldr := ctrl.NewControllerManagedBy(mgr).
+		// Only the ManagedCluster changes that matter to the integration are reconciled
+		For(&clusterv1.ManagedCluster{}, builder.WithPredicates(managedClusterPredicate())).
 		Owns(&auth.ManagedServiceAccount{}). // Watch ManagedServiceAccounts owned by ManagedClusters
-		Watches(
-			// Watch the Provider CRD
-			&apiextensionsv1.CustomResourceDefinition{},
-			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
-				// Only react to the specific Provider CRD
-				if obj.GetName() != ProviderCRDName {
-					return nil
-				}
-				// List all ManagedClusters and enqueue a reconcile for each
-				var reqs []reconcile.Request
-				var mcList clusterv1.ManagedClusterList
-				if err := r.List(ctx, &mcList); err != nil {
-					log.Error(err, "Failed to list ManagedClusters on Provider CRD event")
-					return nil
-				}
-				for _, mc := range mcList.Items {
-					reqs = append(reqs, reconcile.Request{
-						NamespacedName: types.NamespacedName{
-							Name:      mc.Name,
-							Namespace: mc.Namespace, // Namespace is empty for ManagedCluster
-						},
-					})
-				}
-				return reqs
-			}),
-		).Complete(r)
+		// Watch the CA bundles referenced by ManagedClusters to keep the provider secrets in sync
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.clustersForCABundle(caBundleKindConfigMap))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.clustersForCABundle(caBundleKindSecret))).
+		// Watch the provider secrets and ClusterPermissions so a deleted or edited one is repaired right away
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.clusterForProviderSecret)).
+		Watches(clusterPermissionObject(), handler.EnqueueRequestsFromMapFunc(r.clusterForClusterPermission)).
+		// Watch the managed-serviceaccount and cluster-proxy addons of the clusters for changes of the agent
+		// namespace and of the cluster-proxy availability
+		Watches(&addonv1alpha1.ManagedClusterAddOn{}, handler.EnqueueRequestsFromMapFunc(clusterForAgentAddon)).
+		// Watch the Provider CRD
+		Watches(&apiextensionsv1.CustomResourceDefinition{}, handler.EnqueueRequestsFromMapFunc(r.clustersForProviderCRD))
+
+	if r.integration().Placement != "" {
+		// Watch the decisions of the Placement to onboard and offboard the clusters entering and leaving them
+		bldr = bldr.Watches(&clusterv1beta1.PlacementDecision{},
+			handler.EnqueueRequestsFromMapFunc(r.clustersForPlacementDecision))
+	}
+
+	if r.Sharder != nil {
+		// Every replica reconciles its shards, the clusters of a claimed shard are sent by the Sharder
+		needLeaderElection := false
+		bldr = bldr.WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+			WatchesRawSource(source.Channel(r.Sharder.clusterEvents(), &handler.EnqueueRequestForObject{}))
+		if err := mgr.Add(r.Sharder); err != nil {
+			return err
+		}
+	}
+
+	// The Providers are watched once their CRD is established, see watchProviders
+	c, err := bldr.Build(r)
+	if err != nil {
+		return err
+	}
+	r.providerWatch.controller = c
+	r.providerWatch.cache = mgr.GetCache()
+	// The ClusterPermission, ManagedServiceAccount and Secret informers are started by the watches above, the
+	// Provider one by watchProviders
+	r.resourceCache.reader = mgr.GetCache()
+	r.resourceCache.start(ClusterPermissionsGVR)
+	r.resourceCache.start(ManagedServiceAccountsGVR)
+	r.resourceCache.start(ProviderSecretGVR)
+
+	if r.DryRun {
+		return r.setupDryRun(mgr)
+	}
+	return nil
 }
 
+// resourceOperation is the write reconcileResource made to bring a resource to the desired state
+type resourceOperation string
+
+const (
+	operationNone     resourceOperation = ""
+	operationCreated  resourceOperation = "Created"
+	operationRepaired resourceOperation = "Repaired"
+)
+
+// reconcileResource creates the resource from the payload when it does not exist. When it exists, the
+// fields set by the payload are compared with the live object and any drift is repaired with a
+// server-side apply. Fields owned by other managers are not touched.
 func (r *ManagedClusterReconciler) reconcileResource(
 	ctx context.Context,
 	gvr schema.GroupVersionResource,
-	managedClusterName string,
 	namespace string,
 	payload map[string]interface{},
-) error {
+) (*unstructured.Unstructured, resourceOperation, error) {
 	log := log.FromContext(ctx)
 	resourceKind := gvr.Resource
-	_, err := r.DynamicClient.Resource(gvr).Namespace(namespace).Get(
-		ctx,
-		managedClusterMTVName(managedClusterName),
-		metav1.GetOptions{})
+
+	unstructuredPayload, err := payloadToUnstructured(payload)
+	if err != nil {
+		log.Error(err, "Failed to convert "+resourceKind+" payload to unstructured")
+		return nil, operationNone, err
+	}
+
+	existing, err := r.getResource(ctx, gvr, namespace, unstructuredPayload.GetName())
 	if errors.IsNotFound(err) {
 		log.Info("Create " + resourceKind)
 
-		payloadJSON, err := json.Marshal(payload)
-		if err != nil {
-			log.Error(err, "Failed to marshal "+resourceKind+" JSON")
-			return err
+		var created *unstructured.Unstructured
+		created, err = r.DynamicClient.Resource(gvr).Namespace(namespace).Create(
+			ctx, unstructuredPayload, metav1.CreateOptions{FieldManager: FieldManager})
+		if err == nil {
+			log.Info("Created successfully", resourceKind, unstructuredPayload.GetName(), "namespace", namespace)
+			return created, operationCreated, nil
 		}
-
-		unstructuredPayload := &unstructured.Unstructured{}
-		err = json.Unmarshal(payloadJSON, unstructuredPayload)
-		if err != nil {
-			log.Error(err, "Failed to unmarshal "+resourceKind+" JSON to unstructured")
-			return err
+		if !errors.IsAlreadyExists(err) {
+			log.Error(err, "Failed to create resource", "kind", resourceKind, "namespace", namespace)
+			return nil, operationNone, err
 		}
+		// The cache has not seen the resource yet, compare the payload with the live object instead
+		existing, err = r.DynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, unstructuredPayload.GetName(),
+			metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, operationNone, err
+	}
 
-		_, err = r.DynamicClient.Resource(gvr).Namespace(namespace).Create(
-			ctx, unstructuredPayload, metav1.CreateOptions{})
-		if err != nil {
-			log.Error(err, "Failed to create resource", "kind", resourceKind, "namespace", namespace)
-			return err
+	drifted := ownedFieldDrift(unstructuredPayload.Object, existing.Object, "")
+	if len(drifted) == 0 {
+		if !resyncing(ctx) {
+			return existing, operationNone, nil
 		}
-		log.Info("Created successfully", resourceKind, managedClusterName, "namespace", namespace)
-	} else if err != nil {
-		return err
+		log.Info("Resynchronizing", resourceKind, existing.GetName(), "namespace", namespace)
 	}
-	return nil
+
+	log.Info("Repairing drift", resourceKind, existing.GetName(), "namespace", namespace, "fields", drifted)
+	applied, err := r.applyResource(ctx, gvr, existing, unstructuredPayload)
+	if err != nil {
+		log.Error(err, "Failed to repair drift", "kind", resourceKind, "namespace", namespace)
+		return nil, operationNone, err
+	}
+	log.Info("Repaired drift successfully", resourceKind, existing.GetName(), "namespace", namespace,
+		"fields", drifted)
+	return applied, operationRepaired, nil
 }
 
 func deleteResource(
 	ctx context.Context,
 	dynamicClient dynamic.Interface,
 	gvr schema.GroupVersionResource,
-	managedClusterName string,
+	managedClusterMTV string,
 	namespace string,
 ) error {
 	log := log.FromContext(ctx)
 	resourceKind := gvr.Resource
-	managedClusterMTV := managedClusterMTVName(managedClusterName)
 
 	err := dynamicClient.Resource(gvr).Namespace(namespace).Delete(ctx,
 		managedClusterMTV, metav1.DeleteOptions{})
@@ -531,40 +1042,21 @@ func (r *ManagedClusterReconciler) cleanupManagedClusterResources(ctx context.Co
 ) error {
 	log := log.FromContext(ctx)
 	log.Info("The ManagedCluster is no longer labeled for CNV operator installation, cleaning up resources")
-	managedClusterName := managedCluster.GetName()
-	// Delete the following resources if they exist:
-	//  * ClusterPermission
-	//  * ManagedServiceAccount
-	//  * Provider
-	if err := deleteResource(ctx,
-		r.DynamicClient,
-		ClusterPermissionsGVR,
-		managedClusterName,
-		managedClusterName); err != nil {
-		return err
-	}
-	if err := deleteResource(ctx,
-		r.DynamicClient,
-		ManagedServiceAccountsGVR,
-		managedClusterName,
-		managedClusterName); err != nil {
-		return err
-	}
-	if err := deleteResource(ctx,
-		r.DynamicClient,
-		ProviderSecretGVR,
-		managedClusterName,
-		MTVIntegrationsNamespace); err != nil {
+	r.setIntegrationPhase(ctx, managedCluster, PhaseCleaningUp, nil)
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonCleanupStarted, actionOffboard,
+		"Removing the MTV resources of the cluster")
+
+	if err := r.deleteManagedClusterResources(ctx, managedCluster); err != nil {
+		r.setIntegrationPhase(ctx, managedCluster, PhaseCleaningUp, err)
+		recordStepError(PhaseCleaningUp, err)
+		r.recordPhaseFailure(managedCluster, PhaseCleaningUp, err)
 		return err
 	}
+	r.removeIntegrationPhase(ctx, managedCluster)
+	onboarding.forget(managedCluster.GetName())
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonCleanupFinished, actionOffboard,
+		"Removed the MTV resources of the cluster")
 
-	if err := deleteResource(ctx,
-		r.DynamicClient,
-		ProvidersGVR,
-		managedClusterName,
-		MTVIntegrationsNamespace); err != nil {
-		return err
-	}
 	original := managedCluster.DeepCopy()
 	if !controllerutil.RemoveFinalizer(managedCluster, ManagedClusterFinalizer) {
 		log.Info("Finalizer not found, nothing to remove")
@@ -578,14 +1070,45 @@ func (r *ManagedClusterReconciler) cleanupManagedClusterResources(ctx context.Co
 	return nil
 }
 
+// deleteManagedClusterResources deletes the resources created for the ManagedCluster: by name, including the
+// ones with the legacy naming that were not migrated yet, then by their ownership labels, and the Provider
+// adopted for it
+func (r *ManagedClusterReconciler) deleteManagedClusterResources(ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) error {
+	managedClusterName := managedCluster.GetName()
+	// Delete the following resources if they exist:
+	//  * ClusterPermission
+	//  * ManagedServiceAccount
+	//  * Provider secret
+	//  * Provider
+	resources := integrationResources(r.integration(), managedClusterName)
+	resources = append(resources, r.legacyResources(managedClusterName)...)
+	for _, resource := range resources {
+		if err := deleteResource(ctx, r.DynamicClient, resource.gvr, resource.name, resource.namespace); err != nil {
+			return err
+		}
+	}
+	if err := r.deleteOwnedResources(ctx, managedClusterName); err != nil {
+		return err
+	}
+	return r.deleteAdoptedProvider(ctx, managedCluster)
+}
+
+// managedClusterMTVName is the name of the resources created for the ManagedCluster with the default
+// configuration
 func managedClusterMTVName(name string) string {
-	return name + "-mtv"
+	return DefaultIntegrationConfig().ResourceName(name)
 }
 
 func (r *ManagedClusterReconciler) checkProviderCRD(ctx context.Context) (bool, error) {
-	// Check if the Provider CRD is established
+	return r.crdEstablished(ctx, ProviderCRDName)
+}
+
+// crdEstablished checks if the CRD with the <plural>.<group> name is established
+func (r *ManagedClusterReconciler) crdEstablished(ctx context.Context, name string) (bool, error) {
 	crd := &apiextensionsv1.CustomResourceDefinition{}
-	err := r.Get(ctx, types.NamespacedName{Name: ProviderCRDName}, crd)
+	err := r.Get(ctx, types.NamespacedName{Name: name}, crd)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return false, nil
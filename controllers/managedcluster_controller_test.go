@@ -8,17 +8,23 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -35,6 +41,98 @@ var providerCrd = &apiextensionsv1.CustomResourceDefinition{
 	},
 }
 
+// testReconcilerConfig holds the objects of the fake clients of newTestReconciler
+type testReconcilerConfig struct {
+	objects            []client.Object
+	statusSubresources []client.Object
+	dynamicObjects     []runtime.Object
+	cachedObjects      []runtime.Object
+	cache              bool
+}
+
+// testReconcilerOption configures the fake clients of newTestReconciler
+type testReconcilerOption func(*testReconcilerConfig)
+
+// withObjects adds objects to the fake client
+func withObjects(objs ...client.Object) testReconcilerOption {
+	return func(c *testReconcilerConfig) { c.objects = append(c.objects, objs...) }
+}
+
+// withStatusSubresource makes the fake client only update the status of the objects with a status update
+func withStatusSubresource(objs ...client.Object) testReconcilerOption {
+	return func(c *testReconcilerConfig) { c.statusSubresources = append(c.statusSubresources, objs...) }
+}
+
+// withDynamicObjects adds objects to the fake dynamic client
+func withDynamicObjects(objs ...runtime.Object) testReconcilerOption {
+	return func(c *testReconcilerConfig) { c.dynamicObjects = append(c.dynamicObjects, objs...) }
+}
+
+// withCachedObjects sets a fake manager cache holding the objects. No kind is read from it until its informer
+// is started.
+func withCachedObjects(objs ...runtime.Object) testReconcilerOption {
+	return func(c *testReconcilerConfig) {
+		c.cache = true
+		c.cachedObjects = append(c.cachedObjects, objs...)
+	}
+}
+
+// testScheme returns a scheme with the types the controller reads and writes
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = coordinationv1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+	return scheme
+}
+
+// newTestReconciler returns a reconciler with a fake client holding the established Provider CRD, a fake
+// dynamic client that handles server-side apply and lists the integration resources, Plans and Migrations, and
+// a fake event recorder
+func newTestReconciler(
+	t *testing.T,
+	opts ...testReconcilerOption,
+) (*ManagedClusterReconciler, *fake.FakeDynamicClient, *events.FakeRecorder) {
+	t.Helper()
+	config := &testReconcilerConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	scheme := testScheme()
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithIndex(&clusterv1.ManagedCluster{}, caBundleIndex, indexCABundleRef).
+		WithStatusSubresource(config.statusSubresources...).
+		WithObjects(append([]client.Object{providerCrd.DeepCopy()}, config.objects...)...).Build()
+
+	listKinds := map[schema.GroupVersionResource]string{PlansGVR: "PlanList", MigrationsGVR: "MigrationList"}
+	for gvr, kind := range integrationListKinds {
+		listKinds[gvr] = kind
+	}
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		config.dynamicObjects...)
+	addApplyReactor(dynClient)
+	recorder := events.NewFakeRecorder(20)
+
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Recorder:      recorder,
+	}
+	if config.cache {
+		reconciler.resourceCache.reader = clientfake.NewClientBuilder().WithScheme(scheme).
+			WithRuntimeObjects(config.cachedObjects...).Build()
+	}
+	return reconciler, dynClient, recorder
+}
+
 func TestManagedClusterMTVName(t *testing.T) {
 	assert.Equal(t, "foo-mtv", managedClusterMTVName("foo"))
 }
@@ -83,6 +181,7 @@ func TestReconcile_CreatesManagedServiceAccount(t *testing.T) {
 	scheme := runtime.NewScheme()
 	_ = clusterv1.Install(scheme)
 	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
 	_ = apiextensionsv1.AddToScheme(scheme)
 
 	managedCluster := &clusterv1.ManagedCluster{
@@ -124,8 +223,10 @@ func TestReconcile_CreatesClusterPermission(t *testing.T) {
 	scheme := runtime.NewScheme()
 	_ = clusterv1.Install(scheme)
 	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
 	_ = apiextensionsv1.AddToScheme(scheme)
 	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
 
 	managedCluster := &clusterv1.ManagedCluster{
 		ObjectMeta: metav1.ObjectMeta{
@@ -150,6 +251,7 @@ func TestReconcile_CreatesClusterPermission(t *testing.T) {
 	}
 
 	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
 		WithObjects(providerCrd, managedCluster, deployment).Build()
 	dynClient := fake.NewSimpleDynamicClient(scheme)
 
@@ -185,8 +287,10 @@ func TestReconcile_CreatesClusterPermission(t *testing.T) {
 	assert.Equal(t, "test-cluster", u.GetNamespace())
 
 	// Compare the spec of the ClusterPermission with the expected payload from payloads.go
-	expectedSpec := clusterPermissionPayload(managedCluster,
-		defaultNamespace) // assuming this function exists in payloads.go
+	clusterAdmin, err := resolveRBACProfile(RBACProfileClusterAdmin, "")
+	require.NoError(t, err)
+	expectedSpec := clusterPermissionPayload(managedCluster, managedClusterMTVName(managedCluster.Name),
+		defaultNamespace, clusterAdmin) // assuming this function exists in payloads.go
 	actualSpec, found, err := unstructured.NestedMap(u.Object, "spec")
 	assert.NoError(t, err)
 	assert.True(t, found, "spec field not found in ClusterPermission")
@@ -205,6 +309,7 @@ func TestReconcile_CreatesProvider(t *testing.T) {
 	_ = corev1.AddToScheme(scheme)
 	_ = apiextensionsv1.AddToScheme(scheme)
 	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
 
 	managedCluster := &clusterv1.ManagedCluster{
 		ObjectMeta: metav1.ObjectMeta{
@@ -239,6 +344,7 @@ func TestReconcile_CreatesProvider(t *testing.T) {
 	}
 
 	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
 		WithObjects(providerCrd, managedCluster, secret, deployment).Build()
 	dynClient := fake.NewSimpleDynamicClient(scheme)
 
@@ -306,7 +412,7 @@ func TestCleanupManagedClusterResources_RemovesFinalizer(t *testing.T) {
 	}
 
 	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(providerCrd, managedCluster).Build()
-	dynClient := fake.NewSimpleDynamicClient(scheme)
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme, integrationListKinds)
 
 	reconciler := &ManagedClusterReconciler{
 		Client:        k8sClient,
@@ -554,7 +660,7 @@ func TestHelperMethods(t *testing.T) {
 				Finalizers:        []string{ManagedClusterFinalizer},
 			},
 		}
-		assert.True(t, reconciler.shouldCleanupCluster(clusterWithDeletion))
+		assert.True(t, reconciler.shouldCleanupCluster(clusterWithDeletion, false))
 
 		// Test with deletion timestamp but WITHOUT finalizer (race condition:
 		// cluster deleted before finalizer could be added). Should still cleanup
@@ -566,7 +672,7 @@ func TestHelperMethods(t *testing.T) {
 				Labels:            map[string]string{LabelCNVOperatorInstall: "true"},
 			},
 		}
-		assert.True(t, reconciler.shouldCleanupCluster(clusterDeletingNoFinalizer))
+		assert.True(t, reconciler.shouldCleanupCluster(clusterDeletingNoFinalizer, true))
 
 		// Test without label but with finalizer
 		clusterWithoutLabel := &clusterv1.ManagedCluster{
@@ -575,7 +681,7 @@ func TestHelperMethods(t *testing.T) {
 				Finalizers: []string{ManagedClusterFinalizer},
 			},
 		}
-		assert.True(t, reconciler.shouldCleanupCluster(clusterWithoutLabel))
+		assert.True(t, reconciler.shouldCleanupCluster(clusterWithoutLabel, false))
 
 		// Test with label and no finalizer (active cluster, not being deleted)
 		clusterWithLabel := &clusterv1.ManagedCluster{
@@ -584,7 +690,7 @@ func TestHelperMethods(t *testing.T) {
 				Labels: map[string]string{LabelCNVOperatorInstall: "true"},
 			},
 		}
-		assert.False(t, reconciler.shouldCleanupCluster(clusterWithLabel))
+		assert.False(t, reconciler.shouldCleanupCluster(clusterWithLabel, true))
 	})
 
 	// Test shouldManageCluster
@@ -596,14 +702,14 @@ func TestHelperMethods(t *testing.T) {
 				Labels: map[string]string{LabelCNVOperatorInstall: "true"},
 			},
 		}
-		assert.True(t, reconciler.shouldManageCluster(clusterWithLabel))
+		assert.True(t, reconciler.shouldManageCluster(clusterWithLabel, true))
 
 		clusterWithoutLabel := &clusterv1.ManagedCluster{
 			ObjectMeta: metav1.ObjectMeta{
 				Labels: map[string]string{},
 			},
 		}
-		assert.False(t, reconciler.shouldManageCluster(clusterWithoutLabel))
+		assert.False(t, reconciler.shouldManageCluster(clusterWithoutLabel, false))
 
 		// Test with label but being deleted - should NOT manage
 		clusterDeletingWithLabel := &clusterv1.ManagedCluster{
@@ -613,7 +719,7 @@ func TestHelperMethods(t *testing.T) {
 				Finalizers:        []string{"some-finalizer"},
 			},
 		}
-		assert.False(t, reconciler.shouldManageCluster(clusterDeletingWithLabel))
+		assert.False(t, reconciler.shouldManageCluster(clusterDeletingWithLabel, true))
 	})
 }
 
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Steps of the onboarding and offboarding that errors are counted for
+const (
+	StepServiceAccount    = "serviceaccount"
+	StepClusterPermission = "clusterpermission"
+	StepSecret            = "secret"
+	StepProvider          = "provider"
+	StepCleanup           = "cleanup"
+)
+
+var (
+	managedClustersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mtv_integrations_managed_clusters",
+		Help: "Number of ManagedClusters labeled for MTV",
+	})
+	providersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mtv_integrations_providers",
+		Help: "Number of Providers created for ManagedClusters",
+	})
+	providersReadyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mtv_integrations_providers_ready",
+		Help: "Number of Providers created for ManagedClusters that are Ready",
+	})
+	providerCreationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mtv_integrations_provider_creation_duration_seconds",
+		Help:    "Time from a ManagedCluster being labeled for MTV to its Provider being created",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	providerReadySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mtv_integrations_provider_ready_duration_seconds",
+		Help:    "Time from a Provider being created to it becoming Ready",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	tokenRotationsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mtv_integrations_token_rotations_total",
+		Help: "Number of ManagedServiceAccount token rotations copied to provider secrets",
+	})
+	reconcileErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mtv_integrations_reconcile_errors_total",
+		Help: "Number of errors by onboarding or offboarding step",
+	}, []string{"step"})
+	orphanedResourcesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mtv_integrations_orphaned_resources_total",
+		Help: "Number of orphaned resources found by the garbage collection, by resource and action",
+	}, []string{"resource", "action"})
+	managedClusterEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mtv_integrations_managedcluster_events_total",
+		Help: "Number of ManagedCluster update events, by whether they were reconciled or filtered out",
+	}, []string{"decision"})
+	reconcilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mtv_integrations_reconciles_total",
+		Help: "Number of ManagedCluster reconciles, by whether the cluster was onboarded, offboarded or skipped",
+	}, []string{"outcome"})
+	ownedShardsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mtv_integrations_owned_shards",
+		Help: "Number of ManagedCluster shards reconciled by the replica when sharding is enabled",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		managedClustersGauge,
+		providersGauge,
+		providersReadyGauge,
+		providerCreationSeconds,
+		providerReadySeconds,
+		tokenRotationsTotal,
+		reconcileErrorsTotal,
+		orphanedResourcesTotal,
+		managedClusterEventsTotal,
+		reconcilesTotal,
+		ownedShardsGauge,
+	)
+}
+
+// phaseSteps maps the phase a reconcile failed in to the step its error is counted for
+var phaseSteps = map[IntegrationPhase]string{
+	PhaseServiceAccountPending: StepServiceAccount,
+	PhasePermissionApplied:     StepClusterPermission,
+	PhaseSecretSynced:          StepSecret,
+	PhaseProviderCreated:       StepProvider,
+	PhaseCleaningUp:            StepCleanup,
+}
+
+// recordStepError counts an error of the step the phase belongs to
+func recordStepError(phase IntegrationPhase, err error) {
+	if step, ok := phaseSteps[phase]; ok && err != nil {
+		reconcileErrorsTotal.WithLabelValues(step).Inc()
+	}
+}
+
+// clusterOnboarding is what the controller saw of the onboarding of a single cluster
+type clusterOnboarding struct {
+	labeledAt       time.Time
+	providerAt      time.Time
+	providerPresent bool
+	providerReady   bool
+}
+
+// onboardingTracker keeps the onboarding state of the labeled clusters for the gauges and histograms.
+// The durations are only observed for clusters whose onboarding started after the controller did.
+type onboardingTracker struct {
+	mu       sync.Mutex
+	clusters map[string]*clusterOnboarding
+	now      func() time.Time
+}
+
+var onboarding = &onboardingTracker{clusters: map[string]*clusterOnboarding{}, now: time.Now}
+
+// observe records the phase a reconcile of a labeled cluster ended in
+func (t *onboardingTracker) observe(managedClusterName string, phase IntegrationPhase, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	present := (phase == PhaseProviderCreated && err == nil) || phase == PhaseProviderReady
+	ready := phase == PhaseProviderReady
+	now := t.now()
+
+	cluster, ok := t.clusters[managedClusterName]
+	if !ok {
+		cluster = &clusterOnboarding{providerPresent: present, providerReady: ready}
+		if !present {
+			cluster.labeledAt = now
+		}
+		t.clusters[managedClusterName] = cluster
+	}
+
+	if present && !cluster.providerPresent {
+		cluster.providerAt = now
+		if !cluster.labeledAt.IsZero() {
+			providerCreationSeconds.Observe(now.Sub(cluster.labeledAt).Seconds())
+		}
+	}
+	if ready && !cluster.providerReady && !cluster.providerAt.IsZero() {
+		providerReadySeconds.Observe(now.Sub(cluster.providerAt).Seconds())
+	}
+	// A reconcile that fails before reaching the Provider does not change what is known about it
+	cluster.providerPresent = cluster.providerPresent || present
+	if present {
+		cluster.providerReady = ready
+	}
+
+	t.updateGauges()
+}
+
+// forget drops a cluster that is no longer labeled for MTV
+func (t *onboardingTracker) forget(managedClusterName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.clusters, managedClusterName)
+	t.updateGauges()
+}
+
+func (t *onboardingTracker) updateGauges() {
+	var present, ready int
+	for _, cluster := range t.clusters {
+		if cluster.providerPresent {
+			present++
+		}
+		if cluster.providerReady {
+			ready++
+		}
+	}
+	managedClustersGauge.Set(float64(len(t.clusters)))
+	providersGauge.Set(float64(present))
+	providersReadyGauge.Set(float64(ready))
+}
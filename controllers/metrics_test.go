@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func histogramSamples(t *testing.T, histogram prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	metric := &dto.Metric{}
+	require.NoError(t, histogram.Write(metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestOnboardingTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := &onboardingTracker{clusters: map[string]*clusterOnboarding{}, now: func() time.Time { return now }}
+	creationCount, creationSum := histogramSamples(t, providerCreationSeconds)
+	readyCount, readySum := histogramSamples(t, providerReadySeconds)
+
+	tracker.observe("c1", PhaseFinalizerAdded, nil)
+	// c2 was onboarded before the controller started, its durations are unknown
+	tracker.observe("c2", PhaseProviderReady, nil)
+	assert.Equal(t, float64(2), testutil.ToFloat64(managedClustersGauge))
+	assert.Equal(t, float64(1), testutil.ToFloat64(providersGauge))
+	assert.Equal(t, float64(1), testutil.ToFloat64(providersReadyGauge))
+
+	now = now.Add(30 * time.Second)
+	tracker.observe("c1", PhaseProviderCreated, nil)
+	assert.Equal(t, float64(2), testutil.ToFloat64(providersGauge))
+	count, sum := histogramSamples(t, providerCreationSeconds)
+	assert.Equal(t, creationCount+1, count)
+	assert.InDelta(t, creationSum+30, sum, 0.001)
+
+	// A later failure before the Provider step keeps what is known about the Provider
+	now = now.Add(10 * time.Second)
+	tracker.observe("c1", PhaseSecretSynced, errors.New("conflict"))
+	assert.Equal(t, float64(2), testutil.ToFloat64(providersGauge))
+
+	now = now.Add(20 * time.Second)
+	tracker.observe("c1", PhaseProviderReady, nil)
+	assert.Equal(t, float64(2), testutil.ToFloat64(providersReadyGauge))
+	count, sum = histogramSamples(t, providerReadySeconds)
+	assert.Equal(t, readyCount+1, count)
+	assert.InDelta(t, readySum+30, sum, 0.001)
+
+	tracker.forget("c1")
+	tracker.forget("c2")
+	assert.Equal(t, float64(0), testutil.ToFloat64(managedClustersGauge))
+	assert.Equal(t, float64(0), testutil.ToFloat64(providersGauge))
+	assert.Equal(t, float64(0), testutil.ToFloat64(providersReadyGauge))
+}
+
+func TestRecordStepError(t *testing.T) {
+	before := testutil.ToFloat64(reconcileErrorsTotal.WithLabelValues(StepClusterPermission))
+
+	recordStepError(PhasePermissionApplied, nil)
+	recordStepError(PhasePermissionApplied, errors.New("forbidden"))
+	recordStepError(PhaseFinalizerAdded, errors.New("conflict"))
+
+	assert.Equal(t, before+1, testutil.ToFloat64(reconcileErrorsTotal.WithLabelValues(StepClusterPermission)))
+}
+
+func TestSyncProviderSecret_CountsTokenRotations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	connection := providerConnection{url: "https://api.example.com:6443"}
+	// The provider secret holds an earlier token and its URL drifted
+	providerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace},
+		Data:       map[string][]byte{"token": []byte("t1"), "url": []byte("https://api.old.example.com:6443")},
+	}
+	sourceSecret := &corev1.Secret{Data: map[string][]byte{"token": []byte("t2")}}
+	before := testutil.ToFloat64(tokenRotationsTotal)
+
+	// A rotation whose update fails is not counted, however often it is retried
+	failing := &ManagedClusterReconciler{Client: clientfake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(providerSecret.DeepCopy()).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(context.Context, client.WithWatch, runtime.ApplyConfiguration, ...client.ApplyOption) error {
+			return errors.New("conflict")
+		},
+	}).Build()}
+	for range 2 {
+		require.Error(t, failing.syncProviderSecret(context.TODO(), managedCluster, sourceSecret,
+			"test-cluster-mtv", connection))
+	}
+	assert.Equal(t, before, testutil.ToFloat64(tokenRotationsTotal))
+
+	// A rotation along a drift is counted once it is applied
+	r := &ManagedClusterReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(providerSecret).Build(),
+	}
+	require.NoError(t, r.syncProviderSecret(context.TODO(), managedCluster, sourceSecret, "test-cluster-mtv",
+		connection))
+	assert.Equal(t, before+1, testutil.ToFloat64(tokenRotationsTotal))
+
+	// The first copy of the token is not a rotation
+	require.NoError(t, r.syncProviderSecret(context.TODO(), &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "new-cluster"}}, sourceSecret, "new-cluster-mtv", connection))
+	assert.Equal(t, before+1, testutil.ToFloat64(tokenRotationsTotal))
+}
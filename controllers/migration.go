@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// integrationResource is a resource the controller creates for a ManagedCluster
+type integrationResource struct {
+	gvr       schema.GroupVersionResource
+	name      string
+	namespace string
+}
+
+// integrationResources lists the resources created for the ManagedCluster with the configuration
+func integrationResources(config IntegrationConfig, managedClusterName string) []integrationResource {
+	name := config.ResourceName(managedClusterName)
+	return []integrationResource{
+		{gvr: ClusterPermissionsGVR, name: name, namespace: managedClusterName},
+		{gvr: ManagedServiceAccountsGVR, name: name, namespace: managedClusterName},
+		{gvr: ProviderSecretGVR, name: name, namespace: config.Namespace},
+		{gvr: ProvidersGVR, name: name, namespace: config.Namespace},
+	}
+}
+
+// legacyResources lists the resources created for the ManagedCluster with the default configuration
+// that are not also created with the current configuration
+func (r *ManagedClusterReconciler) legacyResources(managedClusterName string) []integrationResource {
+	current := integrationResources(r.integration(), managedClusterName)
+
+	var legacy []integrationResource
+	for i, resource := range integrationResources(DefaultIntegrationConfig(), managedClusterName) {
+		if resource != current[i] {
+			legacy = append(legacy, resource)
+		}
+	}
+	return legacy
+}
+
+// migrateLegacyResources deletes the resources that an installation without an integration configuration
+// created for the ManagedCluster. It runs once the Provider with the configured naming is ready, so
+// migrations keep working with the old Provider until the new one can be used. The legacy resources are
+// labeled for the ManagedCluster first, so they are still cleaned up with it, and the deletion waits while
+// Plans that are not archived use the legacy Provider, since deleting it breaks them even once they ran. It
+// returns true while the Plans block the deletion.
+func (r *ManagedClusterReconciler) migrateLegacyResources(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (bool, error) {
+	legacy := r.legacyResources(managedCluster.Name)
+	if len(legacy) == 0 {
+		return false, nil
+	}
+
+	var existing []integrationResource
+	providers := map[types.NamespacedName]bool{}
+	for _, resource := range legacy {
+		obj, err := r.getResource(ctx, resource.gvr, resource.namespace, resource.name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		if err := r.labelLegacyResource(ctx, managedCluster, resource, obj); err != nil {
+			return false, err
+		}
+		existing = append(existing, resource)
+		if resource.gvr == ProvidersGVR {
+			providers[types.NamespacedName{Name: resource.name, Namespace: resource.namespace}] = true
+		}
+	}
+	if len(existing) == 0 {
+		return false, nil
+	}
+
+	plans, err := r.plansMatching(ctx, providers, planNotArchived)
+	if err != nil {
+		return false, fmt.Errorf("failed to list the Plans targeting the legacy Provider: %w", err)
+	}
+	if len(plans) > 0 {
+		if managedCluster.GetAnnotations()[ForceCleanupKey] != "true" {
+			log.FromContext(ctx).Info("Waiting for the Plans using the legacy Provider to be archived or updated "+
+				"before deleting the resources with the legacy naming", "plans", plans)
+			return true, nil
+		}
+		log.FromContext(ctx).Info("AUDIT: Forcing the deletion of the resources with the legacy naming while "+
+			"Plans use them", "plans", plans)
+	}
+
+	for _, resource := range existing {
+		log.FromContext(ctx).Info("Deleting the resource with the legacy naming", resource.gvr.Resource,
+			resource.name, "namespace", resource.namespace)
+		if err := deleteResource(ctx, r.DynamicClient, resource.gvr, resource.name, resource.namespace); err != nil {
+			return false, err
+		}
+	}
+
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonLegacyResourcesMigrated, actionOnboard,
+		"Deleted the resources with the legacy naming, the Provider %s/%s replaces the %s/%s Provider",
+		r.integration().Namespace, r.integration().ResourceName(managedCluster.Name),
+		MTVIntegrationsNamespace, managedClusterMTVName(managedCluster.Name))
+	return false, nil
+}
+
+// planNotArchived checks if the Plan is not archived, so it can still be run and needs its Providers
+func planNotArchived(plan *forkliftv1beta1.Plan) bool {
+	return !plan.Spec.Archived
+}
+
+// labelLegacyResource adds the ownership labels and annotations to a resource with the legacy naming, so it is
+// mapped to its ManagedCluster while it waits for its deletion
+func (r *ManagedClusterReconciler) labelLegacyResource(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	resource integrationResource,
+	obj *unstructured.Unstructured,
+) error {
+	if len(ownershipDrift(obj, managedCluster.Name)) == 0 {
+		return nil
+	}
+
+	ownership := ownershipMetadata(managedCluster.Name)
+	patch, err := json.Marshal(map[string]interface{}{
+		payloadKeyMetadata: map[string]interface{}{"labels": ownership, "annotations": ownership},
+	})
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Labeling the resource with the legacy naming", resource.gvr.Resource,
+		resource.name, "namespace", resource.namespace)
+	_, err = r.DynamicClient.Resource(resource.gvr).Namespace(resource.namespace).Patch(ctx, resource.name,
+		types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+func testProvider(name, namespace string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind":       "Provider",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+	}}
+}
+
+func TestLegacyResources(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{}
+	assert.Empty(t, reconciler.legacyResources("c1"), "the default configuration has no legacy resources")
+
+	// Only the namespace changed, the resources in the cluster namespace keep their name
+	reconciler.Integration = IntegrationConfig{Namespace: "migrations"}
+	assert.Equal(t, []integrationResource{
+		{gvr: ProviderSecretGVR, name: "c1-mtv", namespace: MTVIntegrationsNamespace},
+		{gvr: ProvidersGVR, name: "c1-mtv", namespace: MTVIntegrationsNamespace},
+	}, reconciler.legacyResources("c1"))
+
+	reconciler.Integration = IntegrationConfig{ProviderNamePrefix: "acm-"}
+	assert.Len(t, reconciler.legacyResources("c1"), 4)
+}
+
+func TestMigrateLegacyResources(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{PlansGVR: "PlanList",
+			MigrationsGVR: "MigrationList"},
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace),
+		testProvider("acm-test-cluster", "migrations"))
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Recorder:      recorder,
+		Integration:   IntegrationConfig{Namespace: "migrations", ProviderNamePrefix: "acm-"},
+	}
+
+	blocked, err := reconciler.migrateLegacyResources(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "expected NotFound for the legacy Provider, got err=%v", err)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace("migrations").Get(context.TODO(),
+		"acm-test-cluster", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Normal " + ReasonLegacyResourcesMigrated}, drainEvents(recorder))
+
+	// Nothing is left to migrate
+	blocked, err = reconciler.migrateLegacyResources(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.False(t, blocked)
+	assert.Empty(t, drainEvents(recorder))
+}
+
+func TestMigrateLegacyResources_WaitsForPlans(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	running := unstructuredPlan(t, testPlan("running", "test-cluster-mtv", forkliftv1beta1.ConditionExecuting))
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{PlansGVR: "PlanList",
+			MigrationsGVR: "MigrationList"},
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace),
+		testProvider("acm-test-cluster", "migrations"),
+		running,
+		// A Plan of the Provider with the configured naming does not block the migration
+		unstructuredPlan(t, testPlan("new", "acm-test-cluster", forkliftv1beta1.ConditionExecuting)))
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{
+		DynamicClient: dynClient,
+		Recorder:      recorder,
+		Integration:   IntegrationConfig{Namespace: "migrations", ProviderNamePrefix: "acm-"},
+	}
+
+	blocked, err := reconciler.migrateLegacyResources(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Empty(t, drainEvents(recorder))
+
+	// The legacy Provider is kept and labeled for its cluster
+	legacy, err := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	cluster, ok := managedClusterOf(legacy)
+	assert.True(t, ok)
+	assert.Equal(t, "test-cluster", cluster)
+	assert.Empty(t, ownershipDrift(legacy, "test-cluster"))
+
+	// A finished Plan can still be run again and keeps the legacy Provider
+	finished := testPlan("running", "test-cluster-mtv", forkliftv1beta1.ConditionSucceeded)
+	_, err = dynClient.Resource(PlansGVR).Namespace("migrations").Update(context.TODO(),
+		unstructuredPlan(t, finished), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	blocked, err = reconciler.migrateLegacyResources(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.True(t, blocked)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// The legacy resources are deleted once the Plan is archived
+	finished.Spec.Archived = true
+	_, err = dynClient.Resource(PlansGVR).Namespace("migrations").Update(context.TODO(),
+		unstructuredPlan(t, finished), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	blocked, err = reconciler.migrateLegacyResources(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.False(t, blocked)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, []string{"Normal " + ReasonLegacyResourcesMigrated}, drainEvents(recorder))
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// msaAddonName is the name of the ManagedClusterAddOn of the managed-serviceaccount addon
+	msaAddonName = "managed-serviceaccount"
+	// deploymentNameIndex indexes the Deployments by name
+	deploymentNameIndex = "metadata.name"
+)
+
+// AgentDeploymentCacheConfig restricts the Deployment cache to the managed-serviceaccount agent, the only
+// Deployment the controller reads
+func AgentDeploymentCacheConfig() cache.ByObject {
+	return cache.ByObject{Field: fields.OneTermEqualSelector("metadata.name", msaaDeploymentName)}
+}
+
+// indexDeploymentName indexes a Deployment by its name
+func indexDeploymentName(obj client.Object) []string {
+	return []string{obj.GetName()}
+}
+
+// agentNamespace returns the namespace of the managed-serviceaccount agent on the ManagedCluster, where the
+// ServiceAccount of the ManagedServiceAccount is created. The configured namespace applies to every cluster.
+// Otherwise the namespace of the managed-serviceaccount ManagedClusterAddOn of the cluster is used, so it
+// can differ per cluster, and the namespace of the agent Deployment on the hub is the last resort.
+func (r *ManagedClusterReconciler) agentNamespace(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (string, error) {
+	if r.AgentNamespace != "" {
+		return r.AgentNamespace, nil
+	}
+
+	addon := &addonv1alpha1.ManagedClusterAddOn{}
+	err := r.Get(ctx, types.NamespacedName{Name: msaAddonName, Namespace: managedCluster.Name}, addon)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if addon.Status.Namespace != "" {
+			return addon.Status.Namespace, nil
+		}
+		//nolint:staticcheck // Older addon managers only set the install namespace of the spec
+		if addon.Spec.InstallNamespace != "" {
+			return addon.Spec.InstallNamespace, nil
+		}
+	}
+
+	log.FromContext(ctx).V(1).Info("The managed-serviceaccount addon reports no namespace, looking up the agent")
+	return r.findMsaaDeploymentNs(ctx)
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAgentNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: msaaDeploymentName, Namespace: "open-cluster-management-agent-addon"},
+	}
+	otherDeployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "other"}}
+	addons := []*addonv1alpha1.ManagedClusterAddOn{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: msaAddonName, Namespace: "status-cluster"},
+			Spec:       addonv1alpha1.ManagedClusterAddOnSpec{InstallNamespace: "spec-namespace"},
+			Status:     addonv1alpha1.ManagedClusterAddOnStatus{Namespace: "status-namespace"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: msaAddonName, Namespace: "spec-cluster"},
+			Spec:       addonv1alpha1.ManagedClusterAddOnSpec{InstallNamespace: "spec-namespace"},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		configured string
+		cluster    string
+		objects    bool
+		want       string
+	}{
+		{name: "configured", configured: "agent", cluster: "status-cluster", objects: true, want: "agent"},
+		{name: "addon status", cluster: "status-cluster", objects: true, want: "status-namespace"},
+		{name: "addon spec", cluster: "spec-cluster", objects: true, want: "spec-namespace"},
+		{name: "agent Deployment", cluster: "no-addon", objects: true, want: "open-cluster-management-agent-addon"},
+		{name: "not found", cluster: "no-addon"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := clientfake.NewClientBuilder().WithScheme(scheme).
+				WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+				WithObjects(otherDeployment)
+			if tc.objects {
+				builder = builder.WithObjects(deployment, addons[0], addons[1])
+			}
+			reconciler := &ManagedClusterReconciler{Client: builder.Build(), AgentNamespace: tc.configured}
+			managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: tc.cluster}}
+
+			namespace, err := reconciler.agentNamespace(context.TODO(), managedCluster)
+			if tc.want == "" {
+				assert.True(t, apierrors.IsNotFound(err), "expected NotFound, got err=%v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, namespace)
+		})
+	}
+}
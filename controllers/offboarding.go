@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ForceCleanupKey is the ManagedCluster annotation that lets the cleanup delete the Provider while Plans
+// targeting it are still in flight, when set to "true"
+const ForceCleanupKey = "mtv-integrations.open-cluster-management.io/force-cleanup"
+
+// planInFlight checks if the Plan may still run a migration with its Provider. Only archived Plans and Plans
+// that succeeded, failed or were canceled no longer depend on it, a Plan that did not start yet may start at
+// any time.
+func planInFlight(plan *forkliftv1beta1.Plan) bool {
+	if plan.Spec.Archived {
+		return false
+	}
+	return !plan.Status.HasAnyCondition(forkliftv1beta1.ConditionSucceeded, forkliftv1beta1.ConditionFailed,
+		forkliftv1beta1.ConditionCanceled)
+}
+
+// migrationInFlight checks if the Migration did not complete yet, a pending Migration runs its Plan even
+// when the Plan reports an earlier outcome
+func migrationInFlight(migration *forkliftv1beta1.Migration) bool {
+	return migration.Status.Completed == nil &&
+		!migration.Status.HasAnyCondition(forkliftv1beta1.ConditionSucceeded, forkliftv1beta1.ConditionFailed,
+			forkliftv1beta1.ConditionCanceled)
+}
+
+// migrationPlan returns the Plan a Migration runs. A reference without a namespace is a Plan in the
+// namespace of the Migration.
+func migrationPlan(migration *forkliftv1beta1.Migration) types.NamespacedName {
+	namespace := migration.Spec.Plan.Namespace
+	if namespace == "" {
+		namespace = migration.Namespace
+	}
+	return types.NamespacedName{Name: migration.Spec.Plan.Name, Namespace: namespace}
+}
+
+// planReferences checks if the source or destination of the Plan is one of the Providers. A reference
+// without a namespace is a Provider in the namespace of the Plan.
+func planReferences(plan *forkliftv1beta1.Plan, providers map[types.NamespacedName]bool) bool {
+	for _, ref := range []corev1.ObjectReference{plan.Spec.Provider.Source, plan.Spec.Provider.Destination} {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = plan.Namespace
+		}
+		if providers[types.NamespacedName{Name: ref.Name, Namespace: namespace}] {
+			return true
+		}
+	}
+	return false
+}
+
+// inFlightPlans returns the <namespace>/<name> of the in-flight Plans targeting a Provider of the
+// ManagedCluster, including the Provider with the legacy naming and the adopted Provider
+func (r *ManagedClusterReconciler) inFlightPlans(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) ([]string, error) {
+	providers := map[types.NamespacedName]bool{}
+	resources := append(integrationResources(r.integration(), managedCluster.Name),
+		r.legacyResources(managedCluster.Name)...)
+	for _, resource := range resources {
+		if resource.gvr == ProvidersGVR {
+			providers[types.NamespacedName{Name: resource.name, Namespace: resource.namespace}] = true
+		}
+	}
+	adopted, err := r.adoptedProvider(ctx, managedCluster)
+	if err != nil {
+		return nil, err
+	}
+	if adopted != nil {
+		providers[types.NamespacedName{Name: adopted.GetName(), Namespace: adopted.GetNamespace()}] = true
+	}
+	return r.plansInFlightFor(ctx, providers)
+}
+
+// plansInFlightFor returns the <namespace>/<name> of the in-flight Plans that use one of the Providers as
+// their source or destination, and of the Plans with an in-flight Migration
+func (r *ManagedClusterReconciler) plansInFlightFor(
+	ctx context.Context,
+	providers map[types.NamespacedName]bool,
+) ([]string, error) {
+	return r.plansMatching(ctx, providers, planInFlight)
+}
+
+// plansMatching returns the <namespace>/<name> of the Plans that use one of the Providers as their source or
+// destination and match the filter, and of the Plans with an in-flight Migration
+func (r *ManagedClusterReconciler) plansMatching(
+	ctx context.Context,
+	providers map[types.NamespacedName]bool,
+	filter func(*forkliftv1beta1.Plan) bool,
+) ([]string, error) {
+	referencing, err := r.plansReferencing(ctx, providers)
+	if err != nil || len(referencing) == 0 {
+		return nil, err
+	}
+	migrating, err := r.migratingPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []string
+	for _, plan := range referencing {
+		if filter(plan) || migrating[types.NamespacedName{Name: plan.Name, Namespace: plan.Namespace}] {
+			plans = append(plans, plan.Namespace+"/"+plan.Name)
+		}
+	}
+	sort.Strings(plans)
+	return plans, nil
+}
+
+// plansReferencing returns the Plans that use one of the Providers as their source or destination
+func (r *ManagedClusterReconciler) plansReferencing(
+	ctx context.Context,
+	providers map[types.NamespacedName]bool,
+) ([]*forkliftv1beta1.Plan, error) {
+	if err := r.startWhenEstablished(ctx, PlansGVR); err != nil {
+		return nil, err
+	}
+	list, err := r.listResources(ctx, PlansGVR, metav1.NamespaceAll)
+	if err != nil {
+		// Without the Plan CRD no Plan depends on the Provider
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var plans []*forkliftv1beta1.Plan
+	for _, item := range list.Items {
+		plan := &forkliftv1beta1.Plan{}
+		if err := decodeForklift(&item, plan); err != nil {
+			return nil, err
+		}
+		if planReferences(plan, providers) {
+			plans = append(plans, plan)
+		}
+	}
+	return plans, nil
+}
+
+// migratingPlans returns the Plans run by a Migration that did not complete yet
+func (r *ManagedClusterReconciler) migratingPlans(ctx context.Context) (map[types.NamespacedName]bool, error) {
+	if err := r.startWhenEstablished(ctx, MigrationsGVR); err != nil {
+		return nil, err
+	}
+	list, err := r.listResources(ctx, MigrationsGVR, metav1.NamespaceAll)
+	if err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	plans := map[types.NamespacedName]bool{}
+	for _, item := range list.Items {
+		migration := &forkliftv1beta1.Migration{}
+		if err := decodeForklift(&item, migration); err != nil {
+			return nil, err
+		}
+		if migrationInFlight(migration) {
+			plans[migrationPlan(migration)] = true
+		}
+	}
+	return plans, nil
+}
+
+// decodeForklift decodes a Plan or Migration. Their conditions have unexported fields the unstructured
+// converter cannot set, so they are decoded from JSON.
+func decodeForklift(item *unstructured.Unstructured, obj interface{}) error {
+	data, err := item.MarshalJSON()
+	if err == nil {
+		err = json.Unmarshal(data, obj)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the %s %s/%s: %w", item.GetKind(), item.GetNamespace(), item.GetName(), err)
+	}
+	return nil
+}
+
+// cleanupBlocked checks if the cleanup of the ManagedCluster must wait for in-flight Plans, which would break
+// halfway without their Provider. The ForceCleanupKey annotation overrides the check.
+func (r *ManagedClusterReconciler) cleanupBlocked(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (bool, error) {
+	log := log.FromContext(ctx)
+
+	plans, err := r.inFlightPlans(ctx, managedCluster)
+	if err != nil {
+		err = fmt.Errorf("failed to list the Plans targeting the Provider: %w", err)
+		r.setIntegrationPhase(ctx, managedCluster, PhaseCleaningUp, err)
+		recordStepError(PhaseCleaningUp, err)
+		r.recordPhaseFailure(managedCluster, PhaseCleaningUp, err)
+		return false, err
+	}
+	if len(plans) == 0 {
+		return false, nil
+	}
+
+	if managedCluster.GetAnnotations()[ForceCleanupKey] == "true" {
+		log.Info("AUDIT: Forcing the cleanup while Plans are in flight", "plans", plans)
+		r.recordEvent(managedCluster, corev1.EventTypeWarning, ReasonCleanupForced, actionOffboard,
+			"Forced the cleanup while the Plans %s are in flight", strings.Join(plans, ", "))
+		return false, nil
+	}
+
+	log.Info("Waiting for the in-flight Plans before cleaning up", "plans", plans)
+	blockedErr := fmt.Errorf("the cleanup waits for the in-flight Plans %s, set the %s annotation to \"true\" "+
+		"to force it", strings.Join(plans, ", "), ForceCleanupKey)
+	condition := meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	if condition == nil || condition.Message != phaseErrorMessage(PhaseCleanupBlocked, blockedErr) {
+		r.recordPhaseFailure(managedCluster, PhaseCleanupBlocked, blockedErr)
+	}
+	r.setIntegrationPhase(ctx, managedCluster, PhaseCleanupBlocked, blockedErr)
+	return true, nil
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	planapi "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1/plan"
+	libcnd "github.com/kubev2v/forklift/pkg/lib/condition"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func testPlan(name, destination string, conditions ...string) *forkliftv1beta1.Plan {
+	plan := &forkliftv1beta1.Plan{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "migrations"},
+	}
+	plan.Spec.Provider.Source = corev1.ObjectReference{Name: "vsphere", Namespace: "migrations"}
+	plan.Spec.Provider.Destination = corev1.ObjectReference{Name: destination, Namespace: MTVIntegrationsNamespace}
+	for _, condition := range conditions {
+		plan.Status.SetCondition(libcnd.Condition{Type: condition, Status: libcnd.True})
+	}
+	return plan
+}
+
+func unstructuredPlan(t *testing.T, plan *forkliftv1beta1.Plan) *unstructured.Unstructured {
+	t.Helper()
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(plan)
+	require.NoError(t, err)
+	obj := &unstructured.Unstructured{Object: object}
+	obj.SetAPIVersion("forklift.konveyor.io/v1beta1")
+	obj.SetKind("Plan")
+	return obj
+}
+
+func TestPlanInFlight(t *testing.T) {
+	started := testPlan("started", "test-cluster-mtv")
+	started.Status.Migration.Timed = planapi.Timed{Started: &metav1.Time{Time: time.Now()}}
+	archived := testPlan("archived", "test-cluster-mtv", forkliftv1beta1.ConditionExecuting)
+	archived.Spec.Archived = true
+	failed := testPlan("failed", "test-cluster-mtv", forkliftv1beta1.ConditionFailed)
+	failed.Status.Migration.Timed = planapi.Timed{Started: &metav1.Time{Time: time.Now()}}
+
+	assert.True(t, planInFlight(testPlan("executing", "test-cluster-mtv", forkliftv1beta1.ConditionExecuting)))
+	assert.True(t, planInFlight(started))
+	assert.True(t, planInFlight(testPlan("pending", "test-cluster-mtv")), "a Plan that did not start may start")
+	assert.False(t, planInFlight(archived))
+	assert.False(t, planInFlight(failed))
+	assert.False(t, planInFlight(testPlan("succeeded", "test-cluster-mtv", forkliftv1beta1.ConditionSucceeded)))
+}
+
+func TestPlanReferences(t *testing.T) {
+	providers := map[types.NamespacedName]bool{{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}: true}
+
+	assert.True(t, planReferences(testPlan("destination", "test-cluster-mtv"), providers))
+	assert.False(t, planReferences(testPlan("other", "other-cluster-mtv"), providers))
+
+	// A reference without a namespace is resolved in the namespace of the Plan
+	source := testPlan("source", "host")
+	source.Namespace = MTVIntegrationsNamespace
+	source.Spec.Provider.Source = corev1.ObjectReference{Name: "test-cluster-mtv"}
+	assert.True(t, planReferences(source, providers))
+}
+
+func TestReconcile_CleanupBlockedByInFlightPlans(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = forkliftv1beta1.SchemeBuilder.AddToScheme(scheme)
+
+	// The cluster lost its selection label
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster).Build()
+	// The cleanup lists the Plans targeting the Provider and the resources labeled for the cluster
+	listKinds := map[schema.GroupVersionResource]string{PlansGVR: "PlanList",
+		MigrationsGVR: "MigrationList"}
+	for gvr, kind := range integrationListKinds {
+		listKinds[gvr] = kind
+	}
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace),
+		unstructuredPlan(t, testPlan("running", "test-cluster-mtv", forkliftv1beta1.ConditionExecuting)),
+		unstructuredPlan(t, testPlan("done", "test-cluster-mtv", forkliftv1beta1.ConditionSucceeded)),
+		unstructuredPlan(t, testPlan("other", "other-cluster-mtv", forkliftv1beta1.ConditionExecuting)))
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Recorder:      recorder,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	result, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, CleanupBlockedCheckInterval, result.RequeueAfter)
+
+	condition := integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseCleanupBlocked), condition.Reason)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Contains(t, condition.Message, "migrations/running")
+	assert.NotContains(t, condition.Message, "migrations/done")
+	assert.Equal(t, []string{"Warning " + ReasonCleanupBlocked}, drainEvents(recorder))
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err, "the Provider must be kept while a Plan is in flight")
+
+	// Waiting again does not repeat the event
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Empty(t, drainEvents(recorder))
+
+	// The force annotation lets the cleanup proceed
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	managedCluster.Annotations = map[string]string{ForceCleanupKey: "true"}
+	require.NoError(t, k8sClient.Update(context.TODO(), managedCluster))
+
+	result, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Contains(t, drainEvents(recorder), "Warning "+ReasonCleanupForced)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.Error(t, err, "the forced cleanup must delete the Provider")
+
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.NotContains(t, managedCluster.Finalizers, ManagedClusterFinalizer)
+}
+
+func testMigration(name, plan string, conditions ...string) *unstructured.Unstructured {
+	migration := &forkliftv1beta1.Migration{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "migrations"},
+	}
+	migration.Spec.Plan = corev1.ObjectReference{Name: plan}
+	for _, condition := range conditions {
+		migration.Status.SetCondition(libcnd.Condition{Type: condition, Status: libcnd.True})
+	}
+	object, _ := runtime.DefaultUnstructuredConverter.ToUnstructured(migration)
+	obj := &unstructured.Unstructured{Object: object}
+	obj.SetAPIVersion("forklift.konveyor.io/v1beta1")
+	obj.SetKind("Migration")
+	return obj
+}
+
+func TestPlansInFlightFor(t *testing.T) {
+	providers := map[types.NamespacedName]bool{{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}: true}
+	// A Plan that already failed is run again by a pending Migration
+	rerun := testPlan("rerun", "test-cluster-mtv", forkliftv1beta1.ConditionFailed)
+	listKinds := map[schema.GroupVersionResource]string{PlansGVR: "PlanList", MigrationsGVR: "MigrationList"}
+	reconciler := &ManagedClusterReconciler{DynamicClient: fake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(), listKinds,
+		unstructuredPlan(t, testPlan("pending", "test-cluster-mtv")),
+		unstructuredPlan(t, rerun),
+		unstructuredPlan(t, testPlan("done", "test-cluster-mtv", forkliftv1beta1.ConditionSucceeded)),
+		unstructuredPlan(t, testPlan("other", "other-cluster-mtv")),
+		testMigration("rerun-2", "rerun"),
+		testMigration("done-1", "done", forkliftv1beta1.ConditionSucceeded),
+		testMigration("other-1", "other"))}
+
+	plans, err := reconciler.plansInFlightFor(context.TODO(), providers)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrations/pending", "migrations/rerun"}, plans)
+
+	assert.True(t, migrationInFlight(&forkliftv1beta1.Migration{}))
+	assert.Equal(t, types.NamespacedName{Name: "rerun", Namespace: "migrations"}, migrationPlan(
+		&forkliftv1beta1.Migration{ObjectMeta: metav1.ObjectMeta{Namespace: "migrations"},
+			Spec: forkliftv1beta1.MigrationSpec{Plan: corev1.ObjectReference{Name: "rerun"}}}))
+}
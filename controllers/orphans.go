@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Actions of the orphaned resources metric
+const (
+	orphanActionDeleted = "deleted"
+	orphanActionDryRun  = "dry-run"
+)
+
+// OrphanCollector deletes the resources the controller created for ManagedClusters that no longer exist or
+// are no longer selected, such as after a ManagedCluster was force-deleted or its finalizer was removed. It
+// sweeps once on startup and then periodically.
+type OrphanCollector struct {
+	Reconciler *ManagedClusterReconciler
+	// Interval is the time between two sweeps. Zero only sweeps on startup.
+	Interval time.Duration
+	// DryRun reports the orphaned resources without deleting them
+	DryRun bool
+}
+
+// Start runs the sweeps until the manager stops
+func (c *OrphanCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-collector")
+	ctx = log.IntoContext(ctx, logger)
+
+	for {
+		if _, err := c.Sweep(ctx); err != nil {
+			logger.Error(err, "Failed to collect the orphaned resources")
+		}
+		if c.Interval <= 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// NeedLeaderElection makes only the leader delete orphaned resources
+func (c *OrphanCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep finds the orphaned resources and deletes them, unless it is a dry run. It returns the orphaned
+// resources.
+func (c *OrphanCollector) Sweep(ctx context.Context) ([]integrationResource, error) {
+	logger := log.FromContext(ctx)
+
+	candidates, err := c.candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []integrationResource
+	orphanedClusters := map[string]bool{}
+	for cluster, resources := range candidates {
+		orphaned, err := c.clusterOrphaned(ctx, cluster)
+		if err != nil {
+			return orphans, err
+		}
+		if !orphaned {
+			continue
+		}
+		orphanedClusters[cluster] = true
+
+		for _, resource := range resources {
+			if c.DryRun {
+				logger.Info("Found an orphaned resource, not deleting it in dry run mode", resource.gvr.Resource,
+					resource.name, "namespace", resource.namespace, "cluster", cluster)
+				orphanedResourcesTotal.WithLabelValues(resource.gvr.Resource, orphanActionDryRun).Inc()
+				orphans = append(orphans, resource)
+				continue
+			}
+
+			logger.Info("Deleting an orphaned resource", resource.gvr.Resource, resource.name,
+				"namespace", resource.namespace, "cluster", cluster)
+			if err := deleteResource(ctx, c.Reconciler.DynamicClient, resource.gvr, resource.name,
+				resource.namespace); err != nil {
+				return orphans, err
+			}
+			orphanedResourcesTotal.WithLabelValues(resource.gvr.Resource, orphanActionDeleted).Inc()
+			orphans = append(orphans, resource)
+		}
+	}
+
+	logger.Info("Collected the orphaned resources", "resources", len(orphans), "clusters", len(orphanedClusters),
+		"dryRun", c.DryRun)
+	return orphans, nil
+}
+
+// candidates lists the resources created by the controller, by the name of the ManagedCluster they were
+// created for. The cluster is read from the ownership labels, and from the resource name when it was created
+// before them.
+func (c *OrphanCollector) candidates(ctx context.Context) (map[string][]integrationResource, error) {
+	integration := c.Reconciler.integration()
+	candidates := map[string][]integrationResource{}
+
+	// The Providers and provider secrets are in the integration namespace and named after the cluster
+	for _, gvr := range []schema.GroupVersionResource{ProvidersGVR, ProviderSecretGVR} {
+		list, err := c.Reconciler.DynamicClient.Resource(gvr).Namespace(integration.Namespace).List(ctx,
+			metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			cluster, ok := managedClusterOf(&item)
+			if !ok {
+				cluster, ok = integration.ClusterNameForProvider(item.GetName())
+			}
+			if ok && createdByController(&item) {
+				candidates[cluster] = append(candidates[cluster],
+					integrationResource{gvr: gvr, name: item.GetName(), namespace: item.GetNamespace()})
+			}
+		}
+	}
+
+	// The ClusterPermissions and ManagedServiceAccounts are in the cluster namespace
+	for _, gvr := range []schema.GroupVersionResource{ClusterPermissionsGVR, ManagedServiceAccountsGVR} {
+		list, err := c.Reconciler.DynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			cluster, owned := managedClusterOf(&item)
+			if !owned {
+				cluster = item.GetNamespace()
+			}
+			if (owned || item.GetName() == integration.ResourceName(cluster)) && createdByController(&item) {
+				candidates[cluster] = append(candidates[cluster],
+					integrationResource{gvr: gvr, name: item.GetName(), namespace: item.GetNamespace()})
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// createdByController checks if the resource was written by the controller, so resources with a matching
+// name created by users or other controllers are left alone. The legacy field manager is the generic name of
+// every kubebuilder manager binary and is not trusted: the resources created before the server-side apply
+// are written by FieldManager once their ownership labels are applied.
+func createdByController(obj *unstructured.Unstructured) bool {
+	for _, managedFields := range obj.GetManagedFields() {
+		if managedFields.Manager == FieldManager {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterOrphaned checks if the resources of the ManagedCluster are orphaned. A ManagedCluster with the
+// finalizer is cleaned up by the reconcile, and a selected ManagedCluster keeps its resources.
+func (c *OrphanCollector) clusterOrphaned(ctx context.Context, cluster string) (bool, error) {
+	managedCluster := &clusterv1.ManagedCluster{}
+	if err := c.Reconciler.Get(ctx, types.NamespacedName{Name: cluster}, managedCluster); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if controllerutil.ContainsFinalizer(managedCluster, ManagedClusterFinalizer) {
+		return false, nil
+	}
+	if managedCluster.GetDeletionTimestamp() != nil {
+		return true, nil
+	}
+
+	selected, err := c.Reconciler.clusterSelected(ctx, managedCluster)
+	if err != nil {
+		return false, err
+	}
+	return !selected, nil
+}
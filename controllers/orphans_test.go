@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// integrationListKinds are the list kinds of the resources created for the ManagedClusters, for the fake
+// dynamic clients that list them
+var integrationListKinds = map[schema.GroupVersionResource]string{
+	ProvidersGVR:              "ProviderList",
+	ProviderSecretGVR:         "SecretList",
+	ClusterPermissionsGVR:     "ClusterPermissionList",
+	ManagedServiceAccountsGVR: "ManagedServiceAccountList",
+}
+
+func managedResource(
+	gvr schema.GroupVersionResource,
+	kind, name, namespace, manager string,
+) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(schema.GroupVersion{Group: gvr.Group, Version: gvr.Version}.String())
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	if manager != "" {
+		obj.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: manager, Operation: metav1.ManagedFieldsOperationApply}})
+	}
+	return obj
+}
+
+func orphanTestSetup(t *testing.T, clusters ...*clusterv1.ManagedCluster) (*OrphanCollector, *fake.FakeDynamicClient) {
+	t.Helper()
+	objects := []runtime.Object{
+		// The cluster was deleted
+		managedResource(ProvidersGVR, "Provider", "gone-mtv", MTVIntegrationsNamespace, FieldManager),
+		managedResource(ProviderSecretGVR, "Secret", "gone-mtv", MTVIntegrationsNamespace, FieldManager),
+		managedResource(ClusterPermissionsGVR, "ClusterPermission", "gone-mtv", "gone", FieldManager),
+		managedResource(ManagedServiceAccountsGVR, "ManagedServiceAccount", "gone-mtv", "gone", FieldManager),
+		// Written by another controller with the generic kubebuilder field manager
+		managedResource(ClusterPermissionsGVR, "ClusterPermission", "other-mtv", "other", legacyFieldManager),
+		// The cluster is still integrated
+		managedResource(ProvidersGVR, "Provider", "kept-mtv", MTVIntegrationsNamespace, FieldManager),
+		// The cluster is no longer selected
+		managedResource(ProvidersGVR, "Provider", "unselected-mtv", MTVIntegrationsNamespace, FieldManager),
+		// Not created by the controller
+		managedResource(ProvidersGVR, "Provider", "manual-mtv", MTVIntegrationsNamespace, "kubectl"),
+		managedResource(ProvidersGVR, "Provider", "host", MTVIntegrationsNamespace, FieldManager),
+	}
+	clusterObjects := make([]client.Object, 0, len(clusters))
+	for _, cluster := range clusters {
+		clusterObjects = append(clusterObjects, cluster)
+	}
+	reconciler, dynClient, _ := newTestReconciler(t, withObjects(clusterObjects...), withDynamicObjects(objects...))
+	return &OrphanCollector{Reconciler: reconciler}, dynClient
+}
+
+func orphanClusters() []*clusterv1.ManagedCluster {
+	return []*clusterv1.ManagedCluster{
+		{ObjectMeta: metav1.ObjectMeta{
+			Name:       "kept",
+			Labels:     map[string]string{LabelCNVOperatorInstall: cnvOperatorInstallEnabled},
+			Finalizers: []string{ManagedClusterFinalizer},
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unselected"}},
+	}
+}
+
+func TestOrphanCollectorSweep(t *testing.T) {
+	collector, dynClient := orphanTestSetup(t, orphanClusters()...)
+
+	orphans, err := collector.Sweep(context.TODO())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []integrationResource{
+		{gvr: ProvidersGVR, name: "gone-mtv", namespace: MTVIntegrationsNamespace},
+		{gvr: ProviderSecretGVR, name: "gone-mtv", namespace: MTVIntegrationsNamespace},
+		{gvr: ClusterPermissionsGVR, name: "gone-mtv", namespace: "gone"},
+		{gvr: ManagedServiceAccountsGVR, name: "gone-mtv", namespace: "gone"},
+		{gvr: ProvidersGVR, name: "unselected-mtv", namespace: MTVIntegrationsNamespace},
+	}, orphans)
+
+	for _, orphan := range orphans {
+		_, err := dynClient.Resource(orphan.gvr).Namespace(orphan.namespace).Get(context.TODO(), orphan.name,
+			metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "expected NotFound for %s %s, got err=%v",
+			orphan.gvr.Resource, orphan.name, err)
+	}
+	for _, name := range []string{"kept-mtv", "manual-mtv", "host"} {
+		_, err := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(), name,
+			metav1.GetOptions{})
+		assert.NoError(t, err, "the Provider %s must be kept", name)
+	}
+	_, err = dynClient.Resource(ClusterPermissionsGVR).Namespace("other").Get(context.TODO(), "other-mtv",
+		metav1.GetOptions{})
+	assert.NoError(t, err, "the ClusterPermission of another controller must be kept")
+
+	// Nothing is left to collect
+	orphans, err = collector.Sweep(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+}
+
+func TestOrphanCollectorSweep_DryRun(t *testing.T) {
+	collector, dynClient := orphanTestSetup(t, orphanClusters()...)
+	collector.DryRun = true
+
+	orphans, err := collector.Sweep(context.TODO())
+	require.NoError(t, err)
+	assert.Len(t, orphans, 5)
+
+	for _, orphan := range orphans {
+		_, err := dynClient.Resource(orphan.gvr).Namespace(orphan.namespace).Get(context.TODO(), orphan.name,
+			metav1.GetOptions{})
+		assert.NoError(t, err, "the dry run must not delete %s %s", orphan.gvr.Resource, orphan.name)
+	}
+}
+
+func TestCreateManagedServiceAccount_WrittenByFieldManager(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+
+	// The orphan collection only considers the resources written by FieldManager
+	var fieldManager string
+	reconciler := &ManagedClusterReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				createOptions := &client.CreateOptions{}
+				createOptions.ApplyOptions(opts)
+				fieldManager = createOptions.FieldManager
+				return c.Create(ctx, obj, opts...)
+			},
+		}).Build(),
+		Scheme: scheme,
+	}
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", UID: "uid"}}
+
+	_, _, err := reconciler.createManagedServiceAccount(context.TODO(), managedCluster, "test-cluster-mtv",
+		"test-cluster", DefaultTokenValidity)
+	require.NoError(t, err)
+	assert.Equal(t, FieldManager, fieldManager)
+}
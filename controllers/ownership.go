@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ManagedByKey is the label and annotation of the ManagedServiceAccount, ClusterPermission, provider
+	// secret and Provider created for a ManagedCluster, the value is FieldManager
+	ManagedByKey = "app.kubernetes.io/managed-by"
+	// ManagedClusterNameKey is the label and annotation that holds the name of the ManagedCluster the
+	// resource was created for. The resources are mapped back to their cluster with it instead of their name,
+	// which is ambiguous when the cluster name itself follows the naming of the integration.
+	ManagedClusterNameKey = "mtv-integrations.open-cluster-management.io/managed-cluster-name"
+)
+
+// ownershipMetadata is the labels and annotations of the resources created for the ManagedCluster
+func ownershipMetadata(managedClusterName string) map[string]string {
+	return map[string]string{
+		ManagedByKey:          FieldManager,
+		ManagedClusterNameKey: managedClusterName,
+	}
+}
+
+// setOwnership adds the ownership labels and annotations to the metadata of a payload
+func setOwnership(payload map[string]interface{}, managedClusterName string) {
+	metadata := payload[payloadKeyMetadata].(map[string]interface{})
+	for _, field := range []string{"labels", "annotations"} {
+		values, _ := metadata[field].(map[string]interface{})
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		for key, value := range ownershipMetadata(managedClusterName) {
+			values[key] = value
+		}
+		metadata[field] = values
+	}
+}
+
+// managedClusterOf returns the ManagedCluster the resource was created for from its ownership label, and
+// false when the resource does not carry the labels. The resources created before the labels were added
+// carry them from the first reconcile of their cluster.
+func managedClusterOf(obj metav1.Object) (string, bool) {
+	if obj.GetLabels()[ManagedByKey] != FieldManager {
+		return "", false
+	}
+	cluster := obj.GetLabels()[ManagedClusterNameKey]
+	return cluster, cluster != ""
+}
+
+// ownershipDrift returns the ownership labels and annotations the resource is missing
+func ownershipDrift(obj metav1.Object, managedClusterName string) []string {
+	var drifted []string
+	for key, value := range ownershipMetadata(managedClusterName) {
+		if obj.GetLabels()[key] != value {
+			drifted = append(drifted, "metadata.labels."+key)
+		}
+		if obj.GetAnnotations()[key] != value {
+			drifted = append(drifted, "metadata.annotations."+key)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// setOwnershipMetadata adds the ownership labels and annotations to a typed object
+func setOwnershipMetadata(obj metav1.Object, managedClusterName string) {
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for key, value := range ownershipMetadata(managedClusterName) {
+		objLabels[key] = value
+		annotations[key] = value
+	}
+	obj.SetLabels(objLabels)
+	obj.SetAnnotations(annotations)
+}
+
+// reconcileManagedServiceAccountOwnership adds the ownership labels and annotations to a ManagedServiceAccount
+// created without them
+func (r *ManagedClusterReconciler) reconcileManagedServiceAccountOwnership(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+) error {
+	drifted := ownershipDrift(managedServiceAccount, managedCluster.Name)
+	if len(drifted) == 0 {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Repairing drift", "ManagedServiceAccount", managedServiceAccount.Name,
+		"namespace", managedServiceAccount.Namespace, "fields", drifted)
+	original := managedServiceAccount.DeepCopy()
+	setOwnershipMetadata(managedServiceAccount, managedCluster.Name)
+	return r.Patch(ctx, managedServiceAccount, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
+
+// deleteOwnedResources deletes the resources labeled for the ManagedCluster, wherever they are and whatever
+// their name, such as the ones created with an earlier integration namespace or naming
+func (r *ManagedClusterReconciler) deleteOwnedResources(ctx context.Context, managedClusterName string) error {
+	selector := labels.SelectorFromSet(ownershipMetadata(managedClusterName)).String()
+	for _, resource := range []struct {
+		gvr       schema.GroupVersionResource
+		namespace string
+	}{
+		{gvr: ClusterPermissionsGVR, namespace: managedClusterName},
+		{gvr: ManagedServiceAccountsGVR, namespace: managedClusterName},
+		{gvr: ProviderSecretGVR, namespace: metav1.NamespaceAll},
+		{gvr: ProvidersGVR, namespace: metav1.NamespaceAll},
+	} {
+		list, err := r.DynamicClient.Resource(resource.gvr).Namespace(resource.namespace).List(ctx,
+			metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		for _, item := range list.Items {
+			if err := deleteResource(ctx, r.DynamicClient, resource.gvr, item.GetName(),
+				item.GetNamespace()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// providerSecretOwnership is the ownership labels of a provider secret along the labels Forklift expects
+func providerSecretOwnership(managedClusterName string) map[string]string {
+	secretLabels := providerSecretLabels()
+	for key, value := range ownershipMetadata(managedClusterName) {
+		secretLabels[key] = value
+	}
+	return secretLabels
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ownedBy labels the resource for the ManagedCluster
+func ownedBy(obj *unstructured.Unstructured, managedClusterName string) *unstructured.Unstructured {
+	obj.SetLabels(ownershipMetadata(managedClusterName))
+	return obj
+}
+
+func TestPayloadsCarryOwnership(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "edge-mtv"}}
+	provider, err := payloadToUnstructured(providerPayload("edge-mtv", "edge-mtv-mtv", MTVIntegrationsNamespace,
+		"https://api.edge.example.com:6443"))
+	require.NoError(t, err)
+	clusterPermission, err := payloadToUnstructured(clusterPermissionPayload(managedCluster, "edge-mtv-mtv",
+		"agent-ns", &rbacProfile{clusterRole: "admin"}))
+	require.NoError(t, err)
+
+	for _, obj := range []*unstructured.Unstructured{provider, clusterPermission} {
+		assert.Equal(t, ownershipMetadata("edge-mtv"), obj.GetLabels(), obj.GetKind())
+		assert.Equal(t, ownershipMetadata("edge-mtv"), obj.GetAnnotations(), obj.GetKind())
+		cluster, ok := managedClusterOf(obj)
+		assert.True(t, ok)
+		assert.Equal(t, "edge-mtv", cluster)
+	}
+
+	// The adopted Provider keeps its adoption label along the ownership labels
+	adopted := handMadeProvider("team-cluster", "team-a", "openshift", "https://api.edge.example.com:6443")
+	payload, err := payloadToUnstructured((&ManagedClusterReconciler{}).adoptedProviderPayload(managedCluster,
+		adopted, "https://api.edge.example.com:6443"))
+	require.NoError(t, err)
+	assert.Equal(t, "edge-mtv", payload.GetLabels()[AdoptedForLabel])
+	assert.Equal(t, "edge-mtv", payload.GetLabels()[ManagedClusterNameKey])
+}
+
+func TestManagedClusterOf(t *testing.T) {
+	provider := testProvider("edge-mtv", MTVIntegrationsNamespace)
+	_, ok := managedClusterOf(provider)
+	assert.False(t, ok, "created before the ownership labels")
+
+	provider.SetLabels(map[string]string{ManagedClusterNameKey: "edge-mtv"})
+	_, ok = managedClusterOf(provider)
+	assert.False(t, ok, "not managed by the controller")
+
+	cluster, ok := managedClusterOf(ownedBy(provider, "edge-mtv"))
+	assert.True(t, ok)
+	assert.Equal(t, "edge-mtv", cluster)
+}
+
+func TestWatches_MapOwnedResourcesByLabel(t *testing.T) {
+	// By its name, a Provider created for the edge-mtv cluster with an earlier naming is the one of the edge cluster
+	reconciler := &ManagedClusterReconciler{}
+	edge := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "edge"}}}
+	edgeMTV := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "edge-mtv"}}}
+
+	provider := testProvider("edge-mtv", MTVIntegrationsNamespace)
+	assert.Equal(t, edge, reconciler.clusterForProvider(context.TODO(), provider), "mapped by name")
+	assert.Equal(t, edgeMTV, reconciler.clusterForProvider(context.TODO(), ownedBy(provider, "edge-mtv")))
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "edge-mtv", Namespace: MTVIntegrationsNamespace,
+		Labels: providerSecretOwnership("edge-mtv")}}
+	assert.Equal(t, edgeMTV, reconciler.clusterForProviderSecret(context.TODO(), secret))
+
+	// A ClusterPermission created with an earlier naming is still mapped to its cluster
+	clusterPermission := clusterPermissionObject()
+	clusterPermission.SetName("acm-edge-mtv")
+	clusterPermission.SetNamespace("edge-mtv")
+	assert.Empty(t, reconciler.clusterForClusterPermission(context.TODO(), clusterPermission))
+	assert.Equal(t, edgeMTV, reconciler.clusterForClusterPermission(context.TODO(),
+		ownedBy(clusterPermission, "edge-mtv")))
+}
+
+func TestDeleteOwnedResources(t *testing.T) {
+	// Created with an earlier integration namespace and naming
+	provider := ownedBy(testProvider("acm-c1", "old-migrations"), "c1")
+	secret := ownedBy(managedResource(ProviderSecretGVR, "Secret", "acm-c1", "old-migrations", FieldManager), "c1")
+	clusterPermission := ownedBy(managedResource(ClusterPermissionsGVR, "ClusterPermission", "acm-c1", "c1",
+		FieldManager), "c1")
+	other := ownedBy(testProvider("acm-c2", "old-migrations"), "c2")
+	unlabeled := testProvider("c1-copy", "old-migrations")
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), integrationListKinds,
+		provider, secret, clusterPermission, other, unlabeled)
+	reconciler := &ManagedClusterReconciler{DynamicClient: dynClient}
+
+	require.NoError(t, reconciler.deleteOwnedResources(context.TODO(), "c1"))
+
+	for _, obj := range []struct {
+		resource *unstructured.Unstructured
+		deleted  bool
+	}{{provider, true}, {secret, true}, {clusterPermission, true}, {other, false}, {unlabeled, false}} {
+		gvr := ProvidersGVR
+		switch obj.resource.GetKind() {
+		case "Secret":
+			gvr = ProviderSecretGVR
+		case "ClusterPermission":
+			gvr = ClusterPermissionsGVR
+		}
+		_, err := dynClient.Resource(gvr).Namespace(obj.resource.GetNamespace()).Get(context.TODO(),
+			obj.resource.GetName(), metav1.GetOptions{})
+		assert.Equal(t, obj.deleted, apierrors.IsNotFound(err), obj.resource.GetName())
+	}
+}
+
+func TestSyncProviderSecret_AddsOwnership(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	sourceSecret := &corev1.Secret{Data: map[string][]byte{"token": []byte("token"), "ca.crt": []byte("ca")}}
+	// A provider secret in sync, created before the ownership labels
+	providerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster-mtv",
+			Namespace: MTVIntegrationsNamespace,
+			Labels:    providerSecretLabels(),
+		},
+		Data: map[string][]byte{
+			"insecureSkipVerify": []byte("false"),
+			"url":                []byte("https://api.example.com:6443"),
+			"cacert":             []byte("ca"),
+			"token":              []byte("token"),
+		},
+	}
+	connection := providerConnection{url: "https://api.example.com:6443"}
+	assert.Equal(t, []string{
+		"metadata.annotations." + ManagedByKey,
+		"metadata.annotations." + ManagedClusterNameKey,
+		"metadata.labels." + ManagedByKey,
+		"metadata.labels." + ManagedClusterNameKey,
+	}, providerSecretDrift(providerSecret, managedCluster.Name, connection))
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(providerSecret).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+	require.NoError(t, reconciler.syncProviderSecret(context.TODO(), managedCluster, sourceSecret,
+		"test-cluster-mtv", connection))
+
+	updated := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, updated))
+	assert.Equal(t, providerSecretOwnership(managedCluster.Name), updated.Labels)
+	assert.Equal(t, ownershipMetadata(managedCluster.Name), updated.Annotations)
+	assert.Empty(t, providerSecretDrift(updated, managedCluster.Name, connection))
+}
+
+func TestReconcileManagedServiceAccountOwnership(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = auth.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	managedServiceAccount := &auth.ManagedServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test-cluster-mtv",
+		Namespace:   "test-cluster",
+		Annotations: map[string]string{rotationRequestKey: "incident-1"},
+	}}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(managedServiceAccount).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+
+	require.NoError(t, reconciler.reconcileManagedServiceAccountOwnership(context.TODO(), managedCluster,
+		managedServiceAccount))
+
+	updated := &auth.ManagedServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}, updated))
+	assert.Equal(t, ownershipMetadata("test-cluster"), updated.Labels)
+	assert.Equal(t, "test-cluster", updated.Annotations[ManagedClusterNameKey])
+	assert.Equal(t, "incident-1", updated.Annotations[rotationRequestKey], "the other annotations are kept")
+	assert.Empty(t, ownershipDrift(updated, "test-cluster"))
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// PausedKey is the ManagedCluster annotation that stops the controller from changing anything for the
+	// cluster while it is set to true, for example while the cluster is debugged. The status is still
+	// reported.
+	PausedKey = "mtv-integrations.open-cluster-management.io/paused"
+	// ResyncKey is the ManagedCluster annotation that requests a full re-apply of the ManagedServiceAccount,
+	// ClusterPermission, provider secret and Provider of the cluster, even when they did not drift. It is
+	// removed once they are re-applied.
+	ResyncKey = "mtv-integrations.open-cluster-management.io/resync"
+)
+
+// resyncKey is the context key of a reconcile that re-applies every resource
+type resyncKey struct{}
+
+// integrationPaused checks if the ManagedCluster carries the paused annotation
+func integrationPaused(managedCluster *clusterv1.ManagedCluster) bool {
+	return managedCluster.GetAnnotations()[PausedKey] == "true"
+}
+
+// wasIntegrationPaused checks if the last reconcile reported the integration of the ManagedCluster as paused
+func wasIntegrationPaused(managedCluster *clusterv1.ManagedCluster) bool {
+	condition := meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	return condition != nil && condition.Reason == string(PhasePaused)
+}
+
+// reportPaused reports the status of a paused ManagedCluster without changing any resource: the phase is
+// set to Paused and the conditions of the existing Provider are mirrored
+func (r *ManagedClusterReconciler) reportPaused(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (ctrl.Result, error) {
+	log.FromContext(ctx).Info("The integration of the ManagedCluster is paused, no change is made",
+		"annotation", PausedKey)
+	if !wasIntegrationPaused(managedCluster) {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonIntegrationPaused, actionOnboard,
+			"The %s annotation pauses the integration, the MTV resources are left as they are", PausedKey)
+	}
+	r.setIntegrationPhase(ctx, managedCluster, PhasePaused, nil)
+
+	provider, err := r.integrationProvider(ctx, managedCluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if provider != nil {
+		r.setProviderConditions(ctx, managedCluster, provider)
+	}
+	return ctrl.Result{}, nil
+}
+
+// reportResumed emits an Event when the paused annotation was removed since the last reconcile
+func (r *ManagedClusterReconciler) reportResumed(managedCluster *clusterv1.ManagedCluster) {
+	if wasIntegrationPaused(managedCluster) {
+		r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonIntegrationResumed, actionOnboard,
+			"The integration is no longer paused")
+	}
+}
+
+// resyncContext marks the reconcile of a ManagedCluster with the resync annotation, so every resource is
+// re-applied
+func resyncContext(ctx context.Context, managedCluster *clusterv1.ManagedCluster) context.Context {
+	if _, ok := managedCluster.GetAnnotations()[ResyncKey]; !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, resyncKey{}, managedCluster.GetAnnotations()[ResyncKey])
+}
+
+// resyncing checks if the reconcile re-applies every resource
+func resyncing(ctx context.Context) bool {
+	_, ok := ctx.Value(resyncKey{}).(string)
+	return ok
+}
+
+// resyncManagedServiceAccount re-applies the rotation and the owner of the ManagedServiceAccount during a
+// resync
+func (r *ManagedClusterReconciler) resyncManagedServiceAccount(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+	validity time.Duration,
+) error {
+	if !resyncing(ctx) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Resynchronizing the ManagedServiceAccount", "ManagedServiceAccount",
+		managedServiceAccount.Name)
+	original := managedServiceAccount.DeepCopy()
+	managedServiceAccount.Spec.Rotation = auth.ManagedServiceAccountRotation{
+		Enabled:  true,
+		Validity: metav1.Duration{Duration: validity},
+	}
+	if err := controllerutil.SetControllerReference(
+		managedCluster, managedServiceAccount, r.Scheme,
+		controllerutil.WithBlockOwnerDeletion(false)); err != nil {
+		return err
+	}
+	return r.Patch(ctx, managedServiceAccount, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
+
+// completeResync removes the resync annotation once every resource of the ManagedCluster was re-applied. A
+// new value set since the reconcile started requests another resync and is kept.
+func (r *ManagedClusterReconciler) completeResync(ctx context.Context, managedCluster *clusterv1.ManagedCluster) error {
+	request, ok := ctx.Value(resyncKey{}).(string)
+	if !ok {
+		return nil
+	}
+
+	original := managedCluster.DeepCopy()
+	delete(managedCluster.Annotations, ResyncKey)
+	if err := r.Patch(ctx, managedCluster, client.MergeFromWithOptions(original,
+		client.MergeFromWithOptimisticLock{})); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	log.FromContext(ctx).Info("AUDIT: Resync completed", "request", request)
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonResyncCompleted, actionOnboard,
+		"Re-applied the MTV resources of the cluster for the %s annotation", ResyncKey)
+	return nil
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func pauseSetup(
+	t *testing.T,
+	managedCluster *clusterv1.ManagedCluster,
+	objs ...runtime.Object,
+) (*ManagedClusterReconciler, *fake.FakeDynamicClient, *events.FakeRecorder) {
+	t.Helper()
+	reconciler, dynClient, recorder := newTestReconciler(t, withObjects(managedCluster),
+		withStatusSubresource(&clusterv1.ManagedCluster{}), withDynamicObjects(objs...))
+	reconciler.AgentNamespace = "open-cluster-management-agent-addon"
+	return reconciler, dynClient, recorder
+}
+
+func TestReconcile_PausedClusterIsNotChanged(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-cluster",
+			Labels:      map[string]string{LabelCNVOperatorInstall: "true"},
+			Annotations: map[string]string{PausedKey: "true"},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+	reconciler, dynClient, recorder := pauseSetup(t, managedCluster,
+		providerWithConditions(map[string]interface{}{"type": "Ready", "status": "True", "reason": "Completed"}))
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	for range 2 {
+		result, err := reconciler.Reconcile(context.TODO(), req)
+		require.NoError(t, err)
+		assert.Zero(t, result)
+	}
+
+	updated := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), req.NamespacedName, updated))
+	assert.NotContains(t, updated.Finalizers, ManagedClusterFinalizer)
+	err := reconciler.Get(context.TODO(), types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		&auth.ManagedServiceAccount{})
+	assert.True(t, apierrors.IsNotFound(err))
+	for _, action := range dynClient.Actions() {
+		assert.Equal(t, "get", action.GetVerb(), "only the Provider is read")
+	}
+
+	// The status is still reported
+	condition := integrationConditionOf(t, reconciler.Client, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhasePaused), condition.Reason)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+	ready := meta.FindStatusCondition(updated.Status.Conditions, ConditionTypeProviderPrefix+"Ready")
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, []string{"Normal " + ReasonIntegrationPaused}, drainEvents(recorder),
+		"the pause is only reported once")
+
+	// Removing the annotation resumes the onboarding
+	original := updated.DeepCopy()
+	delete(updated.Annotations, PausedKey)
+	require.NoError(t, reconciler.Patch(context.TODO(), updated, client.MergeFrom(original)))
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, reconciler.Get(context.TODO(), req.NamespacedName, updated))
+	assert.Contains(t, updated.Finalizers, ManagedClusterFinalizer)
+	assert.Contains(t, drainEvents(recorder), "Normal "+ReasonIntegrationResumed)
+}
+
+func TestReconcile_PausedClusterIsNotCleanedUp(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-cluster",
+			Annotations:       map[string]string{PausedKey: "true"},
+			Finalizers:        []string{ManagedClusterFinalizer},
+			DeletionTimestamp: &now,
+		},
+	}
+	reconciler, dynClient, _ := pauseSetup(t, managedCluster,
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace))
+
+	_, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+
+	updated := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+	assert.Contains(t, updated.Finalizers, ManagedClusterFinalizer, "the deletion waits for the annotation removal")
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestReconcileResource_ResyncReappliesWithoutDrift(t *testing.T) {
+	payload := providerPayload("test-cluster", "test-cluster-mtv", MTVIntegrationsNamespace,
+		"https://api.example.com:6443")
+	provider, err := payloadToUnstructured(payload)
+	require.NoError(t, err)
+	dynClient := fake.NewSimpleDynamicClient(runtime.NewScheme(), provider)
+	addApplyReactor(dynClient)
+	reconciler := &ManagedClusterReconciler{DynamicClient: dynClient}
+
+	_, operation, err := reconciler.reconcileResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace, payload)
+	require.NoError(t, err)
+	assert.Equal(t, operationNone, operation)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+		Name: "test-cluster", Annotations: map[string]string{ResyncKey: "INC-42"},
+	}}
+	ctx := resyncContext(context.TODO(), managedCluster)
+	_, operation, err = reconciler.reconcileResource(ctx, ProvidersGVR, MTVIntegrationsNamespace, payload)
+	require.NoError(t, err)
+	assert.Equal(t, operationRepaired, operation)
+}
+
+func TestResyncManagedServiceAccount(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+		Name: "test-cluster", UID: "uid", Annotations: map[string]string{ResyncKey: "now"},
+	}}
+	managedServiceAccount := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		// Someone disabled the rotation and removed the owner
+		Spec: auth.ManagedServiceAccountSpec{Rotation: auth.ManagedServiceAccountRotation{Enabled: false}},
+	}
+	reconciler, _, _ := pauseSetup(t, managedCluster)
+	require.NoError(t, reconciler.Create(context.TODO(), managedServiceAccount))
+
+	// Nothing is re-applied without the annotation
+	require.NoError(t, reconciler.resyncManagedServiceAccount(context.TODO(), managedCluster,
+		managedServiceAccount, time.Hour))
+	assert.False(t, managedServiceAccount.Spec.Rotation.Enabled)
+
+	ctx := resyncContext(context.TODO(), managedCluster)
+	require.NoError(t, reconciler.resyncManagedServiceAccount(ctx, managedCluster, managedServiceAccount, time.Hour))
+	updated := &auth.ManagedServiceAccount{}
+	require.NoError(t, reconciler.Get(context.TODO(), client.ObjectKeyFromObject(managedServiceAccount), updated))
+	assert.True(t, updated.Spec.Rotation.Enabled)
+	assert.Equal(t, time.Hour, updated.Spec.Rotation.Validity.Duration)
+	require.Len(t, updated.OwnerReferences, 1)
+	assert.Equal(t, "test-cluster", updated.OwnerReferences[0].Name)
+}
+
+func TestCompleteResync(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+		Name: "test-cluster", Annotations: map[string]string{ResyncKey: "INC-42", "other": "kept"},
+	}}
+	reconciler, _, recorder := pauseSetup(t, managedCluster)
+	updated := &clusterv1.ManagedCluster{}
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+
+	require.NoError(t, reconciler.completeResync(context.TODO(), updated))
+	assert.Contains(t, updated.Annotations, ResyncKey, "a reconcile without a resync leaves the annotation")
+
+	require.NoError(t, reconciler.completeResync(resyncContext(context.TODO(), updated), updated))
+	require.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+	assert.NotContains(t, updated.Annotations, ResyncKey)
+	assert.Equal(t, "kept", updated.Annotations["other"])
+	assert.Equal(t, []string{"Normal " + ReasonResyncCompleted}, drainEvents(recorder))
+}
@@ -8,7 +8,8 @@ import (
 )
 
 const (
-	ManagedClusterFinalizer  = "mtv-integrations.open-cluster-management.io/resource-cleanup"
+	ManagedClusterFinalizer = "mtv-integrations.open-cluster-management.io/resource-cleanup"
+	// LabelCNVOperatorInstall and MTVIntegrationsNamespace are the defaults of the IntegrationConfig
 	LabelCNVOperatorInstall  = "acm/cnv-operator-install"
 	MTVIntegrationsNamespace = "mtv-integrations"
 	payloadKeyAPIVersion     = "apiVersion"
@@ -21,6 +22,12 @@ const (
 
 var TokenWaitDuration = 4 * time.Second
 
+// ProviderReadyCheckInterval is how often a Provider that is not ready yet is checked again
+var ProviderReadyCheckInterval = 30 * time.Second
+
+// CleanupBlockedCheckInterval is how often the in-flight Plans blocking a cleanup are checked again
+var CleanupBlockedCheckInterval = 30 * time.Second
+
 var (
 	ClusterPermissionsGVR     = generateGVR("rbac.open-cluster-management.io", "v1alpha1", "clusterpermissions")
 	ManagedServiceAccountsGVR = generateGVR(
@@ -31,59 +38,72 @@ var (
 var (
 	ProvidersGVR      = generateGVR("forklift.konveyor.io", "v1beta1", "providers")
 	ProviderSecretGVR = generateGVR("", "v1", "secrets")
+	PlansGVR          = generateGVR("forklift.konveyor.io", "v1beta1", "plans")
+	MigrationsGVR     = generateGVR("forklift.konveyor.io", "v1beta1", "migrations")
 )
 
-func providerPayload(managedCluster *clusterv1.ManagedCluster) map[string]interface{} {
-	managedClusterMTV := managedCluster.Name + "-mtv"
-
-	// Safely get the URL from ManagedClusterClientConfigs
-	var clusterURL string
-	if len(managedCluster.Spec.ManagedClusterClientConfigs) > 0 {
-		clusterURL = managedCluster.Spec.ManagedClusterClientConfigs[0].URL
-	}
-
-	return map[string]interface{}{
+func providerPayload(managedClusterName, managedClusterMTV, namespace, clusterURL string) map[string]interface{} {
+	payload := map[string]interface{}{
 		payloadKeyAPIVersion: "forklift.konveyor.io/v1beta1",
 		payloadKeyKind:       "Provider",
 		payloadKeyMetadata: map[string]interface{}{
 			payloadKeyName:      managedClusterMTV,
-			payloadKeyNamespace: MTVIntegrationsNamespace,
+			payloadKeyNamespace: namespace,
 		},
 		"spec": map[string]interface{}{
 			"type":        "openshift",
 			payloadKeyURL: clusterURL,
 			"secret": map[string]interface{}{
 				payloadKeyName:      managedClusterMTV,
-				payloadKeyNamespace: MTVIntegrationsNamespace,
+				payloadKeyNamespace: namespace,
 			},
 		},
 	}
+	setOwnership(payload, managedClusterName)
+	return payload
 }
 
-func clusterPermissionPayload(managedCluster *clusterv1.ManagedCluster, msaaNamespace string) map[string]interface{} {
-	managedClusterMTV := managedCluster.Name + "-mtv"
-	return map[string]interface{}{
+func clusterPermissionPayload(
+	managedCluster *clusterv1.ManagedCluster,
+	managedClusterMTV string,
+	msaaNamespace string,
+	profile *rbacProfile,
+) map[string]interface{} {
+	clusterRoleBinding := map[string]interface{}{
+		"subject": map[string]interface{}{
+			payloadKeyKind:      "ServiceAccount",
+			payloadKeyName:      managedClusterMTV,
+			payloadKeyNamespace: msaaNamespace, // The ServiceAccount is created here on the ManagedCluster
+		},
+	}
+	spec := map[string]interface{}{
+		"clusterRoleBinding": clusterRoleBinding,
+	}
+
+	if profile.clusterRole != "" {
+		clusterRoleBinding["roleRef"] = map[string]interface{}{
+			payloadKeyKind: "ClusterRole",
+			payloadKeyName: profile.clusterRole,
+			"apiGroup":     "rbac.authorization.k8s.io",
+		}
+	} else {
+		// Without a roleRef the binding points at the ClusterRole generated from spec.clusterRole
+		spec["clusterRole"] = map[string]interface{}{
+			"rules": policyRulesPayload(profile.rules),
+		}
+	}
+
+	payload := map[string]interface{}{
 		payloadKeyAPIVersion: "rbac.open-cluster-management.io/v1alpha1",
 		payloadKeyKind:       "ClusterPermission",
 		payloadKeyMetadata: map[string]interface{}{
 			payloadKeyName:      managedClusterMTV,
 			payloadKeyNamespace: managedCluster.Name,
 		},
-		"spec": map[string]interface{}{
-			"clusterRoleBinding": map[string]interface{}{
-				"subject": map[string]interface{}{
-					payloadKeyKind:      "ServiceAccount",
-					payloadKeyName:      managedClusterMTV,
-					payloadKeyNamespace: msaaNamespace, // The ServiceAccount is created here on the ManagedCluster
-				},
-				"roleRef": map[string]interface{}{ // This is the documented RBAC for the MTV Provider
-					payloadKeyKind: "ClusterRole",
-					payloadKeyName: "cluster-admin",
-					"apiGroup":     "rbac.authorization.k8s.io",
-				},
-			},
-		},
+		"spec": spec,
 	}
+	setOwnership(payload, managedCluster.Name)
+	return payload
 }
 
 func generateGVR(group string, version string, resource string) schema.GroupVersionResource {
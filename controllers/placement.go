@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// parsePlacement parses the <namespace>/<name> reference of a Placement
+func parsePlacement(placement string) (types.NamespacedName, error) {
+	namespace, name, found := strings.Cut(placement, "/")
+	if !found || len(validation.IsDNS1123Label(namespace)) > 0 || len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return types.NamespacedName{}, fmt.Errorf("invalid placement %q: must be <namespace>/<name>", placement)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// placementClusters returns the names of the clusters in the PlacementDecisions of the Placement. A missing
+// Placement, a misconfigured one and PlacementDecisions that are not populated yet, or not all written, are an
+// error rather than an empty selection, which would offboard every cluster. The selection is only empty once the
+// Placement is scheduled and selected no cluster.
+func (r *ManagedClusterReconciler) placementClusters(
+	ctx context.Context,
+	placement types.NamespacedName,
+) (map[string]bool, error) {
+	var placementObj clusterv1beta1.Placement
+	if err := r.Get(ctx, placement, &placementObj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("the Placement %s does not exist", placement)
+		}
+		return nil, fmt.Errorf("failed to get the Placement %s: %w", placement, err)
+	}
+
+	var decisions clusterv1beta1.PlacementDecisionList
+	if err := r.List(ctx, &decisions, client.InNamespace(placement.Namespace),
+		client.MatchingLabels{clusterv1beta1.PlacementLabel: placement.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list the PlacementDecisions of the Placement %s: %w", placement, err)
+	}
+
+	clusters := map[string]bool{}
+	for _, decision := range decisions.Items {
+		for _, clusterDecision := range decision.Status.Decisions {
+			clusters[clusterDecision.ClusterName] = true
+		}
+	}
+	if meta.IsStatusConditionTrue(placementObj.Status.Conditions, clusterv1beta1.PlacementConditionMisconfigured) {
+		return nil, fmt.Errorf("the Placement %s is misconfigured", placement)
+	}
+	if len(clusters) == 0 {
+		if placementScheduled(&placementObj) && placementObj.Status.NumberOfSelectedClusters == 0 {
+			// The Placement selected no cluster, so all the onboarded clusters are offboarded
+			return clusters, nil
+		}
+		return nil, fmt.Errorf("the Placement %s has no decisions yet", placement)
+	}
+	if len(clusters) != int(placementObj.Status.NumberOfSelectedClusters) {
+		return nil, fmt.Errorf("the PlacementDecisions of the Placement %s are being updated, %d of its %d "+
+			"clusters are decided", placement, len(clusters), placementObj.Status.NumberOfSelectedClusters)
+	}
+	return clusters, nil
+}
+
+// placementScheduled checks if the placement controller evaluated the current generation of the Placement,
+// which it reports with the PlacementSatisfied condition whether clusters matched or not
+func placementScheduled(placement *clusterv1beta1.Placement) bool {
+	satisfied := meta.FindStatusCondition(placement.Status.Conditions, clusterv1beta1.PlacementConditionSatisfied)
+	return satisfied != nil && satisfied.ObservedGeneration >= placement.Generation
+}
+
+// clusterSelected checks if the ManagedCluster is selected for the integration, by the Placement when one
+// is configured and by the selection label otherwise. With a Placement, the cluster is only unselected when
+// the populated decisions exclude it.
+func (r *ManagedClusterReconciler) clusterSelected(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) (bool, error) {
+	integration := r.integration()
+	if integration.Placement == "" {
+		return integration.Selected(managedCluster), nil
+	}
+
+	placement, err := parsePlacement(integration.Placement)
+	if err != nil {
+		return false, err
+	}
+	clusters, err := r.placementClusters(ctx, placement)
+	if err != nil {
+		return false, err
+	}
+	return clusters[managedCluster.Name], nil
+}
+
+// clustersForPlacementDecision enqueues the clusters of a PlacementDecision of the configured Placement, and
+// the onboarded clusters so the ones that left the decisions are offboarded
+func (r *ManagedClusterReconciler) clustersForPlacementDecision(
+	ctx context.Context,
+	obj client.Object,
+) []reconcile.Request {
+	placement, err := parsePlacement(r.integration().Placement)
+	if err != nil || obj.GetNamespace() != placement.Namespace ||
+		obj.GetLabels()[clusterv1beta1.PlacementLabel] != placement.Name {
+		return nil
+	}
+
+	clusters := map[string]bool{}
+	if decision, ok := obj.(*clusterv1beta1.PlacementDecision); ok {
+		for _, clusterDecision := range decision.Status.Decisions {
+			clusters[clusterDecision.ClusterName] = true
+		}
+	}
+
+	var mcList clusterv1.ManagedClusterList
+	if err := r.List(ctx, &mcList); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ManagedClusters on PlacementDecision event")
+		return nil
+	}
+	for _, mc := range mcList.Items {
+		if controllerutil.ContainsFinalizer(&mc, ManagedClusterFinalizer) {
+			clusters[mc.Name] = true
+		}
+	}
+
+	reqs := make([]reconcile.Request, 0, len(clusters))
+	for name := range clusters {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+	}
+	return reqs
+}
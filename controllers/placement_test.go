@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func placementDecision(name, placement string, clusters ...string) *clusterv1beta1.PlacementDecision {
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "mtv",
+			Labels:    map[string]string{clusterv1beta1.PlacementLabel: placement},
+		},
+	}
+	for _, cluster := range clusters {
+		decision.Status.Decisions = append(decision.Status.Decisions,
+			clusterv1beta1.ClusterDecision{ClusterName: cluster})
+	}
+	return decision
+}
+
+func placementOf(name string, selected int32) *clusterv1beta1.Placement {
+	return &clusterv1beta1.Placement{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mtv"},
+		Status:     clusterv1beta1.PlacementStatus{NumberOfSelectedClusters: selected},
+	}
+}
+
+// scheduledPlacementOf returns a Placement that the placement controller evaluated, with its PlacementSatisfied
+// condition
+func scheduledPlacementOf(name string, selected int32, conditions ...metav1.Condition) *clusterv1beta1.Placement {
+	placement := placementOf(name, selected)
+	satisfied := metav1.ConditionTrue
+	reason := "AllDecisionsScheduled"
+	if selected == 0 {
+		satisfied, reason = metav1.ConditionFalse, "NoManagedClusterMatched"
+	}
+	placement.Status.Conditions = append([]metav1.Condition{{
+		Type: clusterv1beta1.PlacementConditionSatisfied, Status: satisfied, Reason: reason,
+	}}, conditions...)
+	return placement
+}
+
+func TestParsePlacement(t *testing.T) {
+	placement, err := parsePlacement("mtv/migrations")
+	require.NoError(t, err)
+	assert.Equal(t, types.NamespacedName{Namespace: "mtv", Name: "migrations"}, placement)
+
+	for _, invalid := range []string{"migrations", "mtv/", "/migrations", "Mtv/migrations"} {
+		_, err := parsePlacement(invalid)
+		assert.Error(t, err, invalid)
+	}
+	assert.Error(t, IntegrationConfig{Placement: "migrations"}.WithDefaults().Validate())
+}
+
+func TestReconcile_PlacementSelection(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+	_ = forkliftv1beta1.SchemeBuilder.AddToScheme(scheme)
+
+	// The cluster is selected by the Placement, without the selection label
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+	decision := placementDecision("migrations-decision-1", "migrations", "other-cluster", "test-cluster")
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster, deployment, placementOf("migrations", 2), decision,
+			placementDecision("other-decision-1", "other", "test-cluster")).Build()
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClientWithCustomListKinds(scheme, integrationListKinds),
+		Integration:   IntegrationConfig{Placement: "mtv/migrations"},
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.Contains(t, managedCluster.Finalizers, ManagedClusterFinalizer)
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}, &auth.ManagedServiceAccount{}))
+
+	// The cluster leaves the decisions and is offboarded
+	decision.Status.Decisions = decision.Status.Decisions[:1]
+	require.NoError(t, k8sClient.Update(context.TODO(), decision))
+	placement := &clusterv1beta1.Placement{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "migrations", Namespace: "mtv"},
+		placement))
+	placement.Status.NumberOfSelectedClusters = 1
+	require.NoError(t, k8sClient.Update(context.TODO(), placement))
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.NotContains(t, managedCluster.Finalizers, ManagedClusterFinalizer)
+}
+
+func TestClusterSelected_PlacementNotDecided(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	for name, objects := range map[string][]client.Object{
+		"missing Placement": {placementDecision("migrations-decision-1", "migrations", "test-cluster")},
+		"no decisions yet":  {placementOf("migrations", 0)},
+		"misconfigured": {scheduledPlacementOf("migrations", 0, metav1.Condition{
+			Type: clusterv1beta1.PlacementConditionMisconfigured, Status: metav1.ConditionTrue, Reason: "Misconfigured",
+		})},
+		"empty decisions": {placementOf("migrations", 1), placementDecision("migrations-decision-1", "migrations")},
+		"decisions not written": {placementOf("migrations", 3), placementDecision("migrations-decision-1", "migrations",
+			"other-cluster")},
+	} {
+		reconciler := &ManagedClusterReconciler{
+			Client:      clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+			Integration: IntegrationConfig{Placement: "mtv/migrations"},
+		}
+		_, err := reconciler.clusterSelected(context.TODO(), managedCluster)
+		assert.Error(t, err, name)
+	}
+
+	// A populated decision set that excludes the cluster unselects it
+	reconciler := &ManagedClusterReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(placementOf("migrations", 1),
+			placementDecision("migrations-decision-1", "migrations", "other-cluster")).Build(),
+		Integration: IntegrationConfig{Placement: "mtv/migrations"},
+	}
+	selected, err := reconciler.clusterSelected(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.False(t, selected)
+
+	// A scheduled Placement that selected no cluster unselects every cluster
+	reconciler.Client = clientfake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(scheduledPlacementOf("migrations", 0)).Build()
+	clusters, err := reconciler.placementClusters(context.TODO(), types.NamespacedName{
+		Namespace: "mtv", Name: "migrations",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, clusters)
+	selected, err = reconciler.clusterSelected(context.TODO(), managedCluster)
+	require.NoError(t, err)
+	assert.False(t, selected)
+}
+
+func TestReconcile_KeepsClusterOfMissingPlacement(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+
+	// An onboarded cluster while the Placement is not created yet
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Finalizers: []string{ManagedClusterFinalizer}},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).WithObjects(providerCrd, managedCluster).Build()
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), integrationListKinds,
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace))
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Integration:   IntegrationConfig{Placement: "mtv/migrations"},
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.Error(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.Contains(t, managedCluster.Finalizers, ManagedClusterFinalizer)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.NoError(t, err, "the Provider is kept")
+}
+
+func TestClustersForPlacementDecision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+
+	onboarded := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "onboarded", Finalizers: []string{ManagedClusterFinalizer}},
+	}
+	other := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	reconciler := &ManagedClusterReconciler{
+		Client:      clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(onboarded, other).Build(),
+		Integration: IntegrationConfig{Placement: "mtv/migrations"},
+	}
+
+	reqs := reconciler.clustersForPlacementDecision(context.TODO(),
+		placementDecision("migrations-decision-1", "migrations", "selected"))
+	assert.ElementsMatch(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "selected"}},
+		{NamespacedName: types.NamespacedName{Name: "onboarded"}},
+	}, reqs)
+
+	assert.Empty(t, reconciler.clustersForPlacementDecision(context.TODO(),
+		placementDecision("other-decision-1", "other", "selected")))
+}
+
+func TestReconcile_OffboardsLastClusterOfEmptyPlacement(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = clusterv1beta1.Install(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+
+	// The last onboarded cluster, once the Placement selects no cluster
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Finalizers: []string{ManagedClusterFinalizer}},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster, scheduledPlacementOf("migrations", 0)).Build()
+	// The offboarding lists the Plans targeting the Provider
+	listKinds := map[schema.GroupVersionResource]string{PlansGVR: "PlanList", MigrationsGVR: "MigrationList"}
+	for gvr, kind := range integrationListKinds {
+		listKinds[gvr] = kind
+	}
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		testProvider("test-cluster-mtv", MTVIntegrationsNamespace))
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Integration:   IntegrationConfig{Placement: "mtv/migrations"},
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.NotContains(t, managedCluster.Finalizers, ManagedClusterFinalizer)
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the Provider is removed")
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Decisions of the ManagedCluster update events counted by mtv_integrations_managedcluster_events_total
+const (
+	eventReconciled = "reconciled"
+	eventFiltered   = "filtered"
+)
+
+// Outcomes of the reconciles counted by mtv_integrations_reconciles_total
+const (
+	reconcileOnboard    = "onboard"
+	reconcileOffboard   = "offboard"
+	reconcileSkipped    = "skipped"
+	reconcileCRDMissing = "crd-missing"
+	reconcileOtherShard = "other-shard"
+	reconcilePaused     = "paused"
+)
+
+// managedClusterChanged checks if a ManagedCluster update changes what the reconcile does: its labels and
+// annotations, its deletion, its finalizers, its client configs, its availability or its local-cluster claim.
+// Lease renewals, heartbeats and the status written by the controller itself are filtered out.
+func managedClusterChanged(old, updated *clusterv1.ManagedCluster) bool {
+	return !equality.Semantic.DeepEqual(old.GetLabels(), updated.GetLabels()) ||
+		!equality.Semantic.DeepEqual(old.GetAnnotations(), updated.GetAnnotations()) ||
+		!equality.Semantic.DeepEqual(old.GetDeletionTimestamp(), updated.GetDeletionTimestamp()) ||
+		!equality.Semantic.DeepEqual(old.GetFinalizers(), updated.GetFinalizers()) ||
+		!equality.Semantic.DeepEqual(old.Spec.ManagedClusterClientConfigs, updated.Spec.ManagedClusterClientConfigs) ||
+		availability(old) != availability(updated) ||
+		isLocalCluster(old) != isLocalCluster(updated)
+}
+
+// availability returns the status of the Available condition of the ManagedCluster, the unavailable grace
+// period starts when it changes
+func availability(managedCluster *clusterv1.ManagedCluster) string {
+	condition := meta.FindStatusCondition(managedCluster.Status.Conditions, clusterv1.ManagedClusterConditionAvailable)
+	if condition == nil {
+		return ""
+	}
+	return string(condition.Status)
+}
+
+// managedClusterPredicate only lets the ManagedCluster updates that change what the reconcile does through,
+// and counts the updates it lets through and filters out
+func managedClusterPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, okOld := e.ObjectOld.(*clusterv1.ManagedCluster)
+			updated, okNew := e.ObjectNew.(*clusterv1.ManagedCluster)
+			if !okOld || !okNew || managedClusterChanged(old, updated) {
+				managedClusterEventsTotal.WithLabelValues(eventReconciled).Inc()
+				return true
+			}
+			managedClusterEventsTotal.WithLabelValues(eventFiltered).Inc()
+			return false
+		},
+	}
+}
+
+// clustersForProviderCRD maps a change of the Provider CRD to the selected ManagedClusters, which are
+// onboarded once it is established, and the ManagedClusters with the finalizer, which may need a cleanup
+func (r *ManagedClusterReconciler) clustersForProviderCRD(ctx context.Context, obj client.Object) []reconcile.Request {
+	// Only react to the specific Provider CRD
+	if obj.GetName() != ProviderCRDName {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+	var mcList clusterv1.ManagedClusterList
+	if err := r.List(ctx, &mcList); err != nil {
+		logger.Error(err, "Failed to list ManagedClusters on Provider CRD event")
+		return nil
+	}
+
+	integration := r.integration()
+	var placementClusters map[string]bool
+	if integration.Placement != "" {
+		placement, err := parsePlacement(integration.Placement)
+		if err == nil {
+			placementClusters, err = r.placementClusters(ctx, placement)
+		}
+		if err != nil {
+			logger.Error(err, "Failed to get the clusters of the Placement on Provider CRD event")
+			return nil
+		}
+	}
+
+	var reqs []reconcile.Request
+	for i := range mcList.Items {
+		mc := &mcList.Items[i]
+		selected := integration.Selected(mc)
+		if integration.Placement != "" {
+			selected = placementClusters[mc.Name]
+		}
+		if !selected && !controllerutil.ContainsFinalizer(mc, ManagedClusterFinalizer) {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: mc.Name}})
+	}
+	return reqs
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestManagedClusterChanged(t *testing.T) {
+	base := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "c1",
+			ResourceVersion: "1",
+			Labels:          map[string]string{LabelCNVOperatorInstall: "true"},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://api.c1.example.com:6443"}},
+			LeaseDurationSeconds:        60,
+		},
+		Status: clusterv1.ManagedClusterStatus{
+			Conditions: []metav1.Condition{{Type: clusterv1.ManagedClusterConditionAvailable,
+				Status: metav1.ConditionTrue, Reason: "Available"}},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		update func(*clusterv1.ManagedCluster)
+		want   bool
+	}{
+		{name: "resource version only", update: func(mc *clusterv1.ManagedCluster) { mc.ResourceVersion = "2" }},
+		{
+			name: "status written by the controller",
+			update: func(mc *clusterv1.ManagedCluster) {
+				mc.Status.Conditions = append(mc.Status.Conditions, metav1.Condition{Type: ConditionTypeMTVIntegration,
+					Status: metav1.ConditionTrue, Reason: string(PhaseProviderReady)})
+			},
+		},
+		{
+			name: "heartbeat of the Available condition",
+			update: func(mc *clusterv1.ManagedCluster) {
+				mc.Status.Conditions[0].LastTransitionTime = metav1.Now()
+				mc.Status.Conditions[0].Message = "heartbeat"
+			},
+		},
+		{name: "lease duration", update: func(mc *clusterv1.ManagedCluster) { mc.Spec.LeaseDurationSeconds = 30 }},
+		{
+			name:   "label",
+			update: func(mc *clusterv1.ManagedCluster) { mc.Labels[LabelCNVOperatorInstall] = "false" },
+			want:   true,
+		},
+		{
+			name:   "annotation",
+			update: func(mc *clusterv1.ManagedCluster) { mc.Annotations = map[string]string{RotateTokenKey: "1"} },
+			want:   true,
+		},
+		{
+			name: "deletion",
+			update: func(mc *clusterv1.ManagedCluster) {
+				now := metav1.NewTime(time.Now())
+				mc.DeletionTimestamp = &now
+			},
+			want: true,
+		},
+		{
+			name:   "finalizer",
+			update: func(mc *clusterv1.ManagedCluster) { mc.Finalizers = []string{ManagedClusterFinalizer} },
+			want:   true,
+		},
+		{
+			name: "client config",
+			update: func(mc *clusterv1.ManagedCluster) {
+				mc.Spec.ManagedClusterClientConfigs[0].CABundle = []byte("renewed-ca")
+			},
+			want: true,
+		},
+		{
+			name:   "availability",
+			update: func(mc *clusterv1.ManagedCluster) { mc.Status.Conditions[0].Status = metav1.ConditionUnknown },
+			want:   true,
+		},
+		{
+			name: "local-cluster claim",
+			update: func(mc *clusterv1.ManagedCluster) {
+				mc.Status.ClusterClaims = []clusterv1.ManagedClusterClaim{{Name: LocalClusterClaim, Value: "true"}}
+			},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := base.DeepCopy()
+			tc.update(updated)
+			assert.Equal(t, tc.want, managedClusterChanged(base, updated))
+		})
+	}
+}
+
+func TestManagedClusterPredicate_CountsEvents(t *testing.T) {
+	reconciled := testutil.ToFloat64(managedClusterEventsTotal.WithLabelValues(eventReconciled))
+	filtered := testutil.ToFloat64(managedClusterEventsTotal.WithLabelValues(eventFiltered))
+
+	old := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", ResourceVersion: "1"}}
+	heartbeat := old.DeepCopy()
+	heartbeat.ResourceVersion = "2"
+	labeled := heartbeat.DeepCopy()
+	labeled.Labels = map[string]string{LabelCNVOperatorInstall: "true"}
+
+	predicate := managedClusterPredicate()
+	assert.False(t, predicate.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: heartbeat}))
+	assert.True(t, predicate.Update(event.UpdateEvent{ObjectOld: heartbeat, ObjectNew: labeled}))
+	assert.True(t, predicate.Create(event.CreateEvent{Object: labeled}))
+	assert.True(t, predicate.Delete(event.DeleteEvent{Object: labeled}))
+
+	assert.Equal(t, reconciled+1, testutil.ToFloat64(managedClusterEventsTotal.WithLabelValues(eventReconciled)))
+	assert.Equal(t, filtered+1, testutil.ToFloat64(managedClusterEventsTotal.WithLabelValues(eventFiltered)))
+}
+
+func TestClustersForProviderCRD(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+			Name: "selected", Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+		}},
+		&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{
+			Name: "offboarding", Finalizers: []string{ManagedClusterFinalizer},
+		}},
+		&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "unselected"}},
+	).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+
+	assert.ElementsMatch(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "selected"}},
+		{NamespacedName: types.NamespacedName{Name: "offboarding"}},
+	}, reconciler.clustersForProviderCRD(context.TODO(), providerCrd))
+
+	other := providerCrd.DeepCopy()
+	other.Name = "plans.forklift.konveyor.io"
+	assert.Empty(t, reconciler.clustersForProviderCRD(context.TODO(), other))
+}
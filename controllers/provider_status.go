@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ConditionTypeProviderPrefix prefixes the Provider conditions mirrored to the ManagedCluster status, for
+	// example MTVProviderReady
+	ConditionTypeProviderPrefix = "MTVProvider"
+	// ConnectionRetryKey is the provider secret annotation that records the authentication failure of the
+	// Provider the secret was last resynchronized for
+	ConnectionRetryKey = "mtv-integrations.open-cluster-management.io/connection-retry"
+
+	// providerConditionNotReported is the reason of a mirrored condition the Provider does not report yet
+	providerConditionNotReported = "NotReported"
+)
+
+// mirroredProviderConditions are the Provider conditions copied to the ManagedCluster status
+var mirroredProviderConditions = []string{"Ready", "ConnectionTestSucceeded", "Validated"}
+
+// providerConnectionFailures are the Provider conditions Forklift sets when the connection test fails
+var providerConnectionFailures = []string{"ConnectionTestFailed", "ConnectionFailed"}
+
+// authFailurePattern matches the connection failures caused by a rejected token
+var authFailurePattern = regexp.MustCompile(`(?i)unauthori[sz]ed|forbidden|\b40[13]\b|authenticat`)
+
+// conditionReasonPattern is the format of a metav1.Condition reason
+var conditionReasonPattern = regexp.MustCompile(`^[A-Za-z]([A-Za-z0-9_,:]*[A-Za-z0-9_])?$`)
+
+// providerWatch starts the watch of the Providers once their CRD is established. A watch of a kind that
+// is not installed would keep the controller from starting.
+type providerWatch struct {
+	mu         sync.Mutex
+	controller controller.Controller
+	cache      cache.Cache
+	started    bool
+}
+
+// providerCondition returns the condition of the Provider with the type, or nil when it is not set
+func providerCondition(provider *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(provider.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// conditionTrue checks if the Forklift condition has the True status
+func conditionTrue(condition map[string]interface{}) bool {
+	return condition != nil && condition["status"] == string(metav1.ConditionTrue)
+}
+
+// connectionFailure returns the connection failure condition of the Provider, or nil when its connection
+// test did not fail
+func connectionFailure(provider *unstructured.Unstructured) map[string]interface{} {
+	for _, conditionType := range providerConnectionFailures {
+		if condition := providerCondition(provider, conditionType); conditionTrue(condition) {
+			return condition
+		}
+	}
+	return nil
+}
+
+// authFailure returns the connection failure of the Provider when its token was rejected
+func authFailure(provider *unstructured.Unstructured) map[string]interface{} {
+	failure := connectionFailure(provider)
+	if failure == nil {
+		return nil
+	}
+	reason, _ := failure["reason"].(string)
+	message, _ := failure["message"].(string)
+	if !authFailurePattern.MatchString(reason + " " + message) {
+		return nil
+	}
+	return failure
+}
+
+// mirroredCondition builds the ManagedCluster condition that mirrors the Provider condition. A connection
+// test that failed is reported as a False ConnectionTestSucceeded condition.
+func mirroredCondition(
+	managedCluster *clusterv1.ManagedCluster,
+	provider *unstructured.Unstructured,
+	conditionType string,
+) metav1.Condition {
+	notReported := fmt.Sprintf("The Provider %s/%s has not reported the %s condition",
+		provider.GetNamespace(), provider.GetName(), conditionType)
+	condition := metav1.Condition{
+		Type:               ConditionTypeProviderPrefix + conditionType,
+		Status:             metav1.ConditionUnknown,
+		Reason:             providerConditionNotReported,
+		Message:            notReported,
+		ObservedGeneration: managedCluster.GetGeneration(),
+	}
+
+	mirrored := providerCondition(provider, conditionType)
+	if mirrored == nil && conditionType == "ConnectionTestSucceeded" {
+		if failure := connectionFailure(provider); failure != nil {
+			mirrored = map[string]interface{}{"status": string(metav1.ConditionFalse)}
+			for _, key := range []string{"reason", "message", "lastTransitionTime"} {
+				mirrored[key] = failure[key]
+			}
+		}
+	}
+	if mirrored == nil {
+		return condition
+	}
+
+	switch status, _ := mirrored["status"].(string); strings.ToLower(status) {
+	case "true":
+		condition.Status = metav1.ConditionTrue
+	case "false":
+		condition.Status = metav1.ConditionFalse
+	}
+	condition.Reason = conditionType
+	if reason, _ := mirrored["reason"].(string); conditionReasonPattern.MatchString(reason) {
+		condition.Reason = reason
+	}
+	condition.Message, _ = mirrored["message"].(string)
+	if since, _ := mirrored["lastTransitionTime"].(string); since != "" {
+		_ = condition.LastTransitionTime.UnmarshalQueryParameter(since)
+	}
+	return condition
+}
+
+// setProviderConditions mirrors the Ready, ConnectionTestSucceeded and Validated conditions of the Provider
+// to the ManagedCluster status. Status reporting is best effort like the integration phase.
+func (r *ManagedClusterReconciler) setProviderConditions(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	provider *unstructured.Unstructured,
+) {
+	original := managedCluster.DeepCopy()
+	changed := false
+	for _, conditionType := range mirroredProviderConditions {
+		if meta.SetStatusCondition(&managedCluster.Status.Conditions,
+			mirroredCondition(managedCluster, provider, conditionType)) {
+			changed = true
+		}
+	}
+	if changed {
+		r.patchStatus(ctx, managedCluster, original)
+	}
+}
+
+// removeProviderConditions removes the mirrored Provider conditions from the conditions
+func removeProviderConditions(conditions *[]metav1.Condition) bool {
+	removed := false
+	for _, conditionType := range mirroredProviderConditions {
+		if meta.RemoveStatusCondition(conditions, ConditionTypeProviderPrefix+conditionType) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// retryProviderSecret resynchronizes the provider secret once for each authentication failure of the
+// Provider. The ManagedServiceAccount and its token secret are read again, so a token rotated since the
+// secret was synchronized replaces the rejected one, and annotating the secret makes Forklift test the
+// connection again.
+func (r *ManagedClusterReconciler) retryProviderSecret(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	endpoint *providerEndpoint,
+	provider *unstructured.Unstructured,
+) error {
+	failure := authFailure(provider)
+	if failure == nil {
+		return nil
+	}
+	failedAt, _ := failure["lastTransitionTime"].(string)
+	message, _ := failure["message"].(string)
+
+	integration := r.integration()
+	providerSecret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{
+		Name:      integration.ResourceName(managedCluster.Name),
+		Namespace: integration.Namespace,
+	}, providerSecret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if retriedAt, ok := providerSecret.GetAnnotations()[ConnectionRetryKey]; ok && retriedAt == failedAt {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("The Provider failed to authenticate, resynchronizing the provider secret",
+		"provider", provider.GetName(), "message", message)
+	managedServiceAccount := &auth.ManagedServiceAccount{}
+	if err := r.Get(ctx, types.NamespacedName{
+		Name:      integration.ResourceName(managedCluster.Name),
+		Namespace: managedCluster.Name,
+	}, managedServiceAccount); err != nil {
+		return err
+	}
+	if _, err := r.handleProviderSecrets(ctx, managedCluster, managedServiceAccount,
+		integration.ResourceName(managedCluster.Name), endpoint); err != nil {
+		return err
+	}
+	// The secret may have been updated by the synchronization
+	if err := r.Get(ctx, client.ObjectKeyFromObject(providerSecret), providerSecret); err != nil {
+		return err
+	}
+
+	original := providerSecret.DeepCopy()
+	if providerSecret.Annotations == nil {
+		providerSecret.Annotations = map[string]string{}
+	}
+	providerSecret.Annotations[ConnectionRetryKey] = failedAt
+	if err := r.Patch(ctx, providerSecret, client.MergeFrom(original), client.FieldOwner(FieldManager)); err != nil {
+		return err
+	}
+
+	r.recordEvent(managedCluster, corev1.EventTypeWarning, ReasonProviderAuthFailed, actionOnboard,
+		"The Provider %s/%s failed to authenticate, resynchronized the provider secret: %s",
+		provider.GetNamespace(), provider.GetName(), message)
+	return nil
+}
+
+// watchProviders starts the watch of the Providers in the integration namespace, of the adopted Providers and
+// of the Forklift host provider, once, and then reads the Providers from the cache. It does nothing when the
+// reconciler was not set up with a manager.
+func (r *ManagedClusterReconciler) watchProviders() error {
+	r.providerWatch.mu.Lock()
+	defer r.providerWatch.mu.Unlock()
+	if r.providerWatch.started || r.providerWatch.controller == nil {
+		return nil
+	}
+
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(ProvidersGVR.GroupVersion().WithKind("Provider"))
+	namespace := r.integration().Namespace
+	err := r.providerWatch.controller.Watch(source.Kind(r.providerWatch.cache, client.Object(provider),
+		handler.EnqueueRequestsFromMapFunc(r.clusterForProvider),
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			provider, ok := obj.(*unstructured.Unstructured)
+			_, adopted := obj.GetLabels()[AdoptedForLabel]
+			_, owned := managedClusterOf(obj)
+			return obj.GetNamespace() == namespace || adopted || owned || (ok && r.isHostProvider(provider))
+		})))
+	if err != nil {
+		return err
+	}
+	r.providerWatch.started = true
+	r.resourceCache.start(ProvidersGVR)
+	return nil
+}
+
+// clusterForProvider maps a Provider to the ManagedCluster of its ownership labels, and the host provider to the
+// local cluster. The Providers created or adopted before the ownership labels are mapped by their name or
+// adoption label.
+func (r *ManagedClusterReconciler) clusterForProvider(ctx context.Context, obj client.Object) []reconcile.Request {
+	if provider, ok := obj.(*unstructured.Unstructured); ok && r.isHostProvider(provider) {
+		return r.localClusters(ctx)
+	}
+	if cluster, ok := managedClusterOf(obj); ok {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cluster}}}
+	}
+	if cluster, adopted := obj.GetLabels()[AdoptedForLabel]; adopted {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cluster}}}
+	}
+	integration := r.integration()
+	if obj.GetNamespace() != integration.Namespace {
+		return nil
+	}
+	cluster, ok := integration.ClusterNameForProvider(obj.GetName())
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cluster}}}
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func providerWithConditions(conditions ...map[string]interface{}) *unstructured.Unstructured {
+	provider := testProvider("test-cluster-mtv", MTVIntegrationsNamespace)
+	items := make([]interface{}, 0, len(conditions))
+	for _, condition := range conditions {
+		items = append(items, condition)
+	}
+	_ = unstructured.SetNestedSlice(provider.Object, items, "status", "conditions")
+	return provider
+}
+
+func TestMirroredCondition(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	provider := providerWithConditions(
+		map[string]interface{}{"type": "Ready", "status": "True", "reason": "Completed", "message": "Ready",
+			"lastTransitionTime": "2026-01-02T03:04:05Z"},
+		map[string]interface{}{"type": "Validated", "status": "False", "reason": "not a reason",
+			"message": "The URL is not valid"},
+		map[string]interface{}{"type": "ConnectionTestFailed", "status": "True", "reason": "Tested",
+			"message": "401 Unauthorized"},
+	)
+
+	ready := mirroredCondition(managedCluster, provider, "Ready")
+	assert.Equal(t, "MTVProviderReady", ready.Type)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, "Completed", ready.Reason)
+	assert.Equal(t, "2026-01-02T03:04:05Z", ready.LastTransitionTime.UTC().Format("2006-01-02T15:04:05Z"))
+
+	// A reason that is not valid for a Kubernetes condition is replaced by the condition type
+	validated := mirroredCondition(managedCluster, provider, "Validated")
+	assert.Equal(t, metav1.ConditionFalse, validated.Status)
+	assert.Equal(t, "Validated", validated.Reason)
+	assert.Equal(t, "The URL is not valid", validated.Message)
+
+	// A failed connection test is reported as a False ConnectionTestSucceeded condition
+	connection := mirroredCondition(managedCluster, provider, "ConnectionTestSucceeded")
+	assert.Equal(t, metav1.ConditionFalse, connection.Status)
+	assert.Equal(t, "Tested", connection.Reason)
+	assert.Equal(t, "401 Unauthorized", connection.Message)
+
+	notReported := mirroredCondition(managedCluster, providerWithConditions(), "Ready")
+	assert.Equal(t, metav1.ConditionUnknown, notReported.Status)
+	assert.Equal(t, providerConditionNotReported, notReported.Reason)
+}
+
+func TestAuthFailure(t *testing.T) {
+	assert.Nil(t, authFailure(providerWithConditions()))
+	assert.Nil(t, authFailure(providerWithConditions(map[string]interface{}{
+		"type": "ConnectionTestFailed", "status": "True", "message": "dial tcp: i/o timeout",
+	})))
+	assert.NotNil(t, authFailure(providerWithConditions(map[string]interface{}{
+		"type": "ConnectionTestFailed", "status": "True", "message": "the server has asked for the client to " +
+			"provide credentials: Unauthorized",
+	})))
+	assert.NotNil(t, authFailure(providerWithConditions(map[string]interface{}{
+		"type": "ConnectionFailed", "status": "True", "message": "HTTP 403",
+	})))
+}
+
+func TestClusterForProvider(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{}
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		reconciler.clusterForProvider(context.TODO(), testProvider("test-cluster-mtv", MTVIntegrationsNamespace)))
+	assert.Empty(t, reconciler.clusterForProvider(context.TODO(), testProvider("test-cluster-mtv", "other")))
+	assert.Empty(t, reconciler.clusterForProvider(context.TODO(), testProvider("host", MTVIntegrationsNamespace)))
+}
+
+func TestSetProviderConditions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(managedCluster).Build()
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, managedCluster))
+
+	reconciler.setProviderConditions(context.TODO(), managedCluster, providerWithConditions(
+		map[string]interface{}{"type": "Ready", "status": "True", "reason": "Completed"}))
+
+	updated := &clusterv1.ManagedCluster{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+	for _, conditionType := range []string{"MTVProviderReady", "MTVProviderConnectionTestSucceeded",
+		"MTVProviderValidated"} {
+		assert.NotNil(t, meta.FindStatusCondition(updated.Status.Conditions, conditionType), conditionType)
+	}
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, "MTVProviderReady"))
+
+	// Offboarding removes the mirrored conditions
+	reconciler.removeIntegrationPhase(context.TODO(), updated)
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, updated))
+	assert.Empty(t, updated.Status.Conditions)
+}
+
+func TestRetryProviderSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = auth.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+	endpoint := &providerEndpoint{ClientConfig: clusterv1.ClientConfig{URL: "https://api.example.com:6443"}}
+	// The provider secret still holds the token rotated out since it was synchronized
+	providerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace},
+		Data:       map[string][]byte{"token": []byte("rotated-out")},
+	}
+	managedServiceAccount := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Status: auth.ManagedServiceAccountStatus{
+			TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv-token"},
+		},
+	}
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv-token", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("current"), "ca.crt": []byte("ca")},
+	}
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(providerSecret, managedServiceAccount, tokenSecret).Build()
+	recorder := events.NewFakeRecorder(10)
+	reconciler := &ManagedClusterReconciler{Client: k8sClient, Scheme: scheme, Recorder: recorder}
+
+	// A failure that is not an authentication failure is not retried
+	timeout := providerWithConditions(map[string]interface{}{
+		"type": "ConnectionTestFailed", "status": "True", "message": "i/o timeout",
+		"lastTransitionTime": "2026-01-02T03:04:05Z",
+	})
+	require.NoError(t, reconciler.retryProviderSecret(context.TODO(), managedCluster, endpoint, timeout))
+	assert.Empty(t, drainEvents(recorder))
+
+	unauthorized := providerWithConditions(map[string]interface{}{
+		"type": "ConnectionTestFailed", "status": "True", "message": "401 Unauthorized",
+		"lastTransitionTime": "2026-01-02T03:04:05Z",
+	})
+	require.NoError(t, reconciler.retryProviderSecret(context.TODO(), managedCluster, endpoint, unauthorized))
+	assert.Contains(t, drainEvents(recorder), "Warning "+ReasonProviderAuthFailed)
+	require.NoError(t, k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(providerSecret), providerSecret))
+	assert.Equal(t, "2026-01-02T03:04:05Z", providerSecret.Annotations[ConnectionRetryKey])
+	assert.Equal(t, "current", string(providerSecret.Data["token"]), "the current token replaces the rejected one")
+
+	// The same failure is only retried once
+	require.NoError(t, reconciler.retryProviderSecret(context.TODO(), managedCluster, endpoint, unauthorized))
+	assert.Empty(t, drainEvents(recorder))
+}
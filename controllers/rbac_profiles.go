@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+)
+
+const (
+	// RBACProfileKey selects the RBAC profile for a ManagedCluster. It is read from the
+	// annotations first and then from the labels, so either can be used.
+	RBACProfileKey = "mtv-integrations.open-cluster-management.io/rbac-profile"
+	// RBACClusterRoleKey names the ClusterRole on the managed cluster used by the custom profile.
+	RBACClusterRoleKey = "mtv-integrations.open-cluster-management.io/rbac-clusterrole"
+
+	// RBACProfileClusterAdmin binds the ServiceAccount to cluster-admin. This is the
+	// documented RBAC for the MTV Provider and the default when nothing else is configured.
+	RBACProfileClusterAdmin = "cluster-admin"
+	// RBACProfileMigrationDestination grants only what Forklift needs to migrate VMs onto the cluster.
+	RBACProfileMigrationDestination = "migration-destination"
+	// RBACProfileInventoryReadOnly grants read access for the Forklift inventory only.
+	RBACProfileInventoryReadOnly = "inventory-read-only"
+	// RBACProfileCustom binds the ServiceAccount to a user supplied ClusterRole.
+	RBACProfileCustom = "custom"
+)
+
+var (
+	verbsRead = []string{"get", "list", "watch"}
+	verbsAll  = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+)
+
+// migrationDestinationRules are the rules Forklift uses on a destination OpenShift provider:
+// it creates VMs, DataVolumes and PVCs in the target namespace and runs the conversion pods there.
+var migrationDestinationRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get", "list", "watch", "create"}},
+	{
+		APIGroups: []string{""},
+		Resources: []string{
+			"persistentvolumeclaims", "pods", "pods/log", "services", "configmaps", "secrets",
+			"serviceaccounts", "events",
+		},
+		Verbs: verbsAll,
+	},
+	{APIGroups: []string{""}, Resources: []string{"persistentvolumes", "nodes"}, Verbs: verbsRead},
+	{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: verbsAll},
+	{
+		APIGroups: []string{"kubevirt.io"},
+		Resources: []string{"virtualmachines", "virtualmachineinstances"},
+		Verbs:     verbsAll,
+	},
+	{
+		APIGroups: []string{"instancetype.kubevirt.io"},
+		Resources: []string{
+			"virtualmachineinstancetypes", "virtualmachineclusterinstancetypes",
+			"virtualmachinepreferences", "virtualmachineclusterpreferences",
+		},
+		Verbs: verbsRead,
+	},
+	{APIGroups: []string{"cdi.kubevirt.io"}, Resources: []string{"datavolumes"}, Verbs: verbsAll},
+	{APIGroups: []string{"cdi.kubevirt.io"}, Resources: []string{"datasources"}, Verbs: verbsRead},
+	{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, Verbs: verbsRead},
+	{
+		APIGroups: []string{"snapshot.storage.k8s.io"},
+		Resources: []string{"volumesnapshots", "volumesnapshotclasses"},
+		Verbs:     verbsRead,
+	},
+	{
+		APIGroups: []string{"k8s.cni.cncf.io"},
+		Resources: []string{"network-attachment-definitions"},
+		Verbs:     verbsRead,
+	},
+	{
+		APIGroups: []string{"forklift.konveyor.io"},
+		Resources: []string{
+			"ovirtvolumepopulators", "openstackvolumepopulators", "vspherexcopyvolumepopulators",
+		},
+		Verbs: verbsAll,
+	},
+}
+
+// inventoryReadOnlyRules let the Forklift inventory list the cluster without being able to change it.
+var inventoryReadOnlyRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"namespaces", "nodes", "persistentvolumeclaims", "persistentvolumes"},
+		Verbs:     verbsRead,
+	},
+	{
+		APIGroups: []string{"kubevirt.io"},
+		Resources: []string{"virtualmachines", "virtualmachineinstances"},
+		Verbs:     verbsRead,
+	},
+	{
+		APIGroups: []string{"instancetype.kubevirt.io"},
+		Resources: []string{
+			"virtualmachineinstancetypes", "virtualmachineclusterinstancetypes",
+			"virtualmachinepreferences", "virtualmachineclusterpreferences",
+		},
+		Verbs: verbsRead,
+	},
+	{APIGroups: []string{"cdi.kubevirt.io"}, Resources: []string{"datavolumes", "datasources"}, Verbs: verbsRead},
+	{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, Verbs: verbsRead},
+	{
+		APIGroups: []string{"k8s.cni.cncf.io"},
+		Resources: []string{"network-attachment-definitions"},
+		Verbs:     verbsRead,
+	},
+}
+
+// rbacProfile is the resolved RBAC for a ManagedCluster. Either rules is set, in which case the
+// ClusterPermission generates a ClusterRole from them, or clusterRole names an existing ClusterRole.
+type rbacProfile struct {
+	name        string
+	rules       []rbacv1.PolicyRule
+	clusterRole string
+}
+
+// rbacProfileName returns the profile selected for the ManagedCluster: the annotation wins over the
+// label, and the hub-wide default is used when neither is set.
+func (r *ManagedClusterReconciler) rbacProfileName(managedCluster *clusterv1.ManagedCluster) string {
+	if profile := managedCluster.GetAnnotations()[RBACProfileKey]; profile != "" {
+		return profile
+	}
+	if profile := managedCluster.GetLabels()[RBACProfileKey]; profile != "" {
+		return profile
+	}
+	if r.DefaultRBACProfile != "" {
+		return r.DefaultRBACProfile
+	}
+	return RBACProfileClusterAdmin
+}
+
+// rbacProfileFor resolves the RBAC profile for the ManagedCluster
+func (r *ManagedClusterReconciler) rbacProfileFor(managedCluster *clusterv1.ManagedCluster) (*rbacProfile, error) {
+	clusterRole := managedCluster.GetAnnotations()[RBACClusterRoleKey]
+	if clusterRole == "" {
+		clusterRole = managedCluster.GetLabels()[RBACClusterRoleKey]
+	}
+	if clusterRole == "" {
+		clusterRole = r.DefaultRBACClusterRole
+	}
+	return resolveRBACProfile(r.rbacProfileName(managedCluster), clusterRole)
+}
+
+// resolveRBACProfile maps a profile name to its RBAC. clusterRole is only used by the custom profile.
+func resolveRBACProfile(name, clusterRole string) (*rbacProfile, error) {
+	switch name {
+	case RBACProfileClusterAdmin:
+		return &rbacProfile{name: name, clusterRole: "cluster-admin"}, nil
+	case RBACProfileMigrationDestination:
+		return &rbacProfile{name: name, rules: migrationDestinationRules}, nil
+	case RBACProfileInventoryReadOnly:
+		return &rbacProfile{name: name, rules: inventoryReadOnlyRules}, nil
+	case RBACProfileCustom:
+		if clusterRole == "" {
+			return nil, fmt.Errorf("the %s RBAC profile requires a ClusterRole set with %s", name, RBACClusterRoleKey)
+		}
+		return &rbacProfile{name: name, clusterRole: clusterRole}, nil
+	default:
+		return nil, fmt.Errorf("unknown RBAC profile %q", name)
+	}
+}
+
+// ValidateRBACProfile checks that a hub-wide default profile can be resolved
+func ValidateRBACProfile(name, clusterRole string) error {
+	_, err := resolveRBACProfile(name, clusterRole)
+	return err
+}
+
+// policyRulesPayload converts the rules to the JSON shape used by the unstructured payloads
+func policyRulesPayload(rules []rbacv1.PolicyRule) []interface{} {
+	payload := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		payload = append(payload, map[string]interface{}{
+			"apiGroups": stringsPayload(rule.APIGroups),
+			"resources": stringsPayload(rule.Resources),
+			"verbs":     stringsPayload(rule.Verbs),
+		})
+	}
+	return payload
+}
+
+func stringsPayload(values []string) []interface{} {
+	payload := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		payload = append(payload, v)
+	}
+	return payload
+}
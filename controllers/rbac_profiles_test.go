@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRBACProfileFor(t *testing.T) {
+	cases := []struct {
+		name            string
+		reconciler      *ManagedClusterReconciler
+		annotations     map[string]string
+		labels          map[string]string
+		wantProfile     string
+		wantClusterRole string
+		wantRules       bool
+		wantErr         bool
+	}{
+		{
+			name:            "defaults to cluster-admin",
+			reconciler:      &ManagedClusterReconciler{},
+			wantProfile:     RBACProfileClusterAdmin,
+			wantClusterRole: "cluster-admin",
+		},
+		{
+			name:        "hub-wide default",
+			reconciler:  &ManagedClusterReconciler{DefaultRBACProfile: RBACProfileInventoryReadOnly},
+			wantProfile: RBACProfileInventoryReadOnly,
+			wantRules:   true,
+		},
+		{
+			name:        "label overrides the hub-wide default",
+			reconciler:  &ManagedClusterReconciler{DefaultRBACProfile: RBACProfileInventoryReadOnly},
+			labels:      map[string]string{RBACProfileKey: RBACProfileMigrationDestination},
+			wantProfile: RBACProfileMigrationDestination,
+			wantRules:   true,
+		},
+		{
+			name:        "annotation overrides the label",
+			reconciler:  &ManagedClusterReconciler{},
+			annotations: map[string]string{RBACProfileKey: RBACProfileInventoryReadOnly},
+			labels:      map[string]string{RBACProfileKey: RBACProfileMigrationDestination},
+			wantProfile: RBACProfileInventoryReadOnly,
+			wantRules:   true,
+		},
+		{
+			name:       "custom profile uses the cluster ClusterRole",
+			reconciler: &ManagedClusterReconciler{DefaultRBACClusterRole: "hub-default"},
+			annotations: map[string]string{
+				RBACProfileKey:     RBACProfileCustom,
+				RBACClusterRoleKey: "mtv-provider",
+			},
+			wantProfile:     RBACProfileCustom,
+			wantClusterRole: "mtv-provider",
+		},
+		{
+			name:            "custom profile falls back to the hub-wide ClusterRole",
+			reconciler:      &ManagedClusterReconciler{DefaultRBACClusterRole: "hub-default"},
+			annotations:     map[string]string{RBACProfileKey: RBACProfileCustom},
+			wantProfile:     RBACProfileCustom,
+			wantClusterRole: "hub-default",
+		},
+		{
+			name:        "custom profile without a ClusterRole",
+			reconciler:  &ManagedClusterReconciler{},
+			annotations: map[string]string{RBACProfileKey: RBACProfileCustom},
+			wantErr:     true,
+		},
+		{
+			name:        "unknown profile",
+			reconciler:  &ManagedClusterReconciler{},
+			annotations: map[string]string{RBACProfileKey: "superuser"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			managedCluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "c1", Annotations: tc.annotations, Labels: tc.labels},
+			}
+			profile, err := tc.reconciler.rbacProfileFor(managedCluster)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantProfile, profile.name)
+			assert.Equal(t, tc.wantClusterRole, profile.clusterRole)
+			assert.Equal(t, tc.wantRules, len(profile.rules) > 0)
+		})
+	}
+}
+
+func TestClusterPermissionPayload_RulesProfile(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c1"}}
+	profile, err := resolveRBACProfile(RBACProfileMigrationDestination, "")
+	require.NoError(t, err)
+
+	payload := clusterPermissionPayload(managedCluster, "c1-mtv", "agent-ns", profile)
+
+	spec := payload["spec"].(map[string]interface{})
+	crb := spec["clusterRoleBinding"].(map[string]interface{})
+	assert.NotContains(t, crb, "roleRef", "the binding must point at the generated ClusterRole")
+	rules := spec["clusterRole"].(map[string]interface{})["rules"].([]interface{})
+	assert.Len(t, rules, len(migrationDestinationRules))
+}
+
+func TestReconcile_UpdatesClusterPermissionOnProfileChange(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Labels:     map[string]string{LabelCNVOperatorInstall: "true"},
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithObjects(providerCrd, managedCluster, msa, deployment).Build()
+	dynClient := fake.NewSimpleDynamicClient(scheme)
+	addApplyReactor(dynClient)
+
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	u, err := dynClient.Resource(ClusterPermissionsGVR).Namespace("test-cluster").Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	roleName, _, _ := unstructured.NestedString(u.Object, "spec", "clusterRoleBinding", "roleRef", "name")
+	assert.Equal(t, "cluster-admin", roleName)
+
+	// Switch the cluster to the read-only inventory profile
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	managedCluster.SetAnnotations(map[string]string{RBACProfileKey: RBACProfileInventoryReadOnly})
+	require.NoError(t, k8sClient.Update(context.TODO(), managedCluster))
+
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	u, err = dynClient.Resource(ClusterPermissionsGVR).Namespace("test-cluster").Get(context.TODO(),
+		"test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	_, found, _ := unstructured.NestedMap(u.Object, "spec", "clusterRoleBinding", "roleRef")
+	assert.False(t, found, "roleRef to cluster-admin must be removed")
+	rules, found, _ := unstructured.NestedSlice(u.Object, "spec", "clusterRole", "rules")
+	assert.True(t, found)
+	assert.Len(t, rules, len(inventoryReadOnlyRules))
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resourceCache serves the reads of the integration resources and of the Forklift Plans and Migrations from the
+// informers of the manager cache instead of the API server. A kind is only read from the cache once its informer
+// is started: the ClusterPermission, ManagedServiceAccount and Secret informers are started by their watches,
+// the Provider informer by watchProviders once the Provider CRD is established, and the Plan and Migration
+// informers by the first read once their CRD is established, as asking the cache for a kind that is not
+// installed would block.
+type resourceCache struct {
+	// reader is the manager cache, nil when the reconciler was not set up with a manager
+	reader client.Reader
+
+	mu      sync.RWMutex
+	started map[schema.GroupVersionResource]bool
+}
+
+// start reads the resources of the kind from the cache from now on
+func (c *resourceCache) start(gvr schema.GroupVersionResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started == nil {
+		c.started = map[schema.GroupVersionResource]bool{}
+	}
+	c.started[gvr] = true
+}
+
+// readerFor returns the cache when the informer of the kind is started, and nil otherwise
+func (c *resourceCache) readerFor(gvr schema.GroupVersionResource) client.Reader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.reader == nil || !c.started[gvr] {
+		return nil
+	}
+	return c.reader
+}
+
+// typedResources are the kinds the manager watches as typed objects. They are read from the typed informers and
+// converted, since an unstructured read would start a second informer of the kind.
+var typedResources = map[schema.GroupVersionResource]func() (client.Object, client.ObjectList){
+	ManagedServiceAccountsGVR: func() (client.Object, client.ObjectList) {
+		return &auth.ManagedServiceAccount{}, &auth.ManagedServiceAccountList{}
+	},
+	ProviderSecretGVR: func() (client.Object, client.ObjectList) {
+		return &corev1.Secret{}, &corev1.SecretList{}
+	},
+}
+
+// cachedObject returns an empty object of the kind of the resource, to be read from the cache
+func cachedObject(gvr schema.GroupVersionResource, kind string) client.Object {
+	if newTyped, ok := typedResources[gvr]; ok {
+		obj, _ := newTyped()
+		return obj
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvr.GroupVersion().WithKind(kind))
+	return obj
+}
+
+// cachedList returns an empty list of the kind of the resource, to be read from the cache
+func cachedList(gvr schema.GroupVersionResource, kind string) client.ObjectList {
+	if newTyped, ok := typedResources[gvr]; ok {
+		_, list := newTyped()
+		return list
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvr.GroupVersion().WithKind(kind + "List"))
+	return list
+}
+
+// toUnstructured converts an object read from the cache to the unstructured object the dynamic client returns
+func toUnstructured(
+	gvr schema.GroupVersionResource,
+	kind string,
+	obj runtime.Object,
+) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	// The typed objects of the cache do not carry their kind
+	u.SetGroupVersionKind(gvr.GroupVersion().WithKind(kind))
+	return u, nil
+}
+
+// getResource reads the resource from the cache when the informer of its kind is started, and from the API
+// server otherwise. The object is a copy and can be modified.
+func (r *ManagedClusterReconciler) getResource(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+	namespace, name string,
+) (*unstructured.Unstructured, error) {
+	reader := r.resourceCache.readerFor(gvr)
+	kind, ok := resourceKinds[gvr]
+	if reader == nil || !ok {
+		return r.DynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+
+	obj := cachedObject(gvr, kind)
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+		if errors.IsNotFound(err) {
+			// Keep the error of the API server, the callers only check it with IsNotFound
+			return nil, errors.NewNotFound(gvr.GroupResource(), name)
+		}
+		return nil, err
+	}
+	return toUnstructured(gvr, kind, obj)
+}
+
+// listResources lists the resources of the namespace, or of every namespace when it is empty, from the cache
+// when the informer of their kind is started, and from the API server otherwise
+func (r *ManagedClusterReconciler) listResources(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+	namespace string,
+) (*unstructured.UnstructuredList, error) {
+	reader := r.resourceCache.readerFor(gvr)
+	kind, ok := resourceKinds[gvr]
+	if reader == nil || !ok {
+		return r.DynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	}
+
+	cached := cachedList(gvr, kind)
+	if err := reader.List(ctx, cached, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if list, ok := cached.(*unstructured.UnstructuredList); ok {
+		return list, nil
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvr.GroupVersion().WithKind(kind + "List"))
+	err := meta.EachListItem(cached, func(item runtime.Object) error {
+		obj, err := toUnstructured(gvr, kind, item)
+		if err == nil {
+			list.Items = append(list.Items, *obj)
+		}
+		return err
+	})
+	return list, err
+}
+
+// startWhenEstablished reads the resources of the kind from the cache once their CRD is established, for the
+// kinds the controller does not watch. The cache starts their informer on the first read.
+func (r *ManagedClusterReconciler) startWhenEstablished(ctx context.Context, gvr schema.GroupVersionResource) error {
+	if r.resourceCache.reader == nil || r.resourceCache.readerFor(gvr) != nil {
+		return nil
+	}
+	established, err := r.crdEstablished(ctx, gvr.GroupResource().String())
+	if err != nil {
+		return err
+	}
+	if established {
+		r.resourceCache.start(gvr)
+	}
+	return nil
+}
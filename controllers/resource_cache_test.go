@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	forkliftv1beta1 "github.com/kubev2v/forklift/pkg/apis/forklift/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// readActions returns the verbs of the get and list calls made to the API server
+func readActions(dynClient *fake.FakeDynamicClient) []string {
+	var reads []string
+	for _, action := range dynClient.Actions() {
+		if action.GetVerb() == "get" || action.GetVerb() == "list" {
+			reads = append(reads, action.GetVerb()+" "+action.GetResource().Resource)
+		}
+	}
+	return reads
+}
+
+func cachedResourcesSetup(t *testing.T, objs ...runtime.Object) (*ManagedClusterReconciler, *fake.FakeDynamicClient) {
+	t.Helper()
+	cached := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		cached = append(cached, obj.DeepCopyObject())
+	}
+	reconciler, dynClient, _ := newTestReconciler(t, withDynamicObjects(objs...), withCachedObjects(cached...))
+	return reconciler, dynClient
+}
+
+func TestGetResource_ReadsTheCacheOnceStarted(t *testing.T) {
+	provider := testProvider("c1-mtv", MTVIntegrationsNamespace)
+	reconciler, dynClient := cachedResourcesSetup(t, provider)
+
+	// The Provider informer is not started before the CRD is established
+	_, err := reconciler.getResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace, "c1-mtv")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"get providers"}, readActions(dynClient))
+
+	dynClient.ClearActions()
+	reconciler.resourceCache.start(ProvidersGVR)
+	cached, err := reconciler.getResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace, "c1-mtv")
+	require.NoError(t, err)
+	assert.Equal(t, "c1-mtv", cached.GetName())
+
+	_, err = reconciler.getResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace, "c2-mtv")
+	assert.True(t, errors.IsNotFound(err))
+
+	list, err := reconciler.listResources(context.TODO(), ProvidersGVR, metav1.NamespaceAll)
+	require.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Empty(t, readActions(dynClient), "the cached reads do not reach the API server")
+}
+
+func TestReconcileResource_SteadyStateMakesNoAPIReads(t *testing.T) {
+	payload := map[string]interface{}{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind":       "Provider",
+		"metadata":   map[string]interface{}{"name": "c1-mtv", "namespace": MTVIntegrationsNamespace},
+		"spec":       map[string]interface{}{"type": "openshift", "url": "https://api.c1.example.com:6443"},
+	}
+	live, err := payloadToUnstructured(payload)
+	require.NoError(t, err)
+	reconciler, dynClient := cachedResourcesSetup(t, live)
+	reconciler.resourceCache.start(ProvidersGVR)
+
+	existing, operation, err := reconciler.reconcileResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace,
+		payload)
+	require.NoError(t, err)
+	assert.Equal(t, operationNone, operation)
+	assert.Equal(t, "c1-mtv", existing.GetName())
+	assert.Empty(t, dynClient.Actions(), "an up to date resource is neither read nor written")
+}
+
+func TestReconcileResource_StaleCacheComparesTheLiveObject(t *testing.T) {
+	payload := map[string]interface{}{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind":       "Provider",
+		"metadata":   map[string]interface{}{"name": "c1-mtv", "namespace": MTVIntegrationsNamespace},
+		"spec":       map[string]interface{}{"type": "openshift"},
+	}
+	live, err := payloadToUnstructured(payload)
+	require.NoError(t, err)
+	reconciler, dynClient := cachedResourcesSetup(t)
+	// The Provider was just created and its informer has not seen it yet
+	_, err = dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace).Create(context.TODO(), live,
+		metav1.CreateOptions{})
+	require.NoError(t, err)
+	dynClient.ClearActions()
+	reconciler.resourceCache.start(ProvidersGVR)
+
+	existing, operation, err := reconciler.reconcileResource(context.TODO(), ProvidersGVR, MTVIntegrationsNamespace,
+		payload)
+	require.NoError(t, err)
+	assert.Equal(t, operationNone, operation)
+	assert.Equal(t, "c1-mtv", existing.GetName())
+	assert.Equal(t, []string{"get providers"}, readActions(dynClient))
+}
+
+func TestIntegrationProvider_ReadsTheCache(t *testing.T) {
+	reconciler, dynClient := cachedResourcesSetup(t, testProvider("c1-mtv", MTVIntegrationsNamespace))
+	reconciler.resourceCache.start(ProvidersGVR)
+
+	onboarded := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c1"}}
+	provider, err := reconciler.integrationProvider(context.TODO(), onboarded)
+	require.NoError(t, err)
+	require.NotNil(t, provider)
+	assert.Equal(t, "c1-mtv", provider.GetName())
+
+	pending := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c2"}}
+	provider, err = reconciler.integrationProvider(context.TODO(), pending)
+	require.NoError(t, err)
+	assert.Nil(t, provider)
+	assert.Empty(t, readActions(dynClient))
+}
+
+func TestGetResource_ReadsTheTypedInformers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = auth.AddToScheme(scheme)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "c1-mtv", Namespace: MTVIntegrationsNamespace},
+		Data:       map[string][]byte{"token": []byte("token")},
+	}
+	msa := &auth.ManagedServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "c1-mtv", Namespace: "c1"}}
+	dynClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	reconciler := &ManagedClusterReconciler{
+		DynamicClient: dynClient,
+		resourceCache: resourceCache{
+			reader: clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(secret, msa).Build(),
+		},
+	}
+	reconciler.resourceCache.start(ProviderSecretGVR)
+	reconciler.resourceCache.start(ManagedServiceAccountsGVR)
+
+	cached, err := reconciler.getResource(context.TODO(), ProviderSecretGVR, MTVIntegrationsNamespace, "c1-mtv")
+	require.NoError(t, err)
+	assert.Equal(t, "Secret", cached.GetKind())
+	token, _, _ := unstructured.NestedString(cached.Object, "data", "token")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("token")), token, "the data is encoded like the API")
+
+	list, err := reconciler.listResources(context.TODO(), ManagedServiceAccountsGVR, "c1")
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "ManagedServiceAccount", list.Items[0].GetKind())
+
+	_, err = reconciler.getResource(context.TODO(), ManagedServiceAccountsGVR, "c2", "c2-mtv")
+	assert.True(t, errors.IsNotFound(err))
+	assert.Empty(t, dynClient.Actions(), "the typed informers serve the reads")
+}
+
+func TestPlansInFlightFor_ReadsTheCacheOnceTheCRDsAreEstablished(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = apiextensionsv1.AddToScheme(scheme)
+	plan := unstructuredPlan(t, testPlan("running", "c1-mtv", forkliftv1beta1.ConditionExecuting))
+	dynClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{PlansGVR: "PlanList", MigrationsGVR: "MigrationList"}, plan)
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).Build()
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		DynamicClient: dynClient,
+		resourceCache: resourceCache{
+			reader: clientfake.NewClientBuilder().WithScheme(runtime.NewScheme()).
+				WithRuntimeObjects(plan.DeepCopy()).Build(),
+		},
+	}
+	providers := map[types.NamespacedName]bool{{Name: "c1-mtv", Namespace: MTVIntegrationsNamespace}: true}
+
+	// Without the CRDs the Plans are listed from the API server
+	plans, err := reconciler.plansInFlightFor(context.TODO(), providers)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrations/running"}, plans)
+	assert.Equal(t, []string{"list plans", "list migrations"}, readActions(dynClient))
+
+	for _, name := range []string{"plans.forklift.konveyor.io", "migrations.forklift.konveyor.io"} {
+		crd := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     providerCrd.Status,
+		}
+		require.NoError(t, k8sClient.Create(context.TODO(), crd))
+	}
+	dynClient.ClearActions()
+	plans, err = reconciler.plansInFlightFor(context.TODO(), providers)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrations/running"}, plans)
+	assert.Empty(t, dynClient.Actions(), "the Plans and Migrations are read from the cache")
+}
+
+func TestReconcile_SteadyStateMakesNoDynamicClientCalls(t *testing.T) {
+	host := hostProviderOf("host", "openshift-mtv", "True")
+	reconciler, _ := localClusterSetup(t, host)
+	dynClient := reconciler.DynamicClient.(*fake.FakeDynamicClient)
+	reconciler.resourceCache = resourceCache{reader: clientfake.NewClientBuilder().WithScheme(reconciler.Scheme).
+		WithRuntimeObjects(host.DeepCopy()).Build()}
+	for _, gvr := range []schema.GroupVersionResource{ClusterPermissionsGVR, ManagedServiceAccountsGVR,
+		ProviderSecretGVR, ProvidersGVR} {
+		reconciler.resourceCache.start(gvr)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "local-cluster"}}
+
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	dynClient.ClearActions()
+	result, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Empty(t, dynClient.Actions(), "a steady-state reconcile neither reads nor writes through the API server")
+}
+
+func TestMigrateLegacyResources_SteadyStateMakesNoDynamicClientCalls(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = auth.AddToScheme(scheme)
+	dynClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	reconciler := &ManagedClusterReconciler{
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+		Integration:   IntegrationConfig{Namespace: "migrations", ProviderNamePrefix: "acm-"},
+		resourceCache: resourceCache{reader: clientfake.NewClientBuilder().WithScheme(scheme).Build()},
+	}
+	for _, gvr := range []schema.GroupVersionResource{ClusterPermissionsGVR, ManagedServiceAccountsGVR,
+		ProviderSecretGVR, ProvidersGVR} {
+		reconciler.resourceCache.start(gvr)
+	}
+
+	// The legacy resources were already deleted
+	blocked, err := reconciler.migrateLegacyResources(context.TODO(),
+		&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}})
+	require.NoError(t, err)
+	assert.False(t, blocked)
+	assert.Empty(t, dynClient.Actions(), "the legacy resources are looked up in the cache")
+}
@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultShardCount is the number of hash ranges the ManagedCluster names are split into
+	DefaultShardCount = 16
+	// DefaultShardLeaseDuration is how long a replica holds a shard without renewing its Lease
+	DefaultShardLeaseDuration = 30 * time.Second
+
+	// shardLeaseKey is the label of the sharding Leases, its value is the kind of Lease
+	shardLeaseKey     = "mtv-integrations.open-cluster-management.io/sharding"
+	shardLeaseShard   = "shard"
+	shardLeaseReplica = "replica"
+	// shardLeasePrefix and replicaLeasePrefix are the name prefixes of the Leases of the shards and of the
+	// replicas
+	shardLeasePrefix   = "mtv-integrations-shard-"
+	replicaLeasePrefix = "mtv-integrations-replica-"
+)
+
+// Sharder splits the reconciliation of the ManagedClusters across the controller replicas. The hash of a
+// ManagedCluster name falls in one of Shards ranges, and each range is held by a single replica through a
+// Lease. Every replica also renews a Lease of its own, so the live replicas are known and each one holds a
+// fair share of the shards. Shards are released and claimed as replicas come and go.
+type Sharder struct {
+	// Client writes the Leases and lists the ManagedClusters of a claimed shard
+	Client client.Client
+	// Reader reads the Leases from the API server, nil reads them with Client. A cached client would watch
+	// every Lease of the cluster and renew the Leases from stale copies that conflict.
+	Reader client.Reader
+	// Namespace is where the Leases are created
+	Namespace string
+	// Identity is the unique identity of the replica
+	Identity string
+	// Shards is the number of hash ranges, zero is DefaultShardCount
+	Shards int
+	// LeaseDuration is how long a Lease is valid without renewal, zero is DefaultShardLeaseDuration. The
+	// Leases are renewed every third of it.
+	LeaseDuration time.Duration
+
+	mu sync.RWMutex
+	// owned holds when the Lease of each shard held by the replica was last renewed
+	owned  map[int]time.Time
+	events chan event.GenericEvent
+	once   sync.Once
+}
+
+func (s *Sharder) leaseReader() client.Reader {
+	if s.Reader == nil {
+		return s.Client
+	}
+	return s.Reader
+}
+
+func (s *Sharder) shardCount() int {
+	if s.Shards <= 0 {
+		return DefaultShardCount
+	}
+	return s.Shards
+}
+
+func (s *Sharder) leaseDuration() time.Duration {
+	if s.LeaseDuration <= 0 {
+		return DefaultShardLeaseDuration
+	}
+	return s.LeaseDuration
+}
+
+// clusterEvents returns the channel the ManagedClusters of a claimed shard are sent to, to reconcile them
+func (s *Sharder) clusterEvents() chan event.GenericEvent {
+	s.once.Do(func() { s.events = make(chan event.GenericEvent, 1024) })
+	return s.events
+}
+
+// shardOf returns the shard of the ManagedCluster name, the hash range its FNV-1a hash falls in
+func shardOf(name string, shards int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return int(uint64(hash.Sum32()) * uint64(shards) >> 32)
+}
+
+// Owns checks if the replica reconciles the ManagedCluster. Every ManagedCluster is owned without sharding.
+func (s *Sharder) Owns(name string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	renewed, ok := s.owned[shardOf(name, s.shardCount())]
+	return ok && time.Since(renewed) < s.leaseDuration()
+}
+
+// ownedShards returns the shards held by the replica
+func (s *Sharder) ownedShards() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shards := make([]int, 0, len(s.owned))
+	for shard := range s.owned {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// setOwned records the renewal of a shard held by the replica, nil when the replica does not hold it. It
+// returns true when the shard was not owned before, its ManagedClusters were then left to another replica or
+// to nobody.
+func (s *Sharder) setOwned(shard int, renewed *time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owned == nil {
+		s.owned = map[int]time.Time{}
+	}
+	previous, ok := s.owned[shard]
+	if renewed == nil {
+		delete(s.owned, shard)
+	} else {
+		s.owned[shard] = *renewed
+	}
+	ownedShardsGauge.Set(float64(len(s.owned)))
+	return renewed != nil && (!ok || renewed.Sub(previous) >= s.leaseDuration())
+}
+
+// Start claims and renews the shards of the replica until the manager stops, then releases them
+func (s *Sharder) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("sharder")
+	ticker := time.NewTicker(s.leaseDuration() / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := s.rebalance(ctx, time.Now()); err != nil {
+			logger.Error(err, "Failed to rebalance the shards")
+		}
+
+		select {
+		case <-ctx.Done():
+			// Hand the shards over right away instead of waiting for the Leases to expire
+			s.release(context.Background())
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes every replica claim shards
+func (s *Sharder) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Sharder) shardLeaseName(shard int) string {
+	return fmt.Sprintf("%s%d", shardLeasePrefix, shard)
+}
+
+func (s *Sharder) replicaLeaseName() string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(s.Identity))
+	return fmt.Sprintf("%s%08x", replicaLeasePrefix, hash.Sum32())
+}
+
+// leaseHeld checks if the Lease has a holder that renewed it in time
+func leaseHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || lease.Spec.RenewTime == nil ||
+		lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expiry)
+}
+
+// rebalance renews the Lease of the replica and of its shards, releases the shards above its fair share and
+// claims free shards up to it
+func (s *Sharder) rebalance(ctx context.Context, now time.Time) error {
+	logger := log.FromContext(ctx).WithName("sharder")
+
+	leases := &coordinationv1.LeaseList{}
+	if err := s.leaseReader().List(ctx, leases, client.InNamespace(s.Namespace),
+		client.HasLabels{shardLeaseKey}); err != nil {
+		return err
+	}
+	byName := map[string]*coordinationv1.Lease{}
+	replicas := 1
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		byName[lease.Name] = lease
+		if lease.Labels[shardLeaseKey] == shardLeaseReplica && lease.Name != s.replicaLeaseName() &&
+			leaseHeld(lease, now) {
+			replicas++
+		}
+	}
+
+	if err := s.renew(ctx, byName[s.replicaLeaseName()], s.replicaLeaseName(), shardLeaseReplica, s.Identity,
+		now); err != nil {
+		return fmt.Errorf("failed to renew the Lease of the replica: %w", err)
+	}
+
+	shards := s.shardCount()
+	fairShare := (shards + replicas - 1) / replicas
+	var held, free []int
+	for shard := 0; shard < shards; shard++ {
+		lease := byName[s.shardLeaseName(shard)]
+		switch {
+		case lease != nil && lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == s.Identity:
+			held = append(held, shard)
+		case lease == nil || !leaseHeld(lease, now):
+			free = append(free, shard)
+		default:
+			// Another replica holds the shard
+			s.setOwned(shard, nil)
+		}
+	}
+
+	// Release the shards above the fair share so the other replicas can claim them
+	for len(held) > fairShare {
+		shard := held[len(held)-1]
+		held = held[:len(held)-1]
+		s.setOwned(shard, nil)
+		logger.Info("Releasing a shard", "shard", shard, "replicas", replicas)
+		if err := s.renew(ctx, byName[s.shardLeaseName(shard)], s.shardLeaseName(shard), shardLeaseShard, "",
+			now); err != nil {
+			logger.Error(err, "Failed to release a shard", "shard", shard)
+		}
+	}
+
+	for _, shard := range held {
+		if err := s.renew(ctx, byName[s.shardLeaseName(shard)], s.shardLeaseName(shard), shardLeaseShard,
+			s.Identity, now); err != nil {
+			logger.Error(err, "Failed to renew a shard", "shard", shard)
+			s.setOwned(shard, nil)
+			continue
+		}
+		// The events of the shard were dropped since a failed renewal, reconcile its clusters again
+		if s.setOwned(shard, &now) {
+			s.enqueueShard(ctx, shard)
+		}
+	}
+
+	for _, shard := range free {
+		if len(held) >= fairShare {
+			break
+		}
+		err := s.renew(ctx, byName[s.shardLeaseName(shard)], s.shardLeaseName(shard), shardLeaseShard, s.Identity,
+			now)
+		if errors.IsConflict(err) || errors.IsAlreadyExists(err) {
+			// Another replica claimed it first
+			continue
+		}
+		if err != nil {
+			logger.Error(err, "Failed to claim a shard", "shard", shard)
+			continue
+		}
+		logger.Info("Claimed a shard", "shard", shard, "replicas", replicas)
+		held = append(held, shard)
+		if s.setOwned(shard, &now) {
+			s.enqueueShard(ctx, shard)
+		}
+	}
+	return nil
+}
+
+// renew writes the Lease with the holder, an empty holder releases it. A Lease that does not exist yet is
+// created. The update fails with a conflict when another replica wrote the Lease since it was read.
+func (s *Sharder) renew(
+	ctx context.Context,
+	lease *coordinationv1.Lease,
+	name, kind, holder string,
+	now time.Time,
+) error {
+	exists := lease != nil
+	if !exists {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.Namespace,
+			Labels:    map[string]string{shardLeaseKey: kind},
+		}}
+	}
+
+	renewTime := metav1.NewMicroTime(now)
+	durationSeconds := int32(s.leaseDuration() / time.Second)
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		lease.Spec.AcquireTime = &renewTime
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.RenewTime = &renewTime
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	if holder == "" {
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.RenewTime = nil
+	}
+
+	if !exists {
+		return s.Client.Create(ctx, lease)
+	}
+	return s.Client.Update(ctx, lease)
+}
+
+// enqueueShard reconciles the ManagedClusters of a claimed shard, their events went to the previous holder
+func (s *Sharder) enqueueShard(ctx context.Context, shard int) {
+	managedClusters := &clusterv1.ManagedClusterList{}
+	if err := s.Client.List(ctx, managedClusters); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the ManagedClusters of a claimed shard", "shard", shard)
+		return
+	}
+	for i := range managedClusters.Items {
+		if shardOf(managedClusters.Items[i].Name, s.shardCount()) != shard {
+			continue
+		}
+		select {
+		case s.clusterEvents() <- event.GenericEvent{Object: &managedClusters.Items[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// release gives up the shards and the Lease of the replica
+func (s *Sharder) release(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("sharder")
+	for _, shard := range s.ownedShards() {
+		s.setOwned(shard, nil)
+		lease := &coordinationv1.Lease{}
+		if err := s.leaseReader().Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: s.shardLeaseName(shard)},
+			lease); err != nil {
+			continue
+		}
+		if err := s.renew(ctx, lease, lease.Name, shardLeaseShard, "", time.Now()); err != nil {
+			logger.Error(err, "Failed to release a shard", "shard", shard)
+		}
+	}
+
+	replica := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: s.replicaLeaseName(), Namespace: s.Namespace}}
+	if err := s.Client.Delete(ctx, replica); client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "Failed to delete the Lease of the replica")
+	}
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 400; i++ {
+		shard := shardOf(fmt.Sprintf("cluster-%d", i), 4)
+		require.GreaterOrEqual(t, shard, 0)
+		require.Less(t, shard, 4)
+		counts[shard]++
+	}
+	for shard, count := range counts {
+		assert.Positive(t, count, "shard %d has no cluster", shard)
+	}
+	assert.Equal(t, shardOf("cluster-1", 4), shardOf("cluster-1", 4))
+}
+
+func TestSharder_NilOwnsEverything(t *testing.T) {
+	var sharder *Sharder
+	assert.True(t, sharder.Owns("cluster-1"))
+}
+
+func shardingSetup(t *testing.T, clusters int) client.Client {
+	t.Helper()
+	objects := make([]client.Object, 0, clusters)
+	for i := 0; i < clusters; i++ {
+		objects = append(objects, &clusterv1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cluster-%d", i)},
+		})
+	}
+	reconciler, _, _ := newTestReconciler(t, withObjects(objects...))
+	return reconciler.Client
+}
+
+// assertPartition checks that every cluster is owned by exactly one of the sharders
+func assertPartition(t *testing.T, clusters int, sharders ...*Sharder) {
+	t.Helper()
+	for i := 0; i < clusters; i++ {
+		name := fmt.Sprintf("cluster-%d", i)
+		owners := 0
+		for _, sharder := range sharders {
+			if sharder.Owns(name) {
+				owners++
+			}
+		}
+		assert.Equal(t, 1, owners, "%s has %d owners", name, owners)
+	}
+}
+
+func TestSharder_Rebalance(t *testing.T) {
+	k8sClient := shardingSetup(t, 20)
+	first := &Sharder{Client: k8sClient, Namespace: "mtv", Identity: "replica-a", Shards: 4}
+	second := &Sharder{Client: k8sClient, Namespace: "mtv", Identity: "replica-b", Shards: 4}
+	now := time.Now()
+
+	// A single replica claims every shard and reconciles their clusters
+	require.NoError(t, first.rebalance(context.TODO(), now))
+	assert.Equal(t, []int{0, 1, 2, 3}, first.ownedShards())
+	assert.Len(t, first.clusterEvents(), 20)
+
+	// A new replica is seen, the first one releases half of the shards and the new one claims them
+	require.NoError(t, second.rebalance(context.TODO(), now))
+	assert.Empty(t, second.ownedShards())
+	require.NoError(t, first.rebalance(context.TODO(), now))
+	assert.Equal(t, []int{0, 1}, first.ownedShards())
+	require.NoError(t, second.rebalance(context.TODO(), now))
+	assert.Equal(t, []int{2, 3}, second.ownedShards())
+	assertPartition(t, 20, first, second)
+
+	// The second replica stops, its shards are handed over to the first one
+	second.release(context.TODO())
+	assert.Empty(t, second.ownedShards())
+	require.NoError(t, first.rebalance(context.TODO(), now))
+	assert.Equal(t, []int{0, 1, 2, 3}, first.ownedShards())
+	assertPartition(t, 20, first)
+}
+
+func TestSharder_ClaimsExpiredShards(t *testing.T) {
+	k8sClient := shardingSetup(t, 0)
+	first := &Sharder{Client: k8sClient, Namespace: "mtv", Identity: "replica-a", Shards: 2}
+	second := &Sharder{Client: k8sClient, Namespace: "mtv", Identity: "replica-b", Shards: 2}
+	now := time.Now()
+
+	require.NoError(t, first.rebalance(context.TODO(), now))
+	assert.Equal(t, []int{0, 1}, first.ownedShards())
+
+	// The first replica died without releasing its Leases, they expire
+	later := now.Add(2 * DefaultShardLeaseDuration)
+	require.NoError(t, second.rebalance(context.TODO(), later))
+	assert.Equal(t, []int{0, 1}, second.ownedShards())
+
+	lease := &coordinationv1.Lease{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Namespace: "mtv",
+		Name: "mtv-integrations-shard-0"}, lease))
+	assert.Equal(t, "replica-b", *lease.Spec.HolderIdentity)
+}
+
+func TestReconcile_SkipsClustersOfOtherShards(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{Sharder: &Sharder{Shards: 2}}
+
+	// No shard is held, the cluster is left to the replica that holds its shard
+	result, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "cluster-1"}})
+	require.NoError(t, err)
+	assert.Zero(t, result)
+}
+
+func TestSharder_EnqueuesShardRenewedAfterFailure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = coordinationv1.AddToScheme(scheme)
+	failRenewals := false
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(&clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "cluster-0"}}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if failRenewals && obj.GetLabels()[shardLeaseKey] == shardLeaseShard {
+					return errors.New("etcd timeout")
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		}).Build()
+	sharder := &Sharder{Client: k8sClient, Namespace: "mtv", Identity: "replica-a", Shards: 1}
+	now := time.Now()
+
+	require.NoError(t, sharder.rebalance(context.TODO(), now))
+	require.Len(t, sharder.clusterEvents(), 1)
+	<-sharder.clusterEvents()
+
+	// A renewal of a shard the replica kept does not reconcile its clusters again
+	require.NoError(t, sharder.rebalance(context.TODO(), now.Add(time.Second)))
+	assert.Empty(t, sharder.clusterEvents())
+
+	// The renewal fails, the events of the shard are dropped until it is renewed again
+	failRenewals = true
+	require.NoError(t, sharder.rebalance(context.TODO(), now.Add(2*time.Second)))
+	assert.False(t, sharder.Owns("cluster-0"))
+	failRenewals = false
+	require.NoError(t, sharder.rebalance(context.TODO(), now.Add(3*time.Second)))
+	assert.True(t, sharder.Owns("cluster-0"))
+	assert.Len(t, sharder.clusterEvents(), 1, "the clusters of the shard are reconciled again")
+}
+
+func TestSharder_ReadsLeasesWithReader(t *testing.T) {
+	reader := shardingSetup(t, 0)
+	// The cached client must not be used to read the Leases
+	cached := interceptor.NewClient(reader.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+			opts ...client.GetOption) error {
+			return errors.New("read from the cache")
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*coordinationv1.LeaseList); ok {
+				return errors.New("read from the cache")
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+	sharder := &Sharder{Client: cached, Reader: reader, Namespace: "mtv", Identity: "replica-a", Shards: 2}
+
+	require.NoError(t, sharder.rebalance(context.TODO(), time.Now()))
+	assert.Equal(t, []int{0, 1}, sharder.ownedShards())
+	sharder.release(context.TODO())
+
+	lease := &coordinationv1.Lease{}
+	require.NoError(t, reader.Get(context.TODO(), types.NamespacedName{Namespace: "mtv",
+		Name: "mtv-integrations-shard-0"}, lease))
+	assert.Nil(t, lease.Spec.HolderIdentity, "the shard is released")
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ConditionTypeMTVIntegration is the ManagedCluster condition that reports how far the onboarding of
+// the cluster as an MTV Provider got. The reason is the current phase, the message holds the last error.
+const ConditionTypeMTVIntegration = "MTVIntegration"
+
+// IntegrationPhase is a step of the onboarding or offboarding of a ManagedCluster
+type IntegrationPhase string
+
+const (
+	PhaseCRDMissing            IntegrationPhase = "CRDMissing"
+	PhaseFinalizerAdded        IntegrationPhase = "FinalizerAdded"
+	PhaseServiceAccountPending IntegrationPhase = "ServiceAccountPending"
+	PhaseTokenReady            IntegrationPhase = "TokenReady"
+	PhasePermissionApplied     IntegrationPhase = "PermissionApplied"
+	PhaseEndpointUnavailable   IntegrationPhase = "EndpointUnavailable"
+	PhaseSecretSynced          IntegrationPhase = "SecretSynced"
+	PhaseProviderCreated       IntegrationPhase = "ProviderCreated"
+	PhaseProviderReady         IntegrationPhase = "ProviderReady"
+	PhaseCleaningUp            IntegrationPhase = "CleaningUp"
+	PhaseClusterUnavailable    IntegrationPhase = "ClusterUnavailable"
+	PhaseCleanupBlocked        IntegrationPhase = "CleanupBlocked"
+	PhaseHostProviderMissing   IntegrationPhase = "HostProviderMissing"
+	PhasePaused                IntegrationPhase = "Paused"
+)
+
+// phaseMessages describe each phase when it was reached without an error
+var phaseMessages = map[IntegrationPhase]string{
+	PhaseCRDMissing:            "The " + ProviderCRDName + " CRD is not established",
+	PhaseFinalizerAdded:        "The cleanup finalizer was added",
+	PhaseServiceAccountPending: "Waiting for the ManagedServiceAccount token",
+	PhaseTokenReady:            "The ManagedServiceAccount token is ready",
+	PhasePermissionApplied:     "The ClusterPermission is applied",
+	PhaseEndpointUnavailable:   "No API server URL is available for the Provider",
+	PhaseSecretSynced:          "The provider secret is synchronized",
+	PhaseProviderCreated:       "The Provider is created and waiting to become ready",
+	PhaseProviderReady:         "The Provider is ready",
+	PhaseCleaningUp:            "The MTV resources of the cluster are being removed",
+	PhaseClusterUnavailable:    "The ManagedCluster is unavailable, the Provider is degraded",
+	PhaseCleanupBlocked:        "The cleanup waits for the in-flight Plans of the Provider",
+	PhaseHostProviderMissing:   "The local cluster waits for the Forklift host provider",
+	PhasePaused:                "The integration is paused by the " + PausedKey + " annotation",
+}
+
+// integrationStatus collects the outcome of the steps of a reconcile so it is written once at the end
+type integrationStatus struct {
+	phase IntegrationPhase
+	err   error
+}
+
+// record sets the phase reached by a step and the error it failed with, if any
+func (s *integrationStatus) record(phase IntegrationPhase, err error) {
+	s.phase = phase
+	s.err = err
+}
+
+// phaseErrorMessage describes the error a phase ended with. A missing endpoint, an unavailable cluster or a
+// blocked cleanup is not a failed step but a state of the ManagedCluster, so the error is the whole message.
+func phaseErrorMessage(phase IntegrationPhase, err error) string {
+	if phase == PhaseEndpointUnavailable || phase == PhaseClusterUnavailable || phase == PhaseCleanupBlocked {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s failed: %v", phase, err)
+}
+
+// integrationCondition builds the ManagedCluster condition for the phase
+func integrationCondition(
+	managedCluster *clusterv1.ManagedCluster,
+	phase IntegrationPhase,
+	err error,
+) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeMTVIntegration,
+		Status:             metav1.ConditionUnknown,
+		Reason:             string(phase),
+		Message:            phaseMessages[phase],
+		ObservedGeneration: managedCluster.GetGeneration(),
+	}
+
+	switch {
+	case err != nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Message = phaseErrorMessage(phase, err)
+	case phase == PhaseProviderReady:
+		condition.Status = metav1.ConditionTrue
+	case phase == PhaseCRDMissing:
+		condition.Status = metav1.ConditionFalse
+	}
+	return condition
+}
+
+// setIntegrationPhase writes the phase to the ManagedCluster status. Status reporting is best effort:
+// failures are logged and do not fail the reconcile.
+func (r *ManagedClusterReconciler) setIntegrationPhase(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	phase IntegrationPhase,
+	err error,
+) {
+	condition := integrationCondition(managedCluster, phase, err)
+
+	existing := meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return
+	}
+
+	// Every phase change gets its own timestamp, not only changes of the condition status
+	condition.LastTransitionTime = metav1.Now()
+	if existing != nil && existing.Reason == condition.Reason && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+
+	original := managedCluster.DeepCopy()
+	meta.RemoveStatusCondition(&managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	managedCluster.Status.Conditions = append(managedCluster.Status.Conditions, condition)
+	r.patchStatus(ctx, managedCluster, original)
+}
+
+// removeIntegrationPhase removes the MTV condition and the mirrored Provider conditions once the cluster is
+// no longer onboarded
+func (r *ManagedClusterReconciler) removeIntegrationPhase(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) {
+	original := managedCluster.DeepCopy()
+	removedPhase := meta.RemoveStatusCondition(&managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+	if !removeProviderConditions(&managedCluster.Status.Conditions) && !removedPhase {
+		return
+	}
+	r.patchStatus(ctx, managedCluster, original)
+}
+
+// patchStatus patches the ManagedCluster status. The optimistic lock keeps the conditions written by
+// the registration agent from being overwritten with a stale list.
+func (r *ManagedClusterReconciler) patchStatus(
+	ctx context.Context,
+	managedCluster, original *clusterv1.ManagedCluster,
+) {
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	if err := r.Status().Patch(ctx, managedCluster, patch); err != nil && !errors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "Failed to update the ManagedCluster status",
+			"condition", ConditionTypeMTVIntegration)
+	}
+}
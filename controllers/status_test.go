@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func integrationConditionOf(t *testing.T, k8sClient client.Client, name string) *metav1.Condition {
+	t.Helper()
+	managedCluster := &clusterv1.ManagedCluster{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, managedCluster))
+	return meta.FindStatusCondition(managedCluster.Status.Conditions, ConditionTypeMTVIntegration)
+}
+
+func TestIntegrationCondition(t *testing.T) {
+	managedCluster := &clusterv1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "c1", Generation: 3}}
+
+	cases := []struct {
+		name       string
+		phase      IntegrationPhase
+		err        error
+		wantStatus metav1.ConditionStatus
+		wantMsg    string
+	}{
+		{"in progress", PhaseSecretSynced, nil, metav1.ConditionUnknown, phaseMessages[PhaseSecretSynced]},
+		{"ready", PhaseProviderReady, nil, metav1.ConditionTrue, phaseMessages[PhaseProviderReady]},
+		{"CRD missing", PhaseCRDMissing, nil, metav1.ConditionFalse, phaseMessages[PhaseCRDMissing]},
+		{"step failed", PhasePermissionApplied, errors.New("forbidden"), metav1.ConditionFalse,
+			"PermissionApplied failed: forbidden"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			condition := integrationCondition(managedCluster, tc.phase, tc.err)
+			assert.Equal(t, ConditionTypeMTVIntegration, condition.Type)
+			assert.Equal(t, string(tc.phase), condition.Reason)
+			assert.Equal(t, tc.wantStatus, condition.Status)
+			assert.Equal(t, tc.wantMsg, condition.Message)
+			assert.Equal(t, int64(3), condition.ObservedGeneration)
+		})
+	}
+}
+
+func TestReconcile_ReportsIntegrationPhases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = auth.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = addonv1alpha1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Labels:     map[string]string{LabelCNVOperatorInstall: "true"},
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token"), "ca.crt": []byte("test-ca")},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&appsv1.Deployment{}, deploymentNameIndex, indexDeploymentName).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(providerCrd, managedCluster, secret, deployment).Build()
+	dynClient := fake.NewSimpleDynamicClient(scheme)
+	addApplyReactor(dynClient)
+
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: dynClient,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+
+	// The ManagedServiceAccount has no token yet
+	_, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	condition := integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseServiceAccountPending), condition.Reason)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+
+	msa := &auth.ManagedServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}, msa))
+	msa.Status.TokenSecretRef = &auth.SecretRef{Name: "test-cluster-mtv"}
+	require.NoError(t, k8sClient.Update(context.TODO(), msa))
+
+	// The Provider is created but Forklift has not validated it yet
+	result, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, ProviderReadyCheckInterval, result.RequeueAfter)
+	condition = integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseProviderCreated), condition.Reason)
+
+	providers := dynClient.Resource(ProvidersGVR).Namespace(MTVIntegrationsNamespace)
+	provider, err := providers.Get(context.TODO(), "test-cluster-mtv", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedSlice(provider.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "True"},
+	}, "status", "conditions"))
+	_, err = providers.Update(context.TODO(), provider, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	result, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	condition = integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseProviderReady), condition.Reason)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+}
+
+func TestReconcile_ReportsCRDMissing(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-cluster",
+			Labels: map[string]string{LabelCNVOperatorInstall: "true"},
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(managedCluster).Build()
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClient(scheme),
+	}
+
+	_, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+
+	condition := integrationConditionOf(t, k8sClient, "test-cluster")
+	require.NotNil(t, condition)
+	assert.Equal(t, string(PhaseCRDMissing), condition.Reason)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+}
+
+func TestCleanupManagedClusterResources_RemovesCondition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clusterv1.Install(scheme)
+
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-cluster",
+			Finalizers: []string{ManagedClusterFinalizer},
+		},
+		Status: clusterv1.ManagedClusterStatus{
+			Conditions: []metav1.Condition{{
+				Type:               ConditionTypeMTVIntegration,
+				Status:             metav1.ConditionTrue,
+				Reason:             string(PhaseProviderReady),
+				LastTransitionTime: metav1.Now(),
+			}},
+		},
+	}
+
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&clusterv1.ManagedCluster{}).
+		WithObjects(managedCluster).Build()
+	reconciler := &ManagedClusterReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		DynamicClient: fake.NewSimpleDynamicClientWithCustomListKinds(scheme, integrationListKinds),
+	}
+
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-cluster"}, managedCluster))
+	require.NoError(t, reconciler.cleanupManagedClusterResources(context.TODO(), managedCluster))
+
+	assert.Nil(t, integrationConditionOf(t, k8sClient, "test-cluster"))
+}
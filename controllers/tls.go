@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// CABundleKey is the ManagedCluster annotation that references a ConfigMap or Secret in the cluster
+	// namespace holding a CA bundle that is added to the provider secret CA, such as the corporate CA of
+	// an ingress in front of the API server. The value is configmap:<name> or secret:<name>.
+	CABundleKey = "mtv-integrations.open-cluster-management.io/ca-bundle"
+	// CABundleDataKey is the ManagedCluster annotation that names the key of the CA bundle in the
+	// referenced object. It defaults to ca-bundle.crt for a ConfigMap and ca.crt for a Secret.
+	CABundleDataKey = "mtv-integrations.open-cluster-management.io/ca-bundle-key"
+	// InsecureSkipVerifyKey is the ManagedCluster annotation that disables the TLS verification of the
+	// Provider when set to "true". It is only honored when the hub allows it.
+	InsecureSkipVerifyKey = "mtv-integrations.open-cluster-management.io/insecure-skip-tls-verify"
+
+	caBundleKindConfigMap = "configmap"
+	caBundleKindSecret    = "secret"
+
+	// caBundleIndex indexes ManagedClusters by the object their CA bundle annotation references
+	caBundleIndex = "mtv-integrations.caBundleRef"
+)
+
+// caBundleRef is a parsed CABundleKey annotation
+type caBundleRef struct {
+	kind      string
+	namespace string
+	name      string
+	key       string
+}
+
+// indexKey is the value of the CA bundle index for the referenced object
+func (ref *caBundleRef) indexKey() string {
+	return caBundleIndexKey(ref.kind, ref.namespace, ref.name)
+}
+
+func caBundleIndexKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// parseCABundleRef parses the CA bundle annotation of the ManagedCluster, it returns nil when it is not set.
+// The referenced object is always in the cluster namespace, so the annotation cannot expose other data.
+func parseCABundleRef(managedCluster *clusterv1.ManagedCluster) (*caBundleRef, error) {
+	value := managedCluster.GetAnnotations()[CABundleKey]
+	if value == "" {
+		return nil, nil
+	}
+
+	kind, name, found := strings.Cut(value, ":")
+	if !found || name == "" || (kind != caBundleKindConfigMap && kind != caBundleKindSecret) {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be configmap:<name> or secret:<name>",
+			CABundleKey, value)
+	}
+
+	ref := &caBundleRef{kind: kind, namespace: managedCluster.Name, name: name}
+	ref.key = managedCluster.GetAnnotations()[CABundleDataKey]
+	if ref.key == "" {
+		ref.key = "ca-bundle.crt"
+		if kind == caBundleKindSecret {
+			ref.key = "ca.crt"
+		}
+	}
+	return ref, nil
+}
+
+// additionalCABundle returns the CA bundle referenced by the ManagedCluster, or nil when it references none
+func (r *ManagedClusterReconciler) additionalCABundle(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) ([]byte, error) {
+	ref, err := parseCABundleRef(managedCluster)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+
+	var caBundle []byte
+	namespacedName := types.NamespacedName{Namespace: ref.namespace, Name: ref.name}
+	if ref.kind == caBundleKindConfigMap {
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, namespacedName, configMap); err != nil {
+			return nil, fmt.Errorf("failed to get the CA bundle ConfigMap %s: %w", namespacedName, err)
+		}
+		caBundle = []byte(configMap.Data[ref.key])
+	} else {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, namespacedName, secret); err != nil {
+			return nil, fmt.Errorf("failed to get the CA bundle Secret %s: %w", namespacedName, err)
+		}
+		caBundle = secret.Data[ref.key]
+	}
+
+	if len(bytes.TrimSpace(caBundle)) == 0 {
+		return nil, fmt.Errorf("the CA bundle %s %s has no %s key", ref.kind, namespacedName, ref.key)
+	}
+	return caBundle, nil
+}
+
+// appendCABundle layers the additional CA bundle onto the CA certificates
+func appendCABundle(caCert, caBundle []byte) []byte {
+	if len(caBundle) == 0 {
+		return caCert
+	}
+	if len(caCert) == 0 {
+		return caBundle
+	}
+
+	combined := append([]byte{}, caCert...)
+	if !bytes.HasSuffix(combined, []byte("\n")) {
+		combined = append(combined, '\n')
+	}
+	return append(combined, caBundle...)
+}
+
+// insecureSkipVerify checks if the Provider of the ManagedCluster skips the TLS verification. A request
+// that the hub does not allow is ignored with a Warning event.
+func (r *ManagedClusterReconciler) insecureSkipVerify(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+) bool {
+	if managedCluster.GetAnnotations()[InsecureSkipVerifyKey] != "true" {
+		return false
+	}
+	if !r.AllowInsecureSkipVerify {
+		log.FromContext(ctx).Info("Ignoring the request to skip the TLS verification, it is not allowed on this hub",
+			"annotation", InsecureSkipVerifyKey)
+		r.recordEvent(managedCluster, corev1.EventTypeWarning, ReasonInsecureSkipVerifyDenied, actionOnboard,
+			"The %s annotation is ignored, skipping the TLS verification is not allowed on this hub",
+			InsecureSkipVerifyKey)
+		return false
+	}
+	return true
+}
+
+// indexCABundleRef is the index function of caBundleIndex
+func indexCABundleRef(obj client.Object) []string {
+	managedCluster, ok := obj.(*clusterv1.ManagedCluster)
+	if !ok {
+		return nil
+	}
+	ref, err := parseCABundleRef(managedCluster)
+	if err != nil || ref == nil {
+		return nil
+	}
+	return []string{ref.indexKey()}
+}
+
+// clustersForCABundle returns a map function that enqueues the ManagedClusters referencing the CA bundle
+// object of the kind
+func (r *ManagedClusterReconciler) clustersForCABundle(
+	kind string,
+) func(ctx context.Context, obj client.Object) []reconcile.Request {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var mcList clusterv1.ManagedClusterList
+		if err := r.List(ctx, &mcList, client.MatchingFields{
+			caBundleIndex: caBundleIndexKey(kind, obj.GetNamespace(), obj.GetName()),
+		}); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list the ManagedClusters referencing the CA bundle",
+				"kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName())
+			return nil
+		}
+
+		reqs := make([]reconcile.Request, 0, len(mcList.Items))
+		for _, mc := range mcList.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: mc.Name}})
+		}
+		return reqs
+	}
+}
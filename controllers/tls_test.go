@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestParseCABundleRef(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        *caBundleRef
+		wantErr     bool
+	}{
+		{name: "not set"},
+		{
+			name:        "ConfigMap with the default key",
+			annotations: map[string]string{CABundleKey: "configmap:corporate-ca"},
+			want:        &caBundleRef{kind: "configmap", namespace: "c1", name: "corporate-ca", key: "ca-bundle.crt"},
+		},
+		{
+			name:        "Secret with a custom key",
+			annotations: map[string]string{CABundleKey: "secret:corporate-ca", CABundleDataKey: "tls.crt"},
+			want:        &caBundleRef{kind: "secret", namespace: "c1", name: "corporate-ca", key: "tls.crt"},
+		},
+		{
+			name:        "unknown kind",
+			annotations: map[string]string{CABundleKey: "route:corporate-ca"},
+			wantErr:     true,
+		},
+		{
+			name:        "missing name",
+			annotations: map[string]string{CABundleKey: "configmap:"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			managedCluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "c1", Annotations: tc.annotations},
+			}
+			ref, err := parseCABundleRef(managedCluster)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ref)
+		})
+	}
+}
+
+func TestAppendCABundle(t *testing.T) {
+	assert.Equal(t, "cluster-ca", string(appendCABundle([]byte("cluster-ca"), nil)))
+	assert.Equal(t, "corporate-ca", string(appendCABundle(nil, []byte("corporate-ca"))))
+	assert.Equal(t, "cluster-ca\ncorporate-ca", string(appendCABundle([]byte("cluster-ca"), []byte("corporate-ca"))))
+	assert.Equal(t, "cluster-ca\ncorporate-ca",
+		string(appendCABundle([]byte("cluster-ca\n"), []byte("corporate-ca"))))
+}
+
+func tlsTestSetup(
+	t *testing.T,
+	annotations map[string]string,
+	objects ...client.Object,
+) (*ManagedClusterReconciler, *clusterv1.ManagedCluster, *auth.ManagedServiceAccount) {
+	t.Helper()
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Annotations: annotations},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Status:     auth.ManagedServiceAccountStatus{TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv"}},
+	}
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Data:       map[string][]byte{"token": []byte("test-token"), "ca.crt": []byte("cluster-ca")},
+	}
+
+	reconciler, _, _ := newTestReconciler(t, withObjects(append(objects, managedCluster, tokenSecret)...))
+	return reconciler, managedCluster, msa
+}
+
+func providerSecretOf(t *testing.T, reconciler *ManagedClusterReconciler) *corev1.Secret {
+	t.Helper()
+	providerSecret := &corev1.Secret{}
+	require.NoError(t, reconciler.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, providerSecret))
+	return providerSecret
+}
+
+func TestHandleProviderSecrets_LayersReferencedCABundle(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "corporate-ca", Namespace: "test-cluster"},
+		Data:       map[string]string{"ca-bundle.crt": "corporate-ca"},
+	}
+	reconciler, managedCluster, msa := tlsTestSetup(t,
+		map[string]string{CABundleKey: "configmap:corporate-ca"}, configMap)
+	endpoint := &providerEndpoint{ClientConfig: clusterv1.ClientConfig{URL: "https://api.example.com:6443"}}
+
+	_, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-ca\ncorporate-ca", string(providerSecretOf(t, reconciler).Data["cacert"]))
+
+	// A change of the referenced CA is copied to the provider secret
+	configMap.Data["ca-bundle.crt"] = "renewed-corporate-ca"
+	require.NoError(t, reconciler.Update(context.TODO(), configMap))
+	_, err = reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-ca\nrenewed-corporate-ca", string(providerSecretOf(t, reconciler).Data["cacert"]))
+
+	// The ConfigMap change enqueues the cluster referencing it
+	reqs := reconciler.clustersForCABundle(caBundleKindConfigMap)(context.TODO(), configMap)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}}, reqs)
+	assert.Empty(t, reconciler.clustersForCABundle(caBundleKindSecret)(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "corporate-ca", Namespace: "test-cluster"},
+	}))
+}
+
+func TestHandleProviderSecrets_MissingCABundle(t *testing.T) {
+	reconciler, managedCluster, msa := tlsTestSetup(t, map[string]string{CABundleKey: "secret:corporate-ca"})
+
+	_, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv",
+		&providerEndpoint{ClientConfig: clusterv1.ClientConfig{URL: "https://api.example.com:6443"}})
+	assert.ErrorContains(t, err, "corporate-ca")
+}
+
+func TestHandleProviderSecrets_InsecureSkipVerify(t *testing.T) {
+	endpoint := &providerEndpoint{ClientConfig: clusterv1.ClientConfig{URL: "https://api.example.com:6443"}}
+	annotations := map[string]string{InsecureSkipVerifyKey: "true"}
+
+	t.Run("denied by the hub", func(t *testing.T) {
+		reconciler, managedCluster, msa := tlsTestSetup(t, annotations)
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+
+		_, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+		require.NoError(t, err)
+		assert.Equal(t, "false", string(providerSecretOf(t, reconciler).Data["insecureSkipVerify"]))
+		assert.Contains(t, drainEvents(recorder), "Warning "+ReasonInsecureSkipVerifyDenied)
+	})
+
+	t.Run("allowed by the hub", func(t *testing.T) {
+		reconciler, managedCluster, msa := tlsTestSetup(t, annotations)
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		reconciler.AllowInsecureSkipVerify = true
+
+		_, err := reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+		require.NoError(t, err)
+		assert.Equal(t, "true", string(providerSecretOf(t, reconciler).Data["insecureSkipVerify"]))
+		assert.Contains(t, drainEvents(recorder), "Warning "+ReasonInsecureSkipVerifyEnabled)
+
+		// Removing the annotation enables the verification again
+		managedCluster.SetAnnotations(nil)
+		_, err = reconciler.handleProviderSecrets(context.TODO(), managedCluster, msa, "test-cluster-mtv", endpoint)
+		require.NoError(t, err)
+		assert.Equal(t, "false", string(providerSecretOf(t, reconciler).Data["insecureSkipVerify"]))
+		assert.Equal(t, []string{"Normal " + ReasonInsecureSkipVerifyDisabled}, drainEvents(recorder))
+	})
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// TokenValidityKey is the ManagedCluster annotation that sets the validity of the ManagedServiceAccount
+	// token of the cluster, as a duration such as 30m or 24h
+	TokenValidityKey = "mtv-integrations.open-cluster-management.io/token-validity"
+	// RotateTokenKey is the ManagedCluster annotation that requests an immediate token rotation. Any new
+	// value, such as a timestamp or an incident number, requests a new rotation.
+	RotateTokenKey = "mtv-integrations.open-cluster-management.io/rotate-token"
+	// TokenRotatedKey is the ManagedCluster annotation set to the RotateTokenKey value once the rotated
+	// token reached the provider secret
+	TokenRotatedKey = "mtv-integrations.open-cluster-management.io/token-rotated"
+
+	// DefaultTokenValidity is the token validity when neither the hub nor the cluster sets one
+	DefaultTokenValidity = time.Hour
+	// MinTokenValidity is the shortest validity a token can be requested with
+	MinTokenValidity = 10 * time.Minute
+
+	// rotationRequestKey is the ManagedServiceAccount annotation that holds the rotation request in progress
+	rotationRequestKey = "mtv-integrations.open-cluster-management.io/rotation-request"
+	// tokenRevokedAtKey is the ManagedCluster annotation that holds when the ManagedServiceAccount was deleted
+	// to revoke its token for the pending rotation request
+	tokenRevokedAtKey = "mtv-integrations.open-cluster-management.io/token-revoked-at"
+
+	// TokenRevocationDelay is the time the ManagedServiceAccount agent gets to delete the ServiceAccount of a
+	// deleted ManagedServiceAccount, which revokes its tokens, before the ManagedServiceAccount is created again
+	TokenRevocationDelay = 30 * time.Second
+)
+
+// ValidateTokenValidity checks that a token validity can be requested
+func ValidateTokenValidity(validity time.Duration) error {
+	if validity != 0 && validity < MinTokenValidity {
+		return fmt.Errorf("invalid token validity %s: must be at least %s", validity, MinTokenValidity)
+	}
+	return nil
+}
+
+// tokenValidity returns the token validity of the ManagedCluster, from its annotation or the hub-wide default
+func (r *ManagedClusterReconciler) tokenValidity(managedCluster *clusterv1.ManagedCluster) (time.Duration, error) {
+	validity := r.TokenValidity
+	if value, ok := managedCluster.GetAnnotations()[TokenValidityKey]; ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s annotation %q: %w", TokenValidityKey, value, err)
+		}
+		validity = parsed
+	}
+	if err := ValidateTokenValidity(validity); err != nil {
+		return 0, err
+	}
+	if validity == 0 {
+		validity = DefaultTokenValidity
+	}
+	return validity, nil
+}
+
+// reconcileTokenValidity updates the rotation validity of an existing ManagedServiceAccount. The new
+// validity applies from the next token the ManagedServiceAccount agent requests.
+func (r *ManagedClusterReconciler) reconcileTokenValidity(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+	validity time.Duration,
+) error {
+	if managedServiceAccount.Spec.Rotation.Validity.Duration == validity {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Updating the token validity", "ManagedServiceAccount", managedServiceAccount.Name,
+		"validity", validity)
+	original := managedServiceAccount.DeepCopy()
+	managedServiceAccount.Spec.Rotation.Validity = metav1.Duration{Duration: validity}
+	if err := r.Patch(ctx, managedServiceAccount, client.MergeFrom(original),
+		client.FieldOwner(FieldManager)); err != nil {
+		return err
+	}
+
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonTokenValidityUpdated, actionOnboard,
+		"Set the token validity of the ManagedServiceAccount %s/%s to %s", managedServiceAccount.Namespace,
+		managedServiceAccount.Name, validity)
+	return nil
+}
+
+// pendingRotation returns the rotation request of the ManagedCluster that is not completed yet
+func pendingRotation(managedCluster *clusterv1.ManagedCluster) string {
+	request := managedCluster.GetAnnotations()[RotateTokenKey]
+	if request == "" || managedCluster.GetAnnotations()[TokenRotatedKey] == request {
+		return ""
+	}
+	return request
+}
+
+// rotationInProgress checks if the ManagedServiceAccount was created again for the pending rotation request
+func rotationInProgress(
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+) bool {
+	request := pendingRotation(managedCluster)
+	return request != "" && managedServiceAccount.GetAnnotations()[rotationRequestKey] == request
+}
+
+// startTokenRotation deletes the ManagedServiceAccount for a new rotation request. The agent then deletes its
+// ServiceAccount on the cluster, which revokes every token issued for it, and the ManagedServiceAccount is
+// created again after TokenRevocationDelay so the agent issues a new token. It returns true when a rotation was
+// started.
+func (r *ManagedClusterReconciler) startTokenRotation(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+) (bool, error) {
+	request := pendingRotation(managedCluster)
+	// A token that is not issued yet is new anyway, the rotation starts once it is
+	if request == "" || rotationInProgress(managedCluster, managedServiceAccount) ||
+		!tokenSecretReady(managedServiceAccount) {
+		return false, nil
+	}
+
+	// Record the revocation first, so the ManagedServiceAccount is not created again before the agent deleted
+	// the ServiceAccount
+	original := managedCluster.DeepCopy()
+	if managedCluster.Annotations == nil {
+		managedCluster.Annotations = map[string]string{}
+	}
+	managedCluster.Annotations[tokenRevokedAtKey] = time.Now().UTC().Format(time.RFC3339)
+	if err := r.Patch(ctx, managedCluster, client.MergeFrom(original)); err != nil {
+		return false, err
+	}
+
+	log.FromContext(ctx).Info("AUDIT: Deleting the ManagedServiceAccount to revoke its token", "request", request,
+		"ManagedServiceAccount", managedServiceAccount.Name, "namespace", managedServiceAccount.Namespace)
+	if err := r.Delete(ctx, managedServiceAccount, client.Preconditions{UID: &managedServiceAccount.UID}); err != nil &&
+		!errors.IsNotFound(err) {
+		return false, err
+	}
+
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonTokenRotationStarted, actionOnboard,
+		"Deleted the ManagedServiceAccount %s/%s to revoke its token for the rotation request %s, it is created "+
+			"again in %s", managedServiceAccount.Namespace, managedServiceAccount.Name, request, TokenRevocationDelay)
+	return true, nil
+}
+
+// tokenRevocationWait returns how long the ManagedServiceAccount deleted for the pending rotation request still
+// waits before it is created again
+func tokenRevocationWait(managedCluster *clusterv1.ManagedCluster, now time.Time) time.Duration {
+	revokedAt, ok := managedCluster.GetAnnotations()[tokenRevokedAtKey]
+	if !ok || pendingRotation(managedCluster) == "" {
+		return 0
+	}
+	revoked, err := time.Parse(time.RFC3339, revokedAt)
+	if err != nil {
+		return 0
+	}
+	return max(revoked.Add(TokenRevocationDelay).Sub(now), 0)
+}
+
+// completeTokenRotation reports the rotation request as completed once the provider secret holds the first token
+// of the ManagedServiceAccount created again for it
+func (r *ManagedClusterReconciler) completeTokenRotation(
+	ctx context.Context,
+	managedCluster *clusterv1.ManagedCluster,
+	managedServiceAccount *auth.ManagedServiceAccount,
+) error {
+	if !rotationInProgress(managedCluster, managedServiceAccount) || !tokenSecretReady(managedServiceAccount) {
+		return nil
+	}
+
+	request := pendingRotation(managedCluster)
+	original := managedCluster.DeepCopy()
+	managedCluster.Annotations[TokenRotatedKey] = request
+	delete(managedCluster.Annotations, tokenRevokedAtKey)
+	if err := r.Patch(ctx, managedCluster, client.MergeFrom(original)); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("AUDIT: Token rotation completed", "request", request)
+	r.recordEvent(managedCluster, corev1.EventTypeNormal, ReasonTokenRotationCompleted, actionOnboard,
+		"The rotated token of the rotation request %s reached the provider secret", request)
+	return nil
+}
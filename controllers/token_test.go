@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	clusterv1 "open-cluster-management.io/api/cluster/v1"
+	auth "open-cluster-management.io/managed-serviceaccount/apis/authentication/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestTokenValidity(t *testing.T) {
+	cases := []struct {
+		name         string
+		hubValidity  time.Duration
+		annotations  map[string]string
+		wantValidity time.Duration
+		wantErr      bool
+	}{
+		{name: "default", wantValidity: DefaultTokenValidity},
+		{name: "hub-wide", hubValidity: 24 * time.Hour, wantValidity: 24 * time.Hour},
+		{
+			name:         "cluster annotation",
+			hubValidity:  24 * time.Hour,
+			annotations:  map[string]string{TokenValidityKey: "30m"},
+			wantValidity: 30 * time.Minute,
+		},
+		{name: "invalid annotation", annotations: map[string]string{TokenValidityKey: "a day"}, wantErr: true},
+		{name: "too short", annotations: map[string]string{TokenValidityKey: "1m"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reconciler := &ManagedClusterReconciler{TokenValidity: tc.hubValidity}
+			managedCluster := &clusterv1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "c1", Annotations: tc.annotations},
+			}
+			validity, err := reconciler.tokenValidity(managedCluster)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantValidity, validity)
+		})
+	}
+}
+
+func tokenTestSetup(
+	t *testing.T,
+	annotations map[string]string,
+) (*ManagedClusterReconciler, client.Client, *events.FakeRecorder) {
+	t.Helper()
+	managedCluster := &clusterv1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-cluster",
+			Labels:      map[string]string{LabelCNVOperatorInstall: "true"},
+			Annotations: annotations,
+			Finalizers:  []string{ManagedClusterFinalizer},
+		},
+		Spec: clusterv1.ManagedClusterSpec{
+			ManagedClusterClientConfigs: []clusterv1.ClientConfig{{URL: "https://example.com"}},
+		},
+	}
+	msa := &auth.ManagedServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster"},
+		Spec: auth.ManagedServiceAccountSpec{
+			Rotation: auth.ManagedServiceAccountRotation{Enabled: true, Validity: metav1.Duration{Duration: time.Hour}},
+		},
+		Status: auth.ManagedServiceAccountStatus{TokenSecretRef: &auth.SecretRef{Name: "test-cluster-mtv"}},
+	}
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-mtv", Namespace: "test-cluster", UID: "old-secret"},
+		Data:       map[string][]byte{"token": []byte("old-token"), "ca.crt": []byte("test-ca")},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "managed-serviceaccount-addon-agent",
+			Namespace: "open-cluster-management-agent-addon",
+		},
+	}
+
+	reconciler, _, recorder := newTestReconciler(t, withObjects(managedCluster, msa, tokenSecret, deployment),
+		withStatusSubresource(&clusterv1.ManagedCluster{}))
+	return reconciler, reconciler.Client, recorder
+}
+
+func TestReconcile_UpdatesTokenValidity(t *testing.T) {
+	reconciler, k8sClient, recorder := tokenTestSetup(t, map[string]string{TokenValidityKey: "30m"})
+
+	_, err := reconciler.Reconcile(context.TODO(),
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}})
+	require.NoError(t, err)
+
+	msa := &auth.ManagedServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}, msa))
+	assert.Equal(t, 30*time.Minute, msa.Spec.Rotation.Validity.Duration)
+	assert.Contains(t, drainEvents(recorder), "Normal "+ReasonTokenValidityUpdated)
+}
+
+func TestReconcile_RotatesTokenOnDemand(t *testing.T) {
+	reconciler, k8sClient, recorder := tokenTestSetup(t, map[string]string{RotateTokenKey: "incident-42"})
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-cluster"}}
+	msaName := types.NamespacedName{Name: "test-cluster-mtv", Namespace: "test-cluster"}
+
+	// The ManagedServiceAccount is deleted so the agent deletes the ServiceAccount and revokes its tokens
+	result, err := reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, TokenRevocationDelay, result.RequeueAfter)
+	err = k8sClient.Get(context.TODO(), msaName, &auth.ManagedServiceAccount{})
+	assert.True(t, apierrors.IsNotFound(err), "expected the ManagedServiceAccount to be deleted, got err=%v", err)
+	assert.Contains(t, drainEvents(recorder), "Normal "+ReasonTokenRotationStarted)
+
+	// The ManagedServiceAccount is not created again before the agent had the time to delete the ServiceAccount
+	result, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Positive(t, result.RequeueAfter)
+	assert.LessOrEqual(t, result.RequeueAfter, TokenRevocationDelay)
+	err = k8sClient.Get(context.TODO(), msaName, &auth.ManagedServiceAccount{})
+	assert.True(t, apierrors.IsNotFound(err), "expected the ManagedServiceAccount to wait, got err=%v", err)
+
+	managedCluster := &clusterv1.ManagedCluster{}
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	managedCluster.Annotations[tokenRevokedAtKey] = time.Now().Add(-TokenRevocationDelay).UTC().Format(time.RFC3339)
+	require.NoError(t, k8sClient.Update(context.TODO(), managedCluster))
+	result, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, TokenWaitDuration, result.RequeueAfter)
+	msa := &auth.ManagedServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.TODO(), msaName, msa))
+	assert.Equal(t, "incident-42", msa.Annotations[rotationRequestKey])
+
+	// The agent issues the token of the new ServiceAccount
+	msa.Status.TokenSecretRef = &auth.SecretRef{Name: msaName.Name}
+	require.NoError(t, k8sClient.Update(context.TODO(), msa))
+	tokenSecret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.TODO(), msaName, tokenSecret))
+	tokenSecret.Data["token"] = []byte("new-token")
+	require.NoError(t, k8sClient.Update(context.TODO(), tokenSecret))
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	providerSecret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.TODO(),
+		types.NamespacedName{Name: "test-cluster-mtv", Namespace: MTVIntegrationsNamespace}, providerSecret))
+	assert.Equal(t, "new-token", string(providerSecret.Data["token"]))
+	require.NoError(t, k8sClient.Get(context.TODO(), req.NamespacedName, managedCluster))
+	assert.Equal(t, "incident-42", managedCluster.Annotations[TokenRotatedKey])
+	assert.NotContains(t, managedCluster.Annotations, tokenRevokedAtKey)
+	assert.Contains(t, drainEvents(recorder), "Normal "+ReasonTokenRotationCompleted)
+
+	// A completed request does not rotate the token again
+	_, err = reconciler.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.TODO(), msaName, &auth.ManagedServiceAccount{}))
+}
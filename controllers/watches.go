@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// clusterPermissionObject is the object the ClusterPermissions are watched with. The controller does not
+// use the ClusterPermission types, so it is watched as unstructured.
+func clusterPermissionObject() *unstructured.Unstructured {
+	clusterPermission := &unstructured.Unstructured{}
+	clusterPermission.SetGroupVersionKind(ClusterPermissionsGVR.GroupVersion().WithKind("ClusterPermission"))
+	return clusterPermission
+}
+
+// clusterForProviderSecret maps a provider secret to the ManagedCluster of its ownership labels. The secrets of
+// the integration namespace without the provider secret labels are not provider secrets, and a provider secret
+// created before the ownership labels is mapped by its name.
+func (r *ManagedClusterReconciler) clusterForProviderSecret(
+	_ context.Context,
+	obj client.Object,
+) []reconcile.Request {
+	integration := r.integration()
+	if obj.GetNamespace() != integration.Namespace {
+		return nil
+	}
+	for key, value := range providerSecretLabels() {
+		if obj.GetLabels()[key] != value {
+			return nil
+		}
+	}
+	cluster, ok := managedClusterOf(obj)
+	if !ok {
+		cluster, ok = integration.ClusterNameForProvider(obj.GetName())
+	}
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cluster}}}
+}
+
+// clusterForAgentAddon maps the managed-serviceaccount and cluster-proxy ManagedClusterAddOns to the
+// ManagedCluster of their namespace
+func clusterForAgentAddon(_ context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetName() != msaAddonName && obj.GetName() != clusterProxyAddonName {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetNamespace()}}}
+}
+
+// clusterForClusterPermission maps the ClusterPermission of the integration to the ManagedCluster of its
+// ownership labels, or of its namespace when it was created before the ownership labels
+func (r *ManagedClusterReconciler) clusterForClusterPermission(
+	_ context.Context,
+	obj client.Object,
+) []reconcile.Request {
+	if cluster, ok := managedClusterOf(obj); ok {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: cluster}}}
+	}
+	if obj.GetName() != r.integration().ResourceName(obj.GetNamespace()) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetNamespace()}}}
+}
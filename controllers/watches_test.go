@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestClusterForProviderSecret(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{}
+	secret := func(name, namespace string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+	}
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		reconciler.clusterForProviderSecret(context.TODO(),
+			secret("test-cluster-mtv", MTVIntegrationsNamespace, providerSecretLabels())))
+	assert.Empty(t, reconciler.clusterForProviderSecret(context.TODO(),
+		secret("test-cluster-mtv", MTVIntegrationsNamespace, nil)), "not a provider secret")
+	assert.Empty(t, reconciler.clusterForProviderSecret(context.TODO(),
+		secret("test-cluster-mtv", "test-cluster", providerSecretLabels())), "not in the integration namespace")
+
+	reconciler.Integration = IntegrationConfig{Namespace: "migrations", ProviderNamePrefix: "acm-"}
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		reconciler.clusterForProviderSecret(context.TODO(),
+			secret("acm-test-cluster", "migrations", providerSecretLabels())))
+}
+
+func TestClusterForClusterPermission(t *testing.T) {
+	reconciler := &ManagedClusterReconciler{}
+	obj := clusterPermissionObject()
+	obj.SetName("test-cluster-mtv")
+	obj.SetNamespace("test-cluster")
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		reconciler.clusterForClusterPermission(context.TODO(), obj))
+
+	// A ClusterPermission of another component in the cluster namespace
+	obj.SetName("other-permission")
+	assert.Empty(t, reconciler.clusterForClusterPermission(context.TODO(), obj))
+}
+
+func TestClusterForAgentAddon(t *testing.T) {
+	addon := &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: metav1.ObjectMeta{Name: msaAddonName, Namespace: "test-cluster"},
+	}
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		clusterForAgentAddon(context.TODO(), addon))
+
+	// The availability of the cluster-proxy addon selects the connection mode
+	addon.Name = clusterProxyAddonName
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test-cluster"}}},
+		clusterForAgentAddon(context.TODO(), addon))
+
+	addon.Name = "work-manager"
+	assert.Empty(t, clusterForAgentAddon(context.TODO(), addon))
+}
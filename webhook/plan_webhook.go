@@ -20,6 +20,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/stolostron/mtv-integrations/controllers"
 )
 
 const (
@@ -33,13 +35,25 @@ const (
 	envUserPermissionNames = "MTV_USERPERMISSION_NAMES"
 )
 
+var providerGVK = schema.GroupVersionKind{
+	Group:   "forklift.konveyor.io",
+	Version: "v1beta1",
+	Kind:    "Provider",
+}
+
 var userPermissionGVR = schema.GroupVersionResource{
 	Group:    "clusterview.open-cluster-management.io",
 	Version:  "v1alpha1",
 	Resource: "userpermissions",
 }
 
-func ValidateWebhook(c client.Client, config rest.Config) *webhook.Admission {
+// ValidateWebhook validates the Plans targeting a Provider created or adopted by the controller, found by its
+// ownership labels, or named with the naming of the integration or the default naming
+func ValidateWebhook(
+	c client.Client,
+	config rest.Config,
+	integration controllers.IntegrationConfig,
+) *webhook.Admission {
 	return &webhook.Admission{
 		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
 			log := ctrl.LoggerFrom(ctx).WithValues("operation", req.Operation, "user", req.UserInfo.Username)
@@ -56,15 +70,34 @@ func ValidateWebhook(c client.Client, config rest.Config) *webhook.Admission {
 
 				targetNamespace := plan.Spec.TargetNamespace
 				destinationName := plan.Spec.Provider.Destination.Name
+				destinationNamespace := plan.Spec.Provider.Destination.Namespace
+				if destinationNamespace == "" {
+					destinationNamespace = req.Namespace
+				}
 
-				if !strings.HasSuffix(destinationName, "-mtv") {
-					log.Info("Skipping Plan validation: destination provider does not have MTV-managed suffix",
+				// The Providers created or adopted by the controller name their cluster in their labels
+				clusterName, managed, err := providerCluster(ctx, c, destinationNamespace, destinationName)
+				if err != nil {
+					log.Error(err, "Failed to get the destination Provider", "destinationProvider", destinationName)
+					return webhook.Denied("Failed to read the destination provider")
+				}
+				if !managed {
+					// A Provider created before the ownership labels, or not created yet, is named after its cluster.
+					// A Provider created before the naming of the integration changed keeps the default naming.
+					for _, naming := range []controllers.IntegrationConfig{integration,
+						controllers.DefaultIntegrationConfig()} {
+						if clusterName, managed = naming.ClusterNameForProvider(destinationName); managed {
+							destinationNamespace = naming.Namespace
+							break
+						}
+					}
+				}
+				if !managed {
+					log.Info("Skipping Plan validation: destination provider is neither MTV-managed nor adopted",
 						"destinationProvider", destinationName)
 					return webhook.Allowed("Plan validation skipped: destination provider is not managed by MTV controller")
 				}
 
-				clusterName := strings.TrimSuffix(destinationName, "-mtv")
-
 				log = log.WithValues("cluster", clusterName, "namespace", targetNamespace)
 
 				config.Impersonate = rest.ImpersonationConfig{
@@ -90,6 +123,16 @@ func ValidateWebhook(c client.Client, config rest.Config) *webhook.Admission {
 						"the target namespace: %s in cluster: %s",
 						targetNamespace, clusterName))
 				}
+
+				// Only new Plans are held back, an existing Plan must still be archived, canceled or have its
+				// finalizers removed while its cluster is unavailable
+				if req.Operation != v1.Create {
+					return webhook.Allowed("Plan validation passed")
+				}
+				if since := unavailableSince(ctx, c, destinationNamespace, destinationName); since != "" {
+					return webhook.Denied(fmt.Sprintf("The cluster: %s is unavailable since %s, "+
+						"wait for it to be available again before creating Plans targeting it", clusterName, since))
+				}
 			}
 
 			return webhook.Allowed("Plan validation passed")
@@ -97,6 +140,37 @@ func ValidateWebhook(c client.Client, config rest.Config) *webhook.Admission {
 	}
 }
 
+// providerCluster returns the ManagedCluster the destination Provider was created or adopted for from its
+// labels, and false when the Provider does not exist or carries neither the ownership nor the adoption labels
+func providerCluster(ctx context.Context, c client.Client, namespace, providerName string) (string, bool, error) {
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: providerName}, provider); err != nil {
+		return "", false, client.IgnoreNotFound(err)
+	}
+	labels := provider.GetLabels()
+	if cluster := labels[controllers.ManagedClusterNameKey]; cluster != "" &&
+		labels[controllers.ManagedByKey] == controllers.FieldManager {
+		return cluster, true, nil
+	}
+	cluster := labels[controllers.AdoptedForLabel]
+	return cluster, cluster != "", nil
+}
+
+// unavailableSince returns when the cluster of the destination Provider became unavailable, or an empty
+// string when the Provider is not marked as degraded. A Provider that cannot be read does not block the Plan.
+func unavailableSince(ctx context.Context, c client.Client, namespace, providerName string) string {
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: providerName}, provider); err != nil {
+		if !errors.IsNotFound(err) {
+			ctrl.LoggerFrom(ctx).Error(err, "Failed to get the destination Provider", "provider", providerName)
+		}
+		return ""
+	}
+	return provider.GetAnnotations()[controllers.ClusterUnavailableKey]
+}
+
 func rawToPlan(rawExt runtime.RawExtension) (*v1beta1.Plan, error) {
 	if len(rawExt.Raw) == 0 {
 		return nil, nil
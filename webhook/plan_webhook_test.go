@@ -2,14 +2,23 @@ package webhook
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/rest"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/stolostron/mtv-integrations/controllers"
 )
 
 func TestBindingNamespacesCoverTarget(t *testing.T) {
@@ -269,6 +278,173 @@ func TestRawToPlan(t *testing.T) {
 	})
 }
 
+func TestValidateWebhook_ProviderNaming(t *testing.T) {
+	t.Parallel()
+	raw := []byte(`{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind": "Plan",
+		"spec": {
+			"targetNamespace": "openshift-mtv",
+			"provider": {"source": {"name": "src"}, "destination": {"name": "cluster-mtv"}}
+		}
+	}`)
+	req := webhook.AdmissionRequest{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+
+	// With a prefix naming, the destination still follows the default naming of the Providers created before
+	// the naming changed, the access of the user to the cluster is checked
+	integration := controllers.IntegrationConfig{ProviderNamePrefix: "acm-"}.WithDefaults()
+	c := clientfake.NewClientBuilder().Build()
+	resp := ValidateWebhook(c, rest.Config{}, integration).Handle(context.TODO(), req)
+	assert.False(t, resp.Allowed)
+	assert.NotContains(t, resp.Result.Message, "Plan validation skipped")
+
+	// A destination that follows neither naming is not a Provider of the integration
+	raw = []byte(`{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind": "Plan",
+		"spec": {
+			"targetNamespace": "openshift-mtv",
+			"provider": {"source": {"name": "src"}, "destination": {"name": "cluster"}}
+		}
+	}`)
+	req.Object = runtime.RawExtension{Raw: raw}
+	resp = ValidateWebhook(c, rest.Config{}, integration).Handle(context.TODO(), req)
+	assert.True(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "Plan validation skipped")
+}
+
+func TestValidateWebhook_AdoptedProvider(t *testing.T) {
+	t.Parallel()
+	raw := []byte(`{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind": "Plan",
+		"spec": {
+			"targetNamespace": "openshift-mtv",
+			"provider": {"source": {"name": "src"}, "destination": {"name": "team-cluster", "namespace": "team-a"}}
+		}
+	}`)
+	req := webhook.AdmissionRequest{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: "team-a",
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	provider.SetNamespace("team-a")
+	provider.SetName("team-cluster")
+	integration := controllers.DefaultIntegrationConfig()
+
+	// A Provider created by hand is not validated
+	c := clientfake.NewClientBuilder().WithObjects(provider.DeepCopy()).Build()
+	resp := ValidateWebhook(c, rest.Config{}, integration).Handle(context.TODO(), req)
+	assert.True(t, resp.Allowed)
+
+	// Once adopted it holds the token of the cluster, the access of the user to the cluster is checked
+	provider.SetLabels(map[string]string{controllers.AdoptedForLabel: "cluster"})
+	c = clientfake.NewClientBuilder().WithObjects(provider).Build()
+	cluster, adopted, err := providerCluster(context.TODO(), c, "team-a", "team-cluster")
+	require.NoError(t, err)
+	assert.True(t, adopted)
+	assert.Equal(t, "cluster", cluster)
+	resp = ValidateWebhook(c, rest.Config{}, integration).Handle(context.TODO(), req)
+	assert.False(t, resp.Allowed)
+	assert.NotContains(t, resp.Result.Message, "Plan validation skipped")
+}
+
+func TestProviderCluster(t *testing.T) {
+	t.Parallel()
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	provider.SetNamespace("mtv-integrations")
+	provider.SetName("edge-mtv-mtv")
+	c := clientfake.NewClientBuilder().WithObjects(provider.DeepCopy()).Build()
+
+	// A Provider without labels is left to the naming of the integration
+	_, managed, err := providerCluster(context.TODO(), c, "mtv-integrations", "edge-mtv-mtv")
+	require.NoError(t, err)
+	assert.False(t, managed)
+	_, managed, err = providerCluster(context.TODO(), c, "mtv-integrations", "missing-mtv")
+	require.NoError(t, err)
+	assert.False(t, managed)
+
+	// The ownership labels name the cluster, whatever the name of the Provider
+	provider.SetLabels(map[string]string{
+		controllers.ManagedByKey:          controllers.FieldManager,
+		controllers.ManagedClusterNameKey: "edge-mtv",
+	})
+	c = clientfake.NewClientBuilder().WithObjects(provider).Build()
+	cluster, managed, err := providerCluster(context.TODO(), c, "mtv-integrations", "edge-mtv-mtv")
+	require.NoError(t, err)
+	assert.True(t, managed)
+	assert.Equal(t, "edge-mtv", cluster)
+}
+
+func TestUnavailableSince(t *testing.T) {
+	t.Parallel()
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	provider.SetNamespace("mtv-integrations")
+	provider.SetName("cluster-mtv")
+	provider.SetAnnotations(map[string]string{controllers.ClusterUnavailableKey: "2026-01-02T03:04:05Z"})
+	c := clientfake.NewClientBuilder().WithObjects(provider).Build()
+
+	assert.Equal(t, "2026-01-02T03:04:05Z", unavailableSince(context.TODO(), c, "mtv-integrations", "cluster-mtv"))
+	assert.Empty(t, unavailableSince(context.TODO(), c, "mtv-integrations", "other-mtv"))
+}
+
+func TestValidateWebhook_UnavailableCluster(t *testing.T) {
+	t.Setenv(envUserPermissionNames, "")
+	// The API server grants the user the access to the target namespace of the cluster
+	permission := userPermissionObject(userPermissionManagedClusterAdmin, []map[string]interface{}{
+		{"cluster": "cluster", "namespaces": []interface{}{"*"}},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/userpermissions/"+userPermissionManagedClusterAdmin) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := permission.MarshalJSON()
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(providerGVK)
+	provider.SetNamespace("mtv-integrations")
+	provider.SetName("cluster-mtv")
+	provider.SetAnnotations(map[string]string{controllers.ClusterUnavailableKey: "2026-01-02T03:04:05Z"})
+	c := clientfake.NewClientBuilder().WithObjects(provider).Build()
+	raw := []byte(`{
+		"apiVersion": "forklift.konveyor.io/v1beta1",
+		"kind": "Plan",
+		"spec": {
+			"targetNamespace": "vms",
+			"archived": true,
+			"provider": {"source": {"name": "src"}, "destination": {"name": "cluster-mtv"}}
+		}
+	}`)
+	handler := ValidateWebhook(c, rest.Config{Host: server.URL}, controllers.DefaultIntegrationConfig())
+
+	// A new Plan targeting the unavailable cluster is denied
+	resp := handler.Handle(context.TODO(), webhook.AdmissionRequest{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "is unavailable since 2026-01-02T03:04:05Z")
+
+	// An existing Plan can still be archived
+	resp = handler.Handle(context.TODO(), webhook.AdmissionRequest{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	assert.True(t, resp.Allowed, resp.Result.Message)
+}
+
 // userPermissionObject builds a cluster-scoped UserPermission unstructured for the fake dynamic client.
 func userPermissionObject(name string, bindings []map[string]interface{}) *unstructured.Unstructured {
 	u := &unstructured.Unstructured{}